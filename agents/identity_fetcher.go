@@ -5,15 +5,21 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"idenauthgo/artifacts"
 	"idenauthgo/checks"
+	"idenauthgo/datasource"
+	"idenauthgo/merkle"
+	"idenauthgo/sessionscan"
+	"idenauthgo/wlformat"
 )
 
 // defaultIndexerURL is the local rolling indexer endpoint that returns the
@@ -22,35 +28,11 @@ const defaultIndexerURL = "http://localhost:8080/api/whitelist/current"
 
 // GetCurrentEpoch queries the node for the current epoch.
 func GetCurrentEpoch(nodeURL, apiKey string) (int, error) {
-	reqData := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_epoch",
-		"params":  []interface{}{},
-		"id":      1,
-	}
-	if apiKey != "" {
-		reqData["key"] = apiKey
-	}
-	body, _ := json.Marshal(reqData)
-	req, _ := http.NewRequest("POST", nodeURL, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	info, err := datasource.NewNodeSource(nodeURL, apiKey).GetEpoch()
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-	var rpcResp struct {
-		Result struct {
-			StartBlock     int    `json:"startBlock"`
-			Epoch          int    `json:"epoch"`
-			NextValidation string `json:"nextValidation"`
-			CurrentPeriod  string `json:"currentPeriod"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
-		return 0, err
-	}
-	return rpcResp.Result.Epoch, nil
+	return info.Epoch, nil
 }
 
 type Identity struct {
@@ -76,6 +58,9 @@ type FetcherConfig struct {
 	NodeURL         string `json:"node_url"`
 	ApiKey          string `json:"api_key"`
 	IndexerURL      string `json:"indexer_url"`
+	// MerkleScheme is the scheme of the root written into the whitelist
+	// header; empty selects the legacy scheme.
+	MerkleScheme string `json:"merkle_scheme"`
 }
 
 // Load fetcher configuration from JSON file, print debug info
@@ -94,41 +79,16 @@ func LoadFetcherConfig(configPath string) (*FetcherConfig, error) {
 	return &cfg, nil
 }
 
-// Fetch identity details from node; log raw response and decoded result
+// Fetch identity details from node and log the decoded result
 func FetchIdentity(address, nodeURL, apiKey string) (*Identity, error) {
-	reqData := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_identity",
-		"params":  []string{address},
-		"id":      1,
-	}
-	if apiKey != "" {
-		reqData["key"] = apiKey
-	}
-	body, _ := json.Marshal(reqData)
-	req, _ := http.NewRequest("POST", nodeURL, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	id, err := datasource.NewNodeSource(nodeURL, apiKey).GetIdentity(address)
 	if err != nil {
-		log.Printf("[AGENT][Fetcher] HTTP error for %s: %v", address, err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Log raw body for debugging
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	log.Printf("[AGENT][Fetcher] Raw RPC response for %s: %s", address, string(bodyBytes))
-	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Reset for decoder
-
-	var rpcResp struct {
-		Result Identity `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
-		log.Printf("[AGENT][Fetcher] Failed to decode JSON for %s: %v", address, err)
+		log.Printf("[AGENT][Fetcher] RPC error for %s: %v", address, err)
 		return nil, err
 	}
-	log.Printf("[AGENT][Fetcher] Decoded identity for %s: %+v", address, rpcResp.Result)
-	return &rpcResp.Result, nil
+	res := Identity{Address: id.Address, State: id.State, Stake: id.Stake, Age: id.Age}
+	log.Printf("[AGENT][Fetcher] Decoded identity for %s: %+v", address, res)
+	return &res, nil
 }
 
 // FetchAddressesFromIndexer retrieves the list of currently eligible addresses
@@ -151,25 +111,11 @@ func FetchAddressesFromIndexer(url string) ([]string, error) {
 
 // getEpochLast fetches the current epoch and discrimination threshold.
 func getEpochLast(nodeURL, apiKey string) (int, float64, error) {
-	url := strings.TrimRight(nodeURL, "/") + "/api/Epoch/Last"
-	if apiKey != "" {
-		url += "?apikey=" + apiKey
-	}
-	resp, err := http.Get(url)
+	info, err := datasource.NewAPISource(nodeURL, apiKey).GetEpoch()
 	if err != nil {
 		return 0, 0, err
 	}
-	defer resp.Body.Close()
-	var out struct {
-		Result struct {
-			Epoch     int     `json:"epoch"`
-			Threshold float64 `json:"discriminationStakeThreshold"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return 0, 0, err
-	}
-	return out.Result.Epoch, out.Result.Threshold, nil
+	return info.Epoch, info.Threshold, nil
 }
 
 // fetchBadAuthors returns a set of bad authors for the given epoch.
@@ -194,24 +140,23 @@ func fetchValidationSummary(nodeURL, apiKey string, epoch int, addr string) (*Va
 	}, nil
 }
 
-// fetchBlockRPC retrieves block data for the given height via JSON-RPC.
-func fetchBlockRPC(nodeURL, apiKey string, height int) (*Block, error) {
-	var b Block
-	if err := rpcCall(nodeURL, apiKey, "dna_getBlockByHeight", []interface{}{height}, &b); err != nil {
-		return nil, err
-	}
-	return &b, nil
-}
+var (
+	scannersMu sync.Mutex
+	scanners   = make(map[string]*sessionscan.Scanner)
+)
 
-// fetchLastBlock obtains the latest block using bcn_lastBlock followed by dna_getBlockByHeight.
-func fetchLastBlockRPC(nodeURL, apiKey string) (*Block, error) {
-	var last struct {
-		Height int `json:"height"`
-	}
-	if err := rpcCall(nodeURL, apiKey, "bcn_lastBlock", nil, &last); err != nil {
-		return nil, err
+// sessionScanner returns the session scanner of a node. It is kept across
+// fetch cycles so its block cache is reused.
+func sessionScanner(nodeURL, apiKey string) *sessionscan.Scanner {
+	scannersMu.Lock()
+	defer scannersMu.Unlock()
+	key := nodeURL + "\x00" + apiKey
+	s, ok := scanners[key]
+	if !ok {
+		s = sessionscan.New(datasource.NewNodeSource(nodeURL, apiKey), 4096)
+		scanners[key] = s
 	}
-	return fetchBlockRPC(nodeURL, apiKey, last.Height)
+	return s
 }
 
 // Main fetcher loop with full logging
@@ -251,56 +196,12 @@ func RunIdentityFetcherOnce(cfg *FetcherConfig, overrideFile string) error {
 			return fmt.Errorf("load address list: %w", err)
 		}
 	} else {
-		// find the block where ShortSessionStarted flag appears
-		last, err := fetchLastBlockRPC(cfg.NodeURL, cfg.ApiKey)
+		rep, err := sessionScanner(cfg.NodeURL, cfg.ApiKey).ScanEpoch(0)
 		if err != nil {
-			return fmt.Errorf("fetch last block: %w", err)
-		}
-		shortHeight := 0
-		for h := last.Height; h >= 0 && h >= last.Height-2000; h-- {
-			blk, err := fetchBlockRPC(cfg.NodeURL, cfg.ApiKey, h)
-			if err != nil {
-				continue
-			}
-			if containsFlag(blk, "ShortSessionStarted") {
-				shortHeight = h
-				break
-			}
-		}
-		if shortHeight == 0 {
-			return fmt.Errorf("ShortSessionStarted block not found")
-		}
-
-		// helper to fetch all transactions of a block via RPC
-		type tx struct {
-			From string `json:"from"`
-		}
-		fetchTxs := func(height int) ([]tx, error) {
-			var txs []tx
-			if err := rpcCall(cfg.NodeURL, cfg.ApiKey, "dna_getBlockTxs", []interface{}{height}, &txs); err != nil {
-				return nil, err
-			}
-			return txs, nil
-		}
-
-		unique := make(map[string]struct{})
-		blocks := 0
-		h := shortHeight
-		for blocks < 7 {
-			txs, err := fetchTxs(h)
-			if err == nil && len(txs) > 0 {
-				blocks++
-				for _, t := range txs {
-					if t.From != "" {
-						unique[strings.ToLower(t.From)] = struct{}{}
-					}
-				}
-			}
-			h++
-		}
-		for a := range unique {
-			addresses = append(addresses, a)
+			return fmt.Errorf("scan validation session: %w", err)
 		}
+		log.Printf("[AGENT][Fetcher] session: %s", rep.Summary())
+		addresses = rep.Senders
 	}
 	log.Printf("[AGENT][Fetcher] using %d addresses", len(addresses))
 
@@ -313,7 +214,7 @@ func RunIdentityFetcherOnce(cfg *FetcherConfig, overrideFile string) error {
 	outputPath := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
 	log.Printf("[AGENT][Fetcher] output file %s", outputPath)
 
-	var whitelist []string
+	wl := wlformat.New(epoch)
 	for _, addr := range addresses {
 		addrL := strings.ToLower(addr)
 		pen, flip, err := checks.CheckPenaltyFlipForEpoch(cfg.NodeURL, cfg.ApiKey, lastEpoch, addrL)
@@ -335,16 +236,29 @@ func RunIdentityFetcherOnce(cfg *FetcherConfig, overrideFile string) error {
 		if (sum.State == "Newbie" || sum.State == "Verified") && sum.Stake < 10000 {
 			continue
 		}
-		whitelist = append(whitelist, addrL)
+		wl.Entries = append(wl.Entries, wlformat.Entry{Address: addrL, State: sum.State, Stake: sum.Stake})
+	}
+	sort.Slice(wl.Entries, func(i, j int) bool { return wl.Entries[i].Address < wl.Entries[j].Address })
+	wl.Scheme = cfg.MerkleScheme
+	if wl.Scheme == "" {
+		wl.Scheme = merkle.Legacy
 	}
-	b, err := json.MarshalIndent(whitelist, "", "  ")
+	scheme, err := merkle.Get(wl.Scheme)
 	if err != nil {
-		return fmt.Errorf("marshal whitelist: %w", err)
+		return err
+	}
+	if wl.Root, err = scheme.Root(wl.Addresses(), epoch); err != nil {
+		return fmt.Errorf("merkle root: %w", err)
 	}
-	if err := os.WriteFile(outputPath, b, 0644); err != nil {
+	var buf bytes.Buffer
+	if err := wlformat.Write(&buf, wl, wlformat.Canonical); err != nil {
+		return fmt.Errorf("write whitelist: %w", err)
+	}
+	v, err := artifacts.Default().Write(outputPath, buf.Bytes(), 0644)
+	if err != nil {
 		return fmt.Errorf("write whitelist: %w", err)
 	}
-	log.Printf("[AGENT][Fetcher] wrote whitelist with %d addresses", len(whitelist))
+	log.Printf("[AGENT][Fetcher] wrote whitelist with %d addresses root=%s sha256=%s", len(wl.Entries), wl.Root, v.Hash)
 	return nil
 }
 
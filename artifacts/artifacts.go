@@ -0,0 +1,253 @@
+// Package artifacts writes generated files such as whitelists and manifests
+// atomically. Every write goes to a temporary file in the target directory
+// that is renamed over the target, so readers see either the old or the new
+// content and never a partial file. The content replaced by a write is kept
+// as a prior version in a .versions directory next to the file.
+//
+// All writes of a Store are performed by one goroutine, so concurrent
+// producers of the same file cannot interleave.
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetain is the number of prior versions kept per file by Default.
+const DefaultRetain = 5
+
+// VersionsDir is the directory, next to the file, holding prior versions.
+const VersionsDir = ".versions"
+
+// ErrClosed is returned by writes to a closed store.
+var ErrClosed = errors.New("artifact store closed")
+
+// Version describes a stored file.
+type Version struct {
+	Path    string    `json:"path"`
+	Hash    string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Unchanged is set when a write found the same content in place and
+	// left the file alone.
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+type request struct {
+	path  string
+	data  []byte
+	perm  os.FileMode
+	reply chan result
+}
+
+type result struct {
+	v   Version
+	err error
+}
+
+// Store serializes writes through a single writer goroutine.
+type Store struct {
+	retain    atomic.Int64
+	reqs      chan request
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts a store that keeps up to retain prior versions of every file.
+func New(retain int) *Store {
+	s := &Store{reqs: make(chan request), quit: make(chan struct{})}
+	s.SetRetain(retain)
+	go s.run()
+	return s
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore *Store
+)
+
+// Default returns the store shared by all producers of the process.
+func Default() *Store {
+	defaultOnce.Do(func() { defaultStore = New(DefaultRetain) })
+	return defaultStore
+}
+
+// SetRetain changes the number of prior versions kept per file. Older
+// versions are pruned on the next write of the file.
+func (s *Store) SetRetain(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.retain.Store(int64(n))
+}
+
+// Close stops the writer goroutine. Writes already accepted complete.
+func (s *Store) Close() {
+	s.closeOnce.Do(func() { close(s.quit) })
+}
+
+func (s *Store) run() {
+	for {
+		select {
+		case r := <-s.reqs:
+			v, err := s.write(r.path, r.data, r.perm)
+			r.reply <- result{v, err}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Write replaces the file at path with data and waits until it is in place.
+// If the file already holds data it is not touched.
+func (s *Store) Write(path string, data []byte, perm os.FileMode) (Version, error) {
+	r := request{path: path, data: data, perm: perm, reply: make(chan result, 1)}
+	select {
+	case s.reqs <- r:
+	case <-s.quit:
+		return Version{}, ErrClosed
+	}
+	res := <-r.reply
+	return res.v, res.err
+}
+
+// Hash returns the hex SHA-256 of data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) write(path string, data []byte, perm os.FileMode) (Version, error) {
+	v := Version{Path: path, Hash: Hash(data), Size: int64(len(data))}
+	if cur, err := os.ReadFile(path); err == nil {
+		if Hash(cur) == v.Hash {
+			v.Unchanged = true
+			if fi, err := os.Stat(path); err == nil {
+				v.ModTime = fi.ModTime()
+			}
+			return v, nil
+		}
+		if err := s.archive(path, cur, perm); err != nil {
+			return Version{}, fmt.Errorf("keep prior version of %s: %w", path, err)
+		}
+	}
+	if err := writeAtomic(path, data, perm); err != nil {
+		return Version{}, err
+	}
+	v.ModTime = time.Now()
+	return v, nil
+}
+
+// archive stores the replaced content of path and prunes old versions.
+func (s *Store) archive(path string, cur []byte, perm os.FileMode) error {
+	retain := int(s.retain.Load())
+	if retain == 0 {
+		return nil
+	}
+	dir := filepath.Join(filepath.Dir(path), VersionsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	base := filepath.Base(path)
+	name := fmt.Sprintf("%s.%s.%s", base, time.Now().UTC().Format("20060102T150405.000000000Z"), Hash(cur)[:12])
+	if err := writeAtomic(filepath.Join(dir, name), cur, perm); err != nil {
+		return err
+	}
+	old, err := versionFiles(path)
+	if err != nil {
+		return err
+	}
+	for len(old) > retain {
+		if err := os.Remove(old[len(old)-1]); err != nil {
+			return err
+		}
+		old = old[:len(old)-1]
+	}
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err := os.Rename(name, path); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return nil
+}
+
+// versionFiles returns the prior versions of path, newest first.
+func versionFiles(path string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(path), VersionsDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var out []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && !strings.HasPrefix(e.Name(), ".") {
+			out = append(out, filepath.Join(dir, e.Name()))
+		}
+	}
+	// the timestamp in the name sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+	return out, nil
+}
+
+// Versions returns the prior versions of path kept by the store, newest
+// first.
+func Versions(path string) ([]Version, error) {
+	files, err := versionFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Version, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Version{Path: f, Hash: Hash(data), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	return out, nil
+}
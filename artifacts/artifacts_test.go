@@ -0,0 +1,78 @@
+package artifacts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteKeepsPriorVersions(t *testing.T) {
+	s := New(2)
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "whitelist_epoch_1.json")
+	for i := 0; i < 4; i++ {
+		v, err := s.Write(path, []byte(fmt.Sprintf("v%d", i)), 0644)
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		if v.Unchanged || v.Hash != Hash([]byte(fmt.Sprintf("v%d", i))) {
+			t.Fatalf("unexpected version %+v", v)
+		}
+	}
+	v, err := s.Write(path, []byte("v3"), 0644)
+	if err != nil || !v.Unchanged {
+		t.Fatalf("rewrite of the same content: %+v %v", v, err)
+	}
+	// a file whose name extends the other's is not mixed up with it
+	if _, err := s.Write(filepath.Join(filepath.Dir(path), "whitelist_epoch_10.json"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "v3" {
+		t.Fatalf("content %q", data)
+	}
+	vs, err := Versions(path)
+	if err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	if len(vs) != 2 || vs[0].Hash != Hash([]byte("v2")) || vs[1].Hash != Hash([]byte("v1")) {
+		t.Fatalf("unexpected versions %+v", vs)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		if !e.IsDir() && e.Name()[0] == '.' {
+			t.Fatalf("temporary file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	s := New(0)
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "list.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := s.Write(path, []byte(fmt.Sprintf("writer %02d", i)), 0644); err != nil {
+				t.Errorf("write: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	data, _ := os.ReadFile(path)
+	if len(data) != len("writer 00") {
+		t.Fatalf("interleaved content %q", data)
+	}
+	if vs, _ := Versions(path); len(vs) != 0 {
+		t.Fatalf("versions kept with retain 0: %d", len(vs))
+	}
+	s.Close()
+	if _, err := s.Write(path, nil, 0644); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
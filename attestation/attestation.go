@@ -0,0 +1,143 @@
+// Package attestation signs and verifies statements about epoch whitelists.
+// An attestation commits to the epoch, Merkle root, tree scheme, number of
+// addresses and snapshot block, hashed as EIP-712 typed data so the same
+// signature can be checked with ecrecover on-chain:
+//
+//	EIP712Domain(string name,string version)
+//	WhitelistAttestation(uint256 epoch,bytes32 merkleRoot,string scheme,uint256 addressCount,uint256 snapshotBlock)
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Domain values of the EIP-712 separator.
+const (
+	DomainName    = "IdenaAuthGo Whitelist"
+	DomainVersion = "1"
+)
+
+var (
+	domainType = crypto.Keccak256([]byte("EIP712Domain(string name,string version)"))
+	structType = crypto.Keccak256([]byte("WhitelistAttestation(uint256 epoch,bytes32 merkleRoot,string scheme,uint256 addressCount,uint256 snapshotBlock)"))
+)
+
+// Attestation is a signed statement about one epoch whitelist.
+type Attestation struct {
+	Epoch         int    `json:"epoch"`
+	MerkleRoot    string `json:"merkle_root"`
+	Scheme        string `json:"scheme"`
+	AddressCount  int    `json:"address_count"`
+	SnapshotBlock int64  `json:"snapshot_block"`
+	Signer        string `json:"signer,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+// ErrUnsigned is returned when verifying an attestation without signature.
+var ErrUnsigned = errors.New("attestation is not signed")
+
+func uint256(v int64) []byte {
+	return common.LeftPadBytes(big.NewInt(v).Bytes(), 32)
+}
+
+// rootBytes decodes a root into a bytes32. The empty root of an empty
+// whitelist is the zero word.
+func rootBytes(root string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(root, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("merkle root: %w", err)
+	}
+	if len(b) != 0 && len(b) != 32 {
+		return nil, fmt.Errorf("merkle root has %d bytes", len(b))
+	}
+	return common.LeftPadBytes(b, 32), nil
+}
+
+// DomainSeparator returns the EIP-712 domain hash.
+func DomainSeparator() []byte {
+	return crypto.Keccak256(domainType, crypto.Keccak256([]byte(DomainName)), crypto.Keccak256([]byte(DomainVersion)))
+}
+
+// Digest returns the EIP-712 hash that is signed.
+func (a *Attestation) Digest() ([]byte, error) {
+	if a.Epoch < 0 || a.AddressCount < 0 || a.SnapshotBlock < 0 {
+		return nil, errors.New("negative attestation field")
+	}
+	root, err := rootBytes(a.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	structHash := crypto.Keccak256(
+		structType,
+		uint256(int64(a.Epoch)),
+		root,
+		crypto.Keccak256([]byte(a.Scheme)),
+		uint256(int64(a.AddressCount)),
+		uint256(a.SnapshotBlock),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, DomainSeparator(), structHash), nil
+}
+
+// Sign signs a with key and fills in Signer and Signature. The signature
+// uses v = 27/28 as expected by ecrecover.
+func (a *Attestation) Sign(key *ecdsa.PrivateKey) error {
+	digest, err := a.Digest()
+	if err != nil {
+		return err
+	}
+	sig, err := crypto.Sign(digest, key)
+	if err != nil {
+		return err
+	}
+	sig[64] += 27
+	a.Signer = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	a.Signature = "0x" + hex.EncodeToString(sig)
+	return nil
+}
+
+// Recover returns the address that produced the signature.
+func (a *Attestation) Recover() (common.Address, error) {
+	if a.Signature == "" {
+		return common.Address{}, ErrUnsigned
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(a.Signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		return common.Address{}, errors.New("malformed signature")
+	}
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	digest, err := a.Digest()
+	if err != nil {
+		return common.Address{}, err
+	}
+	pub, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// Verify checks the signature and that it was made by Signer and, if
+// signer is not empty, by that address.
+func (a *Attestation) Verify(signer string) error {
+	got, err := a.Recover()
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got.Hex(), a.Signer) {
+		return fmt.Errorf("signature is from %s, not the stated signer %s", got.Hex(), a.Signer)
+	}
+	if signer != "" && !strings.EqualFold(got.Hex(), signer) {
+		return fmt.Errorf("signature is from %s, expected %s", got.Hex(), signer)
+	}
+	return nil
+}
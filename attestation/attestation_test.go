@@ -0,0 +1,54 @@
+package attestation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	a := &Attestation{
+		Epoch:         164,
+		MerkleRoot:    "839d9a6ca43af7a125e9ece32839c12217469d40453b82e8a46b91da964f1e03",
+		Scheme:        "sha256-legacy",
+		AddressCount:  3,
+		SnapshotBlock: 1234567,
+	}
+	if err := a.Sign(key); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	signer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	if err := a.Verify(signer); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := a.Verify("0x0000000000000000000000000000000000000001"); err == nil {
+		t.Fatalf("expected error for another signer")
+	}
+
+	tampered := *a
+	tampered.AddressCount = 4
+	if err := tampered.Verify(signer); err == nil {
+		t.Fatalf("expected error for tampered count")
+	}
+	tampered = *a
+	tampered.Scheme = "oz-standard"
+	if err := tampered.Verify(""); err == nil {
+		t.Fatalf("expected error for tampered scheme")
+	}
+}
+
+func TestDigestEncoding(t *testing.T) {
+	a := &Attestation{Epoch: 1, MerkleRoot: "", Scheme: "oz-standard"}
+	if _, err := a.Digest(); err != nil {
+		t.Fatalf("empty root should encode as zero: %v", err)
+	}
+	a.MerkleRoot = "0x1234"
+	if _, err := a.Digest(); err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Fatalf("expected length error, got %v", err)
+	}
+	if err := (&Attestation{}).Verify(""); err != ErrUnsigned {
+		t.Fatalf("expected ErrUnsigned, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"idenauthgo/attestation"
+	"idenauthgo/merkle"
+	"idenauthgo/wlformat"
+)
+
+// Every stored whitelist root is attested by the operator key: an EIP-712
+// signature over epoch, root, scheme, address count and snapshot block. The
+// key is generated on first start, like the OIDC signing key.
+var ATTESTATION_KEY_FILE = getenv("ATTESTATION_KEY_FILE", "attestation_key.hex")
+
+// ATTESTATION_SIGNER is the operator address the verify command trusts when
+// no -signer is given.
+var ATTESTATION_SIGNER = getenv("ATTESTATION_SIGNER", "")
+
+var attestKey *ecdsa.PrivateKey
+
+// loadAttestationKey reads the hex encoded secp256k1 key from path or
+// generates and stores a new one if the file does not exist yet.
+func loadAttestationKey(path string) error {
+	key, err := crypto.LoadECDSA(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if key, err = crypto.GenerateKey(); err != nil {
+			return err
+		}
+		if err := crypto.SaveECDSA(path, key); err != nil {
+			return err
+		}
+		log.Printf("[ATTEST] generated new operator key %s", path)
+	}
+	attestKey = key
+	log.Printf("[ATTEST] signing whitelist roots as %s", crypto.PubkeyToAddress(key.PublicKey).Hex())
+	return nil
+}
+
+// attestRoot signs the statement about an epoch root and stores it next to
+// the root. Without an operator key only the attested values are stored.
+func attestRoot(epoch int, scheme, root string, count int) {
+	a := attestation.Attestation{
+		Epoch:         epoch,
+		MerkleRoot:    root,
+		Scheme:        scheme,
+		AddressCount:  count,
+		SnapshotBlock: attestedBlock(epoch),
+	}
+	if attestKey != nil {
+		if err := a.Sign(attestKey); err != nil {
+			log.Printf("[ATTEST] sign epoch %d/%s: %v", epoch, scheme, err)
+		}
+	}
+	_, err := db.Exec(`UPDATE epoch_merkle_roots SET address_count=?, snapshot_block=?, signer=?, signature=? WHERE epoch=? AND scheme=?`,
+		a.AddressCount, a.SnapshotBlock, a.Signer, a.Signature, epoch, scheme)
+	if err != nil {
+		log.Printf("[ATTEST] store epoch %d/%s: %v", epoch, scheme, err)
+	}
+}
+
+// attestedBlock returns the snapshot block recorded in the epoch's build
+// manifest, the block the identity data was read at. Epochs without a
+// manifest fall back to epoch_snapshot_meta.
+func attestedBlock(epoch int) int64 {
+	if m, err := loadManifest(epoch); err == nil && m.SnapshotBlock > 0 {
+		return m.SnapshotBlock
+	}
+	return int64(getSnapshotBlock(epoch))
+}
+
+// attestEpoch signs the roots of an epoch again, e.g. once its snapshot
+// block is known, and refreshes the attestation in the whitelist file.
+func attestEpoch(epoch int) {
+	attestEpochRoots(epoch)
+	if list, root, scheme, err := loadWhitelistData(epoch); err == nil && root != "" {
+		if err := writeWhitelistFile(epoch, list, root, scheme); err != nil {
+			log.Printf("[ATTEST] rewrite whitelist file for epoch %d: %v", epoch, err)
+		}
+	}
+}
+
+// attestEpochRoots signs every stored root of an epoch again.
+func attestEpochRoots(epoch int) {
+	rows, err := db.Query(`SELECT scheme, merkle_root, address_count FROM epoch_merkle_roots WHERE epoch=? AND address_count IS NOT NULL`, epoch)
+	if err != nil {
+		log.Printf("[ATTEST] epoch %d: %v", epoch, err)
+		return
+	}
+	type rootRow struct {
+		scheme, root string
+		count        int
+	}
+	var roots []rootRow
+	for rows.Next() {
+		var r rootRow
+		if err := rows.Scan(&r.scheme, &r.root, &r.count); err == nil {
+			roots = append(roots, r)
+		}
+	}
+	rows.Close()
+	for _, r := range roots {
+		attestRoot(epoch, r.scheme, r.root, r.count)
+	}
+}
+
+// getAttestation returns the signed attestation of an epoch root.
+func getAttestation(epoch int, scheme string) (*attestation.Attestation, bool) {
+	row := db.QueryRow(`SELECT COALESCE(merkle_root,''), COALESCE(address_count,0), COALESCE(snapshot_block,0), COALESCE(signer,''), COALESCE(signature,'')
+        FROM epoch_merkle_roots WHERE epoch=? AND scheme=?`, epoch, scheme)
+	a := attestation.Attestation{Epoch: epoch, Scheme: scheme}
+	if err := row.Scan(&a.MerkleRoot, &a.AddressCount, &a.SnapshotBlock, &a.Signer, &a.Signature); err != nil || a.Signature == "" {
+		return nil, false
+	}
+	return &a, true
+}
+
+// writeWhitelistFile stores an epoch whitelist in the canonical format with
+// its root, the build manifest, the identity record of every address and,
+// when available, the signed attestation of that root.
+func writeWhitelistFile(epoch int, list []string, root, scheme string) error {
+	wl := wlformat.New(epoch)
+	wl.Root, wl.Scheme = root, scheme
+	if m, err := loadManifest(epoch); err == nil && m.MerkleRoot == root && m.MerkleScheme == scheme {
+		wl.Manifest, _ = json.Marshal(m)
+	}
+	records := map[string]EpochSnapshot{}
+	if snaps, err := loadEpochSnapshots(db, epoch); err == nil {
+		for _, s := range snaps {
+			records[s.Address] = s
+		}
+	}
+	for _, a := range list {
+		e := wlformat.Entry{Address: a}
+		if s, ok := records[strings.ToLower(a)]; ok {
+			e.State, e.Stake = s.State, s.Stake
+			if s.Penalized {
+				e.Flags = append(e.Flags, wlformat.FlagPenalized)
+			}
+			if s.FlipReported {
+				e.Flags = append(e.Flags, wlformat.FlagFlipReported)
+			}
+		}
+		wl.Entries = append(wl.Entries, e)
+	}
+	if a, ok := getAttestation(epoch, scheme); ok && a.MerkleRoot == root {
+		wl.Attestation, _ = json.Marshal(a)
+	}
+	var buf bytes.Buffer
+	if err := wlformat.Write(&buf, wl, wlformat.Canonical); err != nil {
+		return err
+	}
+	return writeArtifact(whitelistPath(epoch), buf.Bytes())
+}
+
+// attestedDocument is either a whitelist file or a /merkle_root response.
+type attestedDocument struct {
+	Addresses    []string                 `json:"addresses"`
+	Entries      []wlformat.Entry         `json:"entries"`
+	MerkleRoot   string                   `json:"merkle_root"`
+	Scheme       string                   `json:"scheme"`
+	MerkleScheme string                   `json:"merkle_scheme"`
+	Epoch        *int                     `json:"epoch"`
+	Attestation  *attestation.Attestation `json:"attestation"`
+}
+
+// verifyAttestedDocument checks the attestation embedded in data: the
+// signature by the trusted signer, that it covers the document's root and
+// scheme and, if the document lists the addresses, that they hash to the
+// attested root. Without a signer anyone could sign a forged document, so
+// one is required.
+func verifyAttestedDocument(data []byte, signer string) (*attestation.Attestation, error) {
+	if signer == "" {
+		return nil, errors.New("no trusted signer given")
+	}
+	var doc attestedDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Addresses == nil && doc.Entries != nil {
+		doc.Addresses = make([]string, len(doc.Entries))
+		for i, e := range doc.Entries {
+			doc.Addresses[i] = e.Address
+		}
+	}
+	a := doc.Attestation
+	if a == nil {
+		return nil, errors.New("document has no attestation")
+	}
+	if err := a.Verify(signer); err != nil {
+		return nil, err
+	}
+	if doc.MerkleRoot != "" && doc.MerkleRoot != a.MerkleRoot {
+		return nil, fmt.Errorf("document root %s differs from attested root %s", doc.MerkleRoot, a.MerkleRoot)
+	}
+	for _, s := range []string{doc.Scheme, doc.MerkleScheme} {
+		if s != "" && s != a.Scheme {
+			return nil, fmt.Errorf("document scheme %s differs from attested scheme %s", s, a.Scheme)
+		}
+	}
+	if doc.Epoch != nil && *doc.Epoch != a.Epoch {
+		return nil, fmt.Errorf("document epoch %d differs from attested epoch %d", *doc.Epoch, a.Epoch)
+	}
+	if doc.Addresses != nil {
+		if len(doc.Addresses) != a.AddressCount {
+			return nil, fmt.Errorf("document lists %d addresses, attestation %d", len(doc.Addresses), a.AddressCount)
+		}
+		scheme, err := merkle.Get(a.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		root, err := scheme.Root(doc.Addresses, a.Epoch)
+		if err != nil {
+			return nil, err
+		}
+		if root != a.MerkleRoot {
+			return nil, fmt.Errorf("addresses hash to %s, attested root is %s", root, a.MerkleRoot)
+		}
+	}
+	return a, nil
+}
+
+// runVerifyCLI implements `verify [-signer 0x..] <file|url>`, checking a
+// whitelist file or /merkle_root response against the operator address from
+// -signer or ATTESTATION_SIGNER. It returns the exit code.
+func runVerifyCLI(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	signer := fs.String("signer", ATTESTATION_SIGNER, "trusted operator address (default $ATTESTATION_SIGNER)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: verify [-signer 0x..] <whitelist file | merkle_root URL>")
+		return 2
+	}
+	if *signer == "" {
+		fmt.Fprintln(os.Stderr, "verify: no trusted signer, pass -signer or set ATTESTATION_SIGNER")
+		return 2
+	}
+	src := fs.Arg(0)
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		var resp *http.Response
+		if resp, err = http.Get(src); err == nil {
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("%s: %s", src, resp.Status)
+			}
+		}
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	a, err := verifyAttestedDocument(data, *signer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verification failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("OK epoch %d %s root %s, %d addresses, snapshot block %d, signed by %s\n",
+		a.Epoch, a.Scheme, a.MerkleRoot, a.AddressCount, a.SnapshotBlock, a.Signer)
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestWhitelistAttestation(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+	key, _ := crypto.GenerateKey()
+	attestKey = key
+	defer func() { attestKey = nil }()
+	signer := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	addrs := []string{
+		"0x0000000000000000000000000000000000000001",
+		"0x0000000000000000000000000000000000000002",
+	}
+	root, scheme, err := whitelistRoot(9070, addrs)
+	if err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+	if err := writeWhitelistFile(9070, addrs, root, scheme); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	saveSnapshotMeta(9070, 555)
+
+	rr := httptest.NewRecorder()
+	merkleRootHandler(rr, httptest.NewRequest("GET", "/merkle_root?epoch=9070", nil))
+	a, err := verifyAttestedDocument(rr.Body.Bytes(), signer)
+	if err != nil {
+		t.Fatalf("verify /merkle_root: %v: %s", err, rr.Body.String())
+	}
+	if a.SnapshotBlock != 555 || a.AddressCount != 2 || a.MerkleRoot != root {
+		t.Fatalf("unexpected attestation %+v", a)
+	}
+
+	// the whitelist file was re-signed with the snapshot block
+	if code := runVerifyCLI([]string{"-signer", signer, whitelistPath(9070)}); code != 0 {
+		t.Fatalf("verify file exited with %d", code)
+	}
+	data, _ := os.ReadFile(whitelistPath(9070))
+	var doc map[string]interface{}
+	_ = json.Unmarshal(data, &doc)
+	doc["entries"] = doc["entries"].([]interface{})[:1]
+	tampered, _ := json.Marshal(doc)
+	if _, err := verifyAttestedDocument(tampered, signer); err == nil || !strings.Contains(err.Error(), "addresses") {
+		t.Fatalf("expected address count error, got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tampered.json")
+	_ = os.WriteFile(path, tampered, 0644)
+	if code := runVerifyCLI([]string{"-signer", signer, path}); code != 1 {
+		t.Fatalf("verify of tampered file exited with %d", code)
+	}
+	if code := runVerifyCLI([]string{whitelistPath(9070)}); code != 2 {
+		t.Fatalf("verify without a trusted signer exited with %d", code)
+	}
+
+	// a document signed by another key is rejected
+	forger, _ := crypto.GenerateKey()
+	attestKey = forger
+	attestEpoch(9070)
+	if _, err := verifyAttestedDocument(mustRead(t, whitelistPath(9070)), signer); err == nil {
+		t.Fatalf("document signed by another key verified")
+	}
+}
+
+func TestAttestationUsesManifestBlock(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	oldDir, oldEpoch, oldList := dataDir, currentEpoch, currentWhitelist
+	dataDir = t.TempDir()
+	defer func() { dataDir, currentEpoch, currentWhitelist = oldDir, oldEpoch, oldList }()
+	key, _ := crypto.GenerateKey()
+	attestKey = key
+	defer func() { attestKey = nil }()
+
+	snaps := []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}
+	if _, _, err := finishEpochWhitelist(9071, 12000, manifestSourceNode, "", blockRef{Height: 777, Hash: "0xblock"}, snaps); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	// startup records the epoch without knowing the block
+	saveSnapshotMeta(9071, 0)
+	a, ok := getAttestation(9071, merkleScheme)
+	if !ok || a.SnapshotBlock != 777 {
+		t.Fatalf("attestation %+v does not carry the manifest block", a)
+	}
+	doc, err := verifyAttestedDocument(mustRead(t, whitelistPath(9071)), crypto.PubkeyToAddress(key.PublicKey).Hex())
+	if err != nil || doc.SnapshotBlock != 777 {
+		t.Fatalf("whitelist file attestation %+v %v", doc, err)
+	}
+}
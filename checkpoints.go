@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"idenauthgo/checks"
+)
+
+// Penalty and flip checks of a whitelist build run on a worker pool that is
+// rate limited against the public API. Every finished check is stored in
+// epoch_check_checkpoints until the build completes, so a build that crashed
+// only repeats the checks it had not finished.
+
+var (
+	CHECK_WORKERS    = getenvInt("CHECK_WORKERS", 8)
+	CHECK_RATE       = getenvInt("CHECK_RATE", 10)
+	CHECK_RETRIES    = getenvInt("CHECK_RETRIES", 3)
+	CHECK_BACKOFF_MS = getenvInt("CHECK_BACKOFF_MS", 500)
+)
+
+// checkProgressInterval is the minimum time between progress log lines.
+var checkProgressInterval = 10 * time.Second
+
+var (
+	checkPoolOnce sync.Once
+	checkPool     *checks.Pool
+)
+
+// sharedCheckPool returns the pool used by all builds, so concurrent builds
+// share the rate limit.
+func sharedCheckPool() *checks.Pool {
+	checkPoolOnce.Do(func() {
+		checkPool = checks.NewPool(checks.PoolConfig{
+			Workers:    CHECK_WORKERS,
+			Rate:       float64(CHECK_RATE),
+			Burst:      CHECK_WORKERS,
+			Retries:    CHECK_RETRIES,
+			Backoff:    time.Duration(CHECK_BACKOFF_MS) * time.Millisecond,
+			MaxBackoff: 30 * time.Second,
+		})
+	})
+	return checkPool
+}
+
+// Checkpoint sources name the kind of check a checkpoint holds, so a build
+// never resumes from results of a check run in another mode.
+const (
+	// checkSourceNode is the penalty and flip status read from the local
+	// node.
+	checkSourceNode = "node"
+	// checkSourceAPI is the penalty and flip status from the public API.
+	checkSourceAPI = "api"
+	// checkSourceSummary is the validation summary read by
+	// buildEpochWhitelistAPI.
+	checkSourceSummary = "api-fallback"
+)
+
+func createCheckpointTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS epoch_check_checkpoints (
+            epoch INTEGER,
+            source TEXT,
+            address TEXT,
+            state TEXT,
+            stake REAL,
+            penalized INTEGER,
+            flipReported INTEGER,
+            ts INTEGER,
+            PRIMARY KEY (epoch, source, address)
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadCheckpoints(epoch int, source string) map[string]EpochSnapshot {
+	out := make(map[string]EpochSnapshot)
+	rows, err := db.Query(`SELECT address, state, stake, penalized, flipReported FROM epoch_check_checkpoints WHERE epoch=? AND source=?`, epoch, source)
+	if err != nil {
+		log.Printf("[CHECK] load checkpoints: %v", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s EpochSnapshot
+		var pen, fr int
+		if err := rows.Scan(&s.Address, &s.State, &s.Stake, &pen, &fr); err == nil {
+			s.Penalized = pen != 0
+			s.FlipReported = fr != 0
+			out[s.Address] = s
+		}
+	}
+	return out
+}
+
+func saveCheckpoint(epoch int, source string, s EpochSnapshot) {
+	_, err := db.Exec(`INSERT OR REPLACE INTO epoch_check_checkpoints(epoch, source, address, state, stake, penalized, flipReported, ts) VALUES(?,?,?,?,?,?,?,?)`,
+		epoch, source, s.Address, s.State, s.Stake, boolToInt(s.Penalized), boolToInt(s.FlipReported), time.Now().Unix())
+	if err != nil {
+		log.Printf("[CHECK] save checkpoint %s: %v", s.Address, err)
+	}
+}
+
+// clearCheckpoints drops the checkpoints of a build that ended, whether its
+// list was published, quarantined or never built.
+func clearCheckpoints(epoch int) {
+	if _, err := db.Exec(`DELETE FROM epoch_check_checkpoints WHERE epoch=?`, epoch); err != nil {
+		log.Printf("[CHECK] clear checkpoints: %v", err)
+	}
+}
+
+// runChecks runs check for every address not checkpointed yet by an earlier
+// attempt of the same build and returns all results by address together
+// with the addresses whose checks failed. Progress is logged, and thereby
+// streamed to /logs/stream.
+func runChecks(epoch int, source string, addrs []string, check func(addr string) (EpochSnapshot, error)) (map[string]EpochSnapshot, map[string]error) {
+	results := loadCheckpoints(epoch, source)
+	var pending []string
+	for _, a := range addrs {
+		if _, ok := results[a]; !ok {
+			pending = append(pending, a)
+		}
+	}
+	if resumed := len(addrs) - len(pending); resumed > 0 {
+		log.Printf("[CHECK] epoch %d: resuming, %d of %d addresses already checked", epoch, resumed, len(addrs))
+	}
+	var mu sync.Mutex
+	start := time.Now()
+	lastLog := start
+	failed := sharedCheckPool().Run(context.Background(), pending, func(ctx context.Context, addr string) error {
+		s, err := check(addr)
+		if err != nil {
+			return err
+		}
+		s.Address = addr
+		saveCheckpoint(epoch, source, s)
+		mu.Lock()
+		results[addr] = s
+		mu.Unlock()
+		return nil
+	}, func(done, failed, total int) {
+		if done < total && time.Since(lastLog) < checkProgressInterval {
+			return
+		}
+		lastLog = time.Now()
+		log.Printf("[CHECK] epoch %d: %d/%d checked, %d failed, %s elapsed", epoch, done, total, failed, time.Since(start).Round(time.Second))
+	})
+	return results, failed
+}
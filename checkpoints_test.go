@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestRunChecksResumesFromCheckpoints(t *testing.T) {
+	setupTestDB(t)
+	oldRetries := CHECK_RETRIES
+	CHECK_RETRIES = 0
+	checkPoolOnce = sync.Once{}
+	defer func() {
+		CHECK_RETRIES = oldRetries
+		checkPoolOnce = sync.Once{}
+	}()
+
+	// a previous attempt of the build finished 0xaaa before crashing
+	saveCheckpoint(50, checkSourceAPI, EpochSnapshot{Address: "0xaaa", State: "Human", Stake: 15000, Penalized: true})
+
+	var mu sync.Mutex
+	var called []string
+	check := func(addr string) (EpochSnapshot, error) {
+		mu.Lock()
+		called = append(called, addr)
+		mu.Unlock()
+		if addr == "0xccc" {
+			return EpochSnapshot{}, errors.New("unavailable")
+		}
+		return EpochSnapshot{State: "Newbie", Stake: 10000}, nil
+	}
+	results, failed := runChecks(50, checkSourceAPI, []string{"0xaaa", "0xbbb", "0xccc"}, check)
+	if len(called) != 2 {
+		t.Fatalf("expected only unchecked addresses to run, got %v", called)
+	}
+	if !results["0xaaa"].Penalized || results["0xbbb"].State != "Newbie" || results["0xbbb"].Address != "0xbbb" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if _, ok := results["0xccc"]; ok || failed["0xccc"] == nil || len(failed) != 1 {
+		t.Fatalf("unexpected failures %v", failed)
+	}
+
+	// the next attempt only repeats the failed check
+	called = nil
+	runChecks(50, checkSourceAPI, []string{"0xaaa", "0xbbb", "0xccc"}, check)
+	if len(called) != 1 || called[0] != "0xccc" {
+		t.Fatalf("expected only 0xccc to be checked again, got %v", called)
+	}
+	if cps := loadCheckpoints(50, checkSourceNode); len(cps) != 0 {
+		t.Fatalf("checkpoints leaked across sources: %v", cps)
+	}
+
+	clearCheckpoints(50)
+	if cps := loadCheckpoints(50, checkSourceAPI); len(cps) != 0 {
+		t.Fatalf("expected checkpoints cleared, got %v", cps)
+	}
+}
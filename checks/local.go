@@ -0,0 +1,96 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"idenauthgo/datasource"
+)
+
+// ErrValidationNotApplied is returned when the local node has not applied the
+// validation that opened the requested epoch, so its identity data cannot
+// answer penalty and flip checks for it.
+var ErrValidationNotApplied = errors.New("validation not applied by local node")
+
+// LocalChecker derives penalty and flip status from the local node instead of
+// the public API: the identity's penalty and lastValidationFlags, read after
+// the node applied the validation block of the epoch.
+type LocalChecker struct {
+	Node *datasource.NodeSource
+}
+
+// NewLocalChecker returns a checker reading from node.
+func NewLocalChecker(node *datasource.NodeSource) *LocalChecker {
+	return &LocalChecker{Node: node}
+}
+
+// FromIdentity derives the status from an identity record. ok is false if the
+// record does not carry the penalty field, e.g. when it came from a source
+// that omits it; the status then has to be read from the node.
+func FromIdentity(id datasource.Identity) (penalized, flip, ok bool) {
+	if id.Penalty == "" {
+		return false, false, false
+	}
+	return hasPenalty(id.Penalty), hasFlag(id.Flags, "AtLeastOneFlipReported"), true
+}
+
+func hasPenalty(p string) bool {
+	v, err := strconv.ParseFloat(p, 64)
+	if err != nil {
+		return p != "0"
+	}
+	return v > 0
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationApplied checks the block-level validation result of epoch: the
+// node must be in that epoch, and the first block of the epoch must carry the
+// ValidationFinished flag. Only then do penalty and lastValidationFlags of its
+// identities describe the validation that opened epoch.
+func (c *LocalChecker) ValidationApplied(epoch int) error {
+	var info struct {
+		Epoch      int   `json:"epoch"`
+		StartBlock int64 `json:"startBlock"`
+	}
+	if err := c.Node.Call("dna_epoch", []interface{}{}, &info); err != nil {
+		return err
+	}
+	if info.Epoch != epoch {
+		return fmt.Errorf("%w: node is in epoch %d, not %d", ErrValidationNotApplied, info.Epoch, epoch)
+	}
+	var blk struct {
+		Height int64    `json:"height"`
+		Flags  []string `json:"flags"`
+	}
+	if err := c.Node.Call("bcn_blockAt", []interface{}{info.StartBlock}, &blk); err != nil {
+		return err
+	}
+	if !hasFlag(blk.Flags, "ValidationFinished") {
+		return fmt.Errorf("%w: block %d of epoch %d has no ValidationFinished flag", ErrValidationNotApplied, info.StartBlock, epoch)
+	}
+	return nil
+}
+
+// CheckPenaltyFlip reads the identity of addr from the node via dna_identity.
+// Call ValidationApplied first to make sure the result refers to the epoch.
+func (c *LocalChecker) CheckPenaltyFlip(addr string) (penalized, flip bool, err error) {
+	id, err := c.Node.GetIdentity(strings.ToLower(addr))
+	if err != nil {
+		return false, false, err
+	}
+	penalized, flip, ok := FromIdentity(*id)
+	if !ok {
+		return false, false, fmt.Errorf("identity %s has no penalty field", addr)
+	}
+	return penalized, flip, nil
+}
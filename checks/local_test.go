@@ -0,0 +1,75 @@
+package checks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"idenauthgo/datasource"
+)
+
+func fakeNode(t *testing.T, results map[string]interface{}) *datasource.NodeSource {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		res, ok := results[req.Method]
+		if !ok {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": res})
+	}))
+	t.Cleanup(srv.Close)
+	return datasource.NewNodeSource(srv.URL, "")
+}
+
+func TestFromIdentity(t *testing.T) {
+	cases := []struct {
+		id            datasource.Identity
+		pen, flip, ok bool
+	}{
+		{datasource.Identity{Penalty: "0"}, false, false, true},
+		{datasource.Identity{Penalty: "12.5"}, true, false, true},
+		{datasource.Identity{Penalty: "0", Flags: []string{"AtLeastOneFlipReported"}}, false, true, true},
+		{datasource.Identity{}, false, false, false},
+	}
+	for _, c := range cases {
+		pen, flip, ok := FromIdentity(c.id)
+		if pen != c.pen || flip != c.flip || ok != c.ok {
+			t.Fatalf("FromIdentity(%+v) = %t %t %t", c.id, pen, flip, ok)
+		}
+	}
+}
+
+func TestLocalCheckerValidationApplied(t *testing.T) {
+	node := fakeNode(t, map[string]interface{}{
+		"dna_epoch":   map[string]interface{}{"epoch": 10, "startBlock": 500},
+		"bcn_blockAt": map[string]interface{}{"height": 500, "flags": []string{"ValidationFinished"}},
+		"dna_identity": map[string]interface{}{
+			"address": "0xabc", "state": "Human", "stake": "15000", "penalty": "0",
+			"lastValidationFlags": []string{"AtLeastOneFlipReported"},
+		},
+	})
+	c := NewLocalChecker(node)
+	if err := c.ValidationApplied(10); err != nil {
+		t.Fatalf("validation applied: %v", err)
+	}
+	if err := c.ValidationApplied(11); !errors.Is(err, ErrValidationNotApplied) {
+		t.Fatalf("expected ErrValidationNotApplied for other epoch, got %v", err)
+	}
+	pen, flip, err := c.CheckPenaltyFlip("0xABC")
+	if err != nil || pen || !flip {
+		t.Fatalf("unexpected status %t %t %v", pen, flip, err)
+	}
+
+	unfinished := NewLocalChecker(fakeNode(t, map[string]interface{}{
+		"dna_epoch":   map[string]interface{}{"epoch": 10, "startBlock": 500},
+		"bcn_blockAt": map[string]interface{}{"height": 500, "flags": nil},
+	}))
+	if err := unfinished.ValidationApplied(10); !errors.Is(err, ErrValidationNotApplied) {
+		t.Fatalf("expected ErrValidationNotApplied without flag, got %v", err)
+	}
+}
@@ -1,11 +1,11 @@
 package checks
 
 import (
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+
+	"idenauthgo/datasource"
 )
 
 // APIBase is the base URL used for REST API calls.
@@ -27,44 +27,7 @@ var (
 
 // Swagger: /Epoch/{epoch}/Authors/Bad
 func fetchBadAuthors(base, apiKey string, epoch int) (map[string]struct{}, error) {
-	bad := make(map[string]struct{})
-	cont := ""
-	for {
-		url := fmt.Sprintf("%s/api/Epoch/%d/Authors/Bad?limit=100", strings.TrimRight(base, "/"), epoch)
-		if apiKey != "" {
-			url += "&apikey=" + apiKey
-		}
-		if cont != "" {
-			url += "&continuationToken=" + cont
-		}
-		resp, err := http.Get(url)
-		if err != nil {
-			return bad, err
-		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			return bad, fmt.Errorf("status %s", resp.Status)
-		}
-		var res struct {
-			Result []struct {
-				Address string `json:"address"`
-			} `json:"result"`
-			Continuation string `json:"continuationToken"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-			resp.Body.Close()
-			return bad, err
-		}
-		resp.Body.Close()
-		for _, r := range res.Result {
-			bad[strings.ToLower(r.Address)] = struct{}{}
-		}
-		if res.Continuation == "" {
-			break
-		}
-		cont = res.Continuation
-	}
-	return bad, nil
+	return datasource.NewAPISource(base, apiKey).BadAuthors(epoch)
 }
 
 func getBadAuthors(base, apiKey string, epoch int) (map[string]struct{}, error) {
@@ -91,54 +54,25 @@ func BadAuthors(base, apiKey string, epoch int) (map[string]struct{}, error) {
 
 // Swagger: /Epoch/{epoch}/Identity/{address}/ValidationSummary
 func FetchValidationSummary(base, apiKey string, epoch int, addr string) (*ValidationSummary, error) {
-	url := fmt.Sprintf("%s/api/Epoch/%d/Identity/%s/ValidationSummary", strings.TrimRight(base, "/"), epoch, addr)
-	if apiKey != "" {
-		if strings.Contains(url, "?") {
-			url += "&apikey=" + apiKey
-		} else {
-			url += "?apikey=" + apiKey
-		}
-	}
-	resp, err := http.Get(url)
+	sum, err := datasource.NewAPISource(base, apiKey).ValidationSummary(epoch, addr)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %s", resp.Status)
-	}
-	var out struct {
-		Result ValidationSummary `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	return &out.Result, nil
+	return &ValidationSummary{
+		State:     sum.State,
+		Stake:     strconv.FormatFloat(sum.Stake, 'f', -1, 64),
+		Approved:  sum.Approved,
+		Penalized: sum.Penalized,
+	}, nil
 }
 
 // LatestEpoch returns the latest epoch number from the API.
 func LatestEpoch(base, apiKey string) (int, error) {
-	url := strings.TrimRight(base, "/") + "/api/Epoch/Last"
-	if apiKey != "" {
-		url += "?apikey=" + apiKey
-	}
-	resp, err := http.Get(url)
+	info, err := datasource.NewAPISource(base, apiKey).GetEpoch()
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("status %s", resp.Status)
-	}
-	var out struct {
-		Result struct {
-			Epoch int `json:"epoch"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return 0, err
-	}
-	return out.Result.Epoch, nil
+	return info.Epoch, nil
 }
 
 // CheckPenaltyFlipForEpoch reports whether an address had a validation penalty or a bad flip report in the specified epoch.
@@ -0,0 +1,152 @@
+package checks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits the rate of requests: it holds up to burst tokens,
+// refilled at rate tokens per second, and every request takes one.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket. A rate of zero or less disables
+// limiting.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil || b.rate <= 0 {
+		return ctx.Err()
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Workers is the number of concurrent checks.
+	Workers int
+	// Rate and Burst configure the token bucket shared by all runs of the
+	// pool, in requests per second. A zero rate disables limiting.
+	Rate  float64
+	Burst int
+	// Retries is how often a failed check is repeated.
+	Retries int
+	// Backoff is the wait before the first retry; it doubles with every
+	// further attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Pool runs per-address checks concurrently under a shared rate limit, so
+// whitelist builds do not issue one API round-trip after another.
+type Pool struct {
+	cfg     PoolConfig
+	limiter *TokenBucket
+}
+
+// NewPool returns a pool for cfg.
+func NewPool(cfg PoolConfig) *Pool {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = cfg.Backoff
+	}
+	return &Pool{cfg: cfg, limiter: NewTokenBucket(cfg.Rate, cfg.Burst)}
+}
+
+// Run calls fn for every item, each attempt taking one token. Failed calls
+// are retried with exponential backoff. progress, if set, is called after
+// every item with the number of finished and failed items. Run returns the
+// last error of every item that failed all attempts.
+func (p *Pool) Run(ctx context.Context, items []string, fn func(ctx context.Context, item string) error, progress func(done, failed, total int)) map[string]error {
+	jobs := make(chan string)
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	done := 0
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Workers && i < len(items); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				err := p.attempt(ctx, item, fn)
+				mu.Lock()
+				done++
+				if err != nil {
+					failed[item] = err
+				}
+				if progress != nil {
+					progress(done, len(failed), len(items))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, item := range items {
+		jobs <- item
+	}
+	close(jobs)
+	wg.Wait()
+	return failed
+}
+
+func (p *Pool) attempt(ctx context.Context, item string, fn func(ctx context.Context, item string) error) error {
+	backoff := p.cfg.Backoff
+	for try := 0; ; try++ {
+		if err := p.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := fn(ctx, item)
+		if err == nil || try == p.cfg.Retries {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if backoff *= 2; backoff > p.cfg.MaxBackoff {
+			backoff = p.cfg.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolRetriesAndReportsFailures(t *testing.T) {
+	p := NewPool(PoolConfig{Workers: 4, Retries: 2, Backoff: time.Millisecond})
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	items := []string{"a", "b", "flaky", "broken"}
+	var last [3]int
+	failed := p.Run(context.Background(), items, func(ctx context.Context, item string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[item]++
+		switch {
+		case item == "flaky" && calls[item] < 3:
+			return errors.New("temporary")
+		case item == "broken":
+			return errors.New("permanent")
+		}
+		return nil
+	}, func(done, failed, total int) {
+		last = [3]int{done, failed, total}
+	})
+	if len(failed) != 1 || failed["broken"] == nil {
+		t.Fatalf("unexpected failures %v", failed)
+	}
+	if calls["a"] != 1 || calls["flaky"] != 3 || calls["broken"] != 3 {
+		t.Fatalf("unexpected attempts %v", calls)
+	}
+	if last != [3]int{4, 1, 4} {
+		t.Fatalf("unexpected progress %v", last)
+	}
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	p := NewPool(PoolConfig{Workers: 3})
+	var running, peak int32
+	items := make([]string, 12)
+	for i := range items {
+		items[i] = fmt.Sprint(i)
+	}
+	p.Run(context.Background(), items, func(ctx context.Context, item string) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}, nil)
+	if peak != 3 {
+		t.Fatalf("expected 3 concurrent checks, got %d", peak)
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	b := NewTokenBucket(100, 2)
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	// two tokens are available at once, the other five take 10ms each
+	if d := time.Since(start); d < 45*time.Millisecond {
+		t.Fatalf("7 requests at 100/s with burst 2 took only %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewTokenBucket(0.001, 1)
+	slow.Wait(context.Background())
+	if err := slow.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ADMIN_TOKEN protects the /admin endpoints. They are disabled when unset.
+var ADMIN_TOKEN = getenv("ADMIN_TOKEN", "")
+
+func createClientTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS clients (
+            client_id TEXT PRIMARY KEY,
+            name TEXT,
+            secret_hash TEXT,
+            redirect_uris TEXT,
+            policy TEXT,
+            created INTEGER
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadClient reads a registered relying party from the clients table.
+func loadClient(id string) (*oidcClient, bool) {
+	row := db.QueryRow(`SELECT name, secret_hash, redirect_uris, policy FROM clients WHERE client_id=?`, id)
+	c := oidcClient{ID: id}
+	var uris string
+	if err := row.Scan(&c.Name, &c.SecretHash, &uris, &c.Policy); err != nil {
+		return nil, false
+	}
+	if err := json.Unmarshal([]byte(uris), &c.RedirectURIs); err != nil {
+		log.Printf("[CLIENTS] bad redirect_uris for %s: %v", id, err)
+		return nil, false
+	}
+	return &c, true
+}
+
+// requireAdmin checks the bearer token of an admin request and writes an
+// error response if it does not match ADMIN_TOKEN.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if ADMIN_TOKEN == "" {
+		http.Error(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(ADMIN_TOKEN)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+type clientInfo struct {
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Policy       string   `json:"policy"`
+	AuthMethod   string   `json:"token_endpoint_auth_method"`
+	Created      int64    `json:"created"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+}
+
+// authMethod returns the token endpoint authentication of a client with
+// the given secret hash.
+func authMethod(secretHash string) string {
+	if secretHash == "" {
+		return "none"
+	}
+	return "client_secret_basic"
+}
+
+// adminClientsHandler lists (GET) or registers (POST) relying parties.
+// The generated client secret is only returned once on creation. Clients
+// registered with "token_endpoint_auth_method": "none" get no secret and
+// have to use PKCE.
+func adminClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`SELECT client_id, name, secret_hash, redirect_uris, policy, created FROM clients ORDER BY created`)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		list := []clientInfo{}
+		for rows.Next() {
+			var c clientInfo
+			var hash, uris string
+			if err := rows.Scan(&c.ClientID, &c.Name, &hash, &uris, &c.Policy, &c.Created); err != nil {
+				continue
+			}
+			c.AuthMethod = authMethod(hash)
+			_ = json.Unmarshal([]byte(uris), &c.RedirectURIs)
+			list = append(list, c)
+		}
+		writeJSON(w, list)
+	case http.MethodPost:
+		var req struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirect_uris"`
+			Policy       string   `json:"policy"`
+			AuthMethod   string   `json:"token_endpoint_auth_method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if len(req.RedirectURIs) == 0 {
+			http.Error(w, "redirect_uris required", http.StatusBadRequest)
+			return
+		}
+		for _, u := range req.RedirectURIs {
+			if err := validateRedirectURI(u); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if _, ok := lookupPolicy(req.Policy); !ok {
+			http.Error(w, "unknown policy", http.StatusBadRequest)
+			return
+		}
+		if req.Policy == "" {
+			req.Policy = policyDefault
+		}
+		c := clientInfo{
+			ClientID:     "client-" + randHex(8),
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			Policy:       req.Policy,
+			Created:      time.Now().Unix(),
+		}
+		var hash string
+		switch req.AuthMethod {
+		case "", "client_secret_basic", "client_secret_post":
+			c.ClientSecret = randHex(24)
+			hash = hashSecret(c.ClientSecret)
+		case "none":
+		default:
+			http.Error(w, "unsupported token_endpoint_auth_method", http.StatusBadRequest)
+			return
+		}
+		c.AuthMethod = authMethod(hash)
+		uris, _ := json.Marshal(c.RedirectURIs)
+		_, err := db.Exec(`INSERT INTO clients(client_id,name,secret_hash,redirect_uris,policy,created) VALUES(?,?,?,?,?,?)`,
+			c.ClientID, c.Name, hash, string(uris), c.Policy, c.Created)
+		if err != nil {
+			log.Printf("[CLIENTS] insert: %v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[CLIENTS] registered %s (%s)", c.ClientID, c.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminClientHandler removes a relying party via DELETE /admin/clients/{id}.
+func adminClientHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/clients/")
+	if id == "" {
+		adminClientsHandler(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := db.Exec(`DELETE FROM clients WHERE client_id=?`, id)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[CLIENTS] deleted %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateRedirectURI accepts absolute https URIs, or http for localhost.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid redirect URI %q", uri)
+	}
+	host := u.Hostname()
+	if u.Scheme == "https" || (u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1")) {
+		return nil
+	}
+	return fmt.Errorf("redirect URI %q must use https", uri)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func registerTestClient(t *testing.T, body string) clientInfo {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/clients", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	adminClientsHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register client: %d %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("register client: content type %q", ct)
+	}
+	var c clientInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &c); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return c
+}
+
+func setupTestClients(t *testing.T) {
+	setupTestOIDC(t)
+	old := ADMIN_TOKEN
+	ADMIN_TOKEN = "admin-token"
+	t.Cleanup(func() { ADMIN_TOKEN = old })
+}
+
+func TestAdminClientsRequiresToken(t *testing.T) {
+	setupTestClients(t)
+	req := httptest.NewRequest(http.MethodPost, "/admin/clients", strings.NewReader(`{"redirect_uris":["https://a.example/cb"]}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	rr := httptest.NewRecorder()
+	adminClientsHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAdminClientsRejectsInsecureRedirect(t *testing.T) {
+	setupTestClients(t)
+	req := httptest.NewRequest(http.MethodPost, "/admin/clients", strings.NewReader(`{"redirect_uris":["http://a.example/cb"]}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	adminClientsHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestRegisteredClientSignin(t *testing.T) {
+	setupTestClients(t)
+	c := registerTestClient(t, `{"name":"forum","redirect_uris":["https://forum.example/idena"],"policy":"signature"}`)
+	if c.ClientSecret == "" || !strings.HasPrefix(c.ClientID, "client-") {
+		t.Fatalf("unexpected client: %+v", c)
+	}
+	stored, ok := lookupOIDCClient(c.ClientID)
+	if !ok || !stored.checkSecret(c.ClientSecret) || stored.checkSecret("nope") {
+		t.Fatalf("stored client does not match secret")
+	}
+
+	rr := httptest.NewRecorder()
+	signinHandler(rr, httptest.NewRequest(http.MethodGet, "/signin?client_id="+c.ClientID+"&redirect_uri=https://evil.example/", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unregistered redirect, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	signinHandler(rr, httptest.NewRequest(http.MethodGet, "/signin?client_id="+c.ClientID+"&state=abc", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected redirect to Idena app, got %d", rr.Code)
+	}
+	var token string
+	if err := db.QueryRow(`SELECT token FROM oidc_requests WHERE client_id=?`, c.ClientID).Scan(&token); err != nil {
+		t.Fatalf("pending request: %v", err)
+	}
+	if p := sessionPolicy(token); p != policySignature {
+		t.Fatalf("expected signature policy, got %q", p)
+	}
+
+	// a newcomer passes the signature-only policy but not the default one
+	if ok, _ := evaluatePolicy(policySignature, true, "0xabc", "Newbie", 0); !ok {
+		t.Fatalf("signature policy should accept any signed identity")
+	}
+	if ok, _ := evaluatePolicy(policyDefault, true, "0xabc", "Newbie", 0); ok {
+		t.Fatalf("default policy should reject newbies")
+	}
+
+	_, err := db.Exec(`UPDATE sessions SET address=?, authenticated=1, status='authenticated', identity_state=?, stake=? WHERE token=?`,
+		"0xabc", "Newbie", 0.0, token)
+	if err != nil {
+		t.Fatalf("update session: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	callbackHandler(rr, httptest.NewRequest(http.MethodGet, "/callback?token="+token, nil))
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || loc.Host != "forum.example" || loc.Query().Get("code") == "" || loc.Query().Get("state") != "abc" {
+		t.Fatalf("expected redirect with code, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
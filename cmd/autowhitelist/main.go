@@ -1,20 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"idenauthgo/agents"
 	"log"
-	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"idenauthgo/artifacts"
 	"idenauthgo/checks"
+	"idenauthgo/datasource"
 	"idenauthgo/eligibility"
+	"idenauthgo/sessionscan"
 )
 
 const (
@@ -24,32 +26,8 @@ const (
 	thresholdOutFile = "discriminationStakeThreshold.txt"
 	newbieMinStake   = 10000.0
 	verifiedMinStake = 10000.0
-	requiredBlocks   = 7
 )
 
-type epochLastResp struct {
-	Result struct {
-		Epoch     int     `json:"epoch"`
-		Threshold float64 `json:"discriminationStakeThreshold"`
-	} `json:"result"`
-}
-
-type epochResp struct {
-	Result struct {
-		ValidationFirstBlock int `json:"validationFirstBlockHeight"`
-	} `json:"result"`
-}
-
-type blockFlagsResp struct {
-	Result struct {
-		Flags []string `json:"flags"`
-	} `json:"result"`
-}
-
-type tx struct {
-	From string `json:"from"`
-}
-
 type validationSummary struct {
 	State     string `json:"state"`
 	Stake     string `json:"stake"`
@@ -57,117 +35,12 @@ type validationSummary struct {
 	Penalized bool   `json:"penalized"`
 }
 
-func apiGet(baseURL, apiKey, path string, out interface{}) error {
-	url := strings.TrimRight(baseURL, "/") + path
-	if apiKey != "" {
-		if strings.Contains(url, "?") {
-			url += "&apikey=" + apiKey
-		} else {
-			url += "?apikey=" + apiKey
-		}
-	}
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %s", resp.Status)
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
-}
-
 func getLatestEpochInfo(nodeURL, apiKey string) (int, float64, error) {
-	var res epochLastResp
-	if err := apiGet(nodeURL, apiKey, "/api/Epoch/Last", &res); err != nil {
+	info, err := datasource.NewAPISource(nodeURL, apiKey).GetEpoch()
+	if err != nil {
 		return 0, 0, err
 	}
-	return res.Result.Epoch, res.Result.Threshold, nil
-}
-
-func getEpochInfo(nodeURL, apiKey string, epoch int) (int, error) {
-	var res epochResp
-	if err := apiGet(nodeURL, apiKey, fmt.Sprintf("/api/Epoch/%d", epoch), &res); err != nil {
-		return 0, err
-	}
-	return res.Result.ValidationFirstBlock, nil
-}
-
-func getBlockFlags(nodeURL, apiKey string, height int) ([]string, error) {
-	var res blockFlagsResp
-	if err := apiGet(nodeURL, apiKey, fmt.Sprintf("/api/Block/%d", height), &res); err != nil {
-		return nil, err
-	}
-	return res.Result.Flags, nil
-}
-
-func fetchAllTxs(nodeURL, apiKey string, height int) ([]tx, error) {
-	var all []tx
-	cont := ""
-	for {
-		path := fmt.Sprintf("/api/Block/%d/Txs?limit=100", height)
-		if cont != "" {
-			path += "&continuationToken=" + cont
-		}
-		var res struct {
-			Result       []tx   `json:"result"`
-			Continuation string `json:"continuationToken"`
-		}
-		if err := apiGet(nodeURL, apiKey, path, &res); err != nil {
-			return all, err
-		}
-		// The API returns "result": null for empty blocks (and rarely
-		// "result": []), so len(Result) indicates whether the block
-		// actually contains transactions.
-		if len(res.Result) > 0 {
-			all = append(all, res.Result...)
-		}
-		if res.Continuation == "" {
-			break
-		}
-		cont = res.Continuation
-		time.Sleep(100 * time.Millisecond)
-	}
-	return all, nil
-}
-
-func findShortSessionBlock(nodeURL, apiKey string, start int) (int, error) {
-	for h := start; h < start+20; h++ {
-		flags, err := getBlockFlags(nodeURL, apiKey, h)
-		if err != nil {
-			continue
-		}
-		for _, f := range flags {
-			if f == "ShortSessionStarted" {
-				return h, nil
-			}
-		}
-	}
-	return 0, fmt.Errorf("ShortSessionStarted not found")
-}
-
-func collectAddresses(nodeURL, apiKey string, start int) ([]string, error) {
-	unique := make(map[string]struct{})
-	blocks := 0
-	h := start
-	for blocks < requiredBlocks {
-		txs, err := fetchAllTxs(nodeURL, apiKey, h)
-		if err == nil && len(txs) > 0 {
-			blocks++
-			for _, t := range txs {
-				if t.From != "" {
-					unique[strings.ToLower(t.From)] = struct{}{}
-				}
-			}
-		}
-		h++
-	}
-	list := make([]string, 0, len(unique))
-	for a := range unique {
-		list = append(list, a)
-	}
-	sort.Strings(list)
-	return list, nil
+	return info.Epoch, info.Threshold, nil
 }
 
 func fetchBadAddresses(nodeURL, apiKey string, epoch int) (map[string]struct{}, error) {
@@ -191,13 +64,14 @@ func fetchValidationSummary(nodeURL, apiKey string, epoch int, addr string) (*va
 
 func saveLines(path string, lines []string) error {
 	data := strings.Join(lines, "\n") + "\n"
-	return os.WriteFile(path, []byte(data), 0644)
+	_, err := artifacts.Default().Write(path, []byte(data), 0644)
+	return err
 }
 
 func main() {
 	nodeURL := flag.String("node", defaultNodeURL, "Idena node RPC base URL")
 	apiKey := flag.String("key", "", "Idena node API key")
-	auto := flag.Bool("auto-addresses", false, "discover addresses from recent blocks")
+	auto := flag.Bool("auto-addresses", false, "discover addresses from the short session transactions on the node")
 	flag.Parse()
 
 	epoch, threshold, err := getLatestEpochInfo(*nodeURL, *apiKey)
@@ -210,19 +84,13 @@ func main() {
 
 	var addresses []string
 	if *auto {
-		lastEpoch := epoch - 1
-		firstBlock, err := getEpochInfo(*nodeURL, *apiKey, lastEpoch)
-		if err != nil {
-			log.Fatalf("epoch info: %v", err)
-		}
-		ssStart, err := findShortSessionBlock(*nodeURL, *apiKey, firstBlock+15)
-		if err != nil {
-			log.Fatalf("find short session: %v", err)
-		}
-		addresses, err = collectAddresses(*nodeURL, *apiKey, ssStart)
+		scanner := sessionscan.New(datasource.NewNodeSource(*nodeURL, *apiKey), 4096)
+		rep, err := scanner.ScanEpoch(epoch)
 		if err != nil {
-			log.Fatalf("collect addresses: %v", err)
+			log.Fatalf("scan validation session: %v", err)
 		}
+		log.Printf("session: %s", rep.Summary())
+		addresses = rep.Senders
 		if err := saveLines(addressFile, addresses); err != nil {
 			log.Printf("write addresses: %v", err)
 		}
@@ -235,11 +103,7 @@ func main() {
 
 	lastEpoch := epoch - 1
 
-	out, err := os.Create(outFile)
-	if err != nil {
-		log.Fatalf("open output: %v", err)
-	}
-	defer out.Close()
+	var out bytes.Buffer
 
 	included := 0
 	for i, addr := range addresses {
@@ -267,5 +131,8 @@ func main() {
 		log.Printf("[%d/%d] OK %s state=%s stake=%.4f", i+1, len(addresses), addr, sum.State, stake)
 		time.Sleep(200 * time.Millisecond)
 	}
+	if _, err := artifacts.Default().Write(outFile, out.Bytes(), 0644); err != nil {
+		log.Fatalf("write output: %v", err)
+	}
 	log.Printf("Done. Whitelisted: %d addresses", included)
 }
@@ -0,0 +1,56 @@
+// Command contractgen writes a Solidity verifier contract for a whitelist
+// Merkle scheme. With -epoch it also prints the publishRoot calldata for the
+// root stored by the server for that epoch.
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"idenauthgo/merkle"
+	"idenauthgo/solidity"
+)
+
+func main() {
+	scheme := flag.String("scheme", merkle.Legacy, "Merkle scheme: "+fmt.Sprint(merkle.Names()))
+	name := flag.String("name", solidity.DefaultContractName, "contract name")
+	out := flag.String("out", "", "write the contract to this file instead of stdout")
+	dbPath := flag.String("db", "./sessions.db", "server database holding the epoch roots")
+	epoch := flag.Int("epoch", -1, "print publishRoot calldata for this epoch's stored root")
+	flag.Parse()
+
+	src, err := solidity.Generate(*scheme, solidity.Options{ContractName: *name})
+	if err != nil {
+		log.Fatalf("generate: %v", err)
+	}
+	if *out == "" {
+		fmt.Print(src)
+	} else if err := os.WriteFile(*out, []byte(src), 0644); err != nil {
+		log.Fatalf("write %s: %v", *out, err)
+	}
+
+	if *epoch < 0 {
+		return
+	}
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		log.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	var root string
+	err = db.QueryRow(`SELECT merkle_root FROM epoch_merkle_roots WHERE epoch=? AND scheme=?`, *epoch, *scheme).Scan(&root)
+	if err != nil {
+		log.Fatalf("no %s root stored for epoch %d: %v", *scheme, *epoch, err)
+	}
+	data, err := solidity.PublishRootCalldata(*epoch, root)
+	if err != nil {
+		log.Fatalf("encode root %s: %v", root, err)
+	}
+	fmt.Fprintf(os.Stderr, "epoch %d root %s\npublishRoot calldata: 0x%s\n", *epoch, root, hex.EncodeToString(data))
+}
@@ -23,7 +23,8 @@ func main() {
 //   go run cmd/fetcher/main.go -config agents/config.json
 // The fetcher will query the rolling indexer for the list of eligible addresses
 // and then contact the Idena node for identity details. The resulting snapshot
-// is written to data/whitelist_epoch_<epoch>.json. Use -address-file to override
+// is written to data/whitelist_epoch_<epoch>.json in the canonical wlformat
+// layout, which the server reads as well. Use -address-file to override
 // the address source if needed.
 // Run this periodically (e.g. via cron or a systemd timer) to keep the snapshot
 // up to date.
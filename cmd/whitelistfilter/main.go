@@ -5,8 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"idenauthgo/agents"
+	"idenauthgo/artifacts"
+	"idenauthgo/datasource"
 	"log"
-	"net/http"
 	"os"
 	"sort"
 	"strconv"
@@ -23,21 +24,13 @@ func hasFlag(flags []string, f string) bool {
 }
 
 func getThreshold(nodeURL, apiKey string, epoch int) (float64, error) {
-	url := fmt.Sprintf("%s/api/Epoch/%d", strings.TrimRight(nodeURL, "/"), epoch)
-	if apiKey != "" {
-		url += "?apikey=" + apiKey
-	}
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
 	var out struct {
 		Result struct {
 			Threshold float64 `json:"discriminationStakeThreshold"`
 		} `json:"result"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	api := datasource.NewAPISource(nodeURL, apiKey)
+	if err := api.Get(fmt.Sprintf("/api/Epoch/%d", epoch), &out); err != nil {
 		return 0, err
 	}
 	return out.Result.Threshold, nil
@@ -93,7 +86,7 @@ func main() {
 		b, _ := json.MarshalIndent(addrs, "", "  ")
 		out = b
 	}
-	if err := os.WriteFile(*output, out, 0644); err != nil {
+	if _, err := artifacts.Default().Write(*output, out, 0644); err != nil {
 		log.Fatalf("write output: %v", err)
 	}
 }
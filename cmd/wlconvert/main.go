@@ -0,0 +1,120 @@
+// Command wlconvert converts whitelist files between the canonical wlformat
+// document and the legacy formats (server JSON, address array, identity
+// JSONL, address lines). The input format is detected unless -from is set.
+//
+//	go run ./cmd/wlconvert -in data/whitelist_epoch_164.jsonl -out whitelist.json
+//	go run ./cmd/wlconvert -in data/whitelist_epoch_164.json -to lines
+//
+// Canonical and server output need a Merkle root; when the input has none it
+// is computed with -scheme. A root present in the input is checked against
+// the addresses.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"idenauthgo/merkle"
+	"idenauthgo/wlformat"
+)
+
+var epochName = regexp.MustCompile(`whitelist_epoch_(\d+)`)
+
+func main() {
+	in := flag.String("in", "", "input whitelist file")
+	out := flag.String("out", "", "output file (default stdout)")
+	from := flag.String("from", "", "input format (detected if empty): "+fmt.Sprint(wlformat.Formats()))
+	to := flag.String("to", string(wlformat.Canonical), "output format: "+fmt.Sprint(wlformat.Formats()))
+	epoch := flag.Int("epoch", -1, "epoch of the whitelist (default: from the input or its file name)")
+	scheme := flag.String("scheme", merkle.Legacy, "Merkle scheme for a root missing from the input: "+fmt.Sprint(merkle.Names()))
+	manifest := flag.String("manifest", "", "snapshot manifest JSON to embed in canonical output")
+	flag.Parse()
+	if *in == "" {
+		log.Fatal("-in is required")
+	}
+	toFormat, err := wlformat.ParseFormat(*to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("read %s: %v", *in, err)
+	}
+	wl, detected, err := wlformat.Parse(data)
+	if err != nil {
+		log.Fatalf("parse %s: %v", *in, err)
+	}
+	if *from != "" && string(detected) != *from {
+		log.Fatalf("%s is %s, not %s", *in, detected, *from)
+	}
+
+	if *epoch >= 0 {
+		wl.Epoch = *epoch
+	} else if m := epochName.FindStringSubmatch(filepath.Base(*in)); m != nil && wl.Epoch == 0 {
+		wl.Epoch, _ = strconv.Atoi(m[1])
+	}
+	if *manifest != "" {
+		raw, err := os.ReadFile(*manifest)
+		if err != nil {
+			log.Fatalf("read manifest: %v", err)
+		}
+		if !json.Valid(raw) {
+			log.Fatalf("manifest %s is not JSON", *manifest)
+		}
+		wl.Manifest = json.RawMessage(bytes.TrimSpace(raw))
+	}
+
+	if wl.Root != "" {
+		name := wl.Scheme
+		if name == "" {
+			name = merkle.Legacy
+		}
+		s, err := merkle.Get(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		root, err := s.Root(wl.Addresses(), wl.Epoch)
+		if err != nil {
+			log.Fatalf("compute root: %v", err)
+		}
+		if root != wl.Root {
+			log.Fatalf("input root %s does not match its addresses (%s root %s)", wl.Root, s.Name(), root)
+		}
+		wl.Scheme = s.Name()
+	} else if toFormat == wlformat.Canonical || toFormat == wlformat.Server {
+		s, err := merkle.Get(*scheme)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if wl.Root, err = s.Root(wl.Addresses(), wl.Epoch); err != nil {
+			log.Fatalf("compute root: %v", err)
+		}
+		wl.Scheme = s.Name()
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = bufio.NewWriter(f)
+	}
+	if err := wlformat.Write(w, wl, toFormat); err != nil {
+		log.Fatalf("write: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("write: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "converted %d addresses of epoch %d from %s to %s\n", len(wl.Entries), wl.Epoch, detected, toFormat)
+}
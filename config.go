@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"idenauthgo/checks"
+	"idenauthgo/datasource"
+	"idenauthgo/merkle"
+	"idenauthgo/sessionscan"
+)
+
+// serverConfig holds the connection and storage settings of the main server.
+// Values are resolved in order: defaults, the JSON config file, environment
+// variables, command-line flags.
+type serverConfig struct {
+	NodeURL     string `json:"node_url"`
+	NodeKey     string `json:"node_key"`
+	FallbackURL string `json:"fallback_url"`
+	IndexerURL  string `json:"indexer_url"`
+	DataDir     string `json:"data_dir"`
+	DBPath      string `json:"db_path"`
+	Port        int    `json:"port"`
+	// MerkleScheme is the default tree scheme for new whitelist roots.
+	MerkleScheme string `json:"merkle_scheme"`
+}
+
+var (
+	idenaRpcUrl    = "http://localhost:9009"
+	fallbackApiUrl = "https://api.idena.io"
+	dataDir        = "data"
+	dbFile         = "./sessions.db"
+	listenPort     = 3030
+	merkleScheme   = merkle.Legacy
+
+	activeConfig = currentServerConfig()
+)
+
+// currentServerConfig returns the settings currently in effect.
+func currentServerConfig() serverConfig {
+	return serverConfig{
+		NodeURL:      idenaRpcUrl,
+		NodeKey:      IDENA_RPC_KEY,
+		FallbackURL:  fallbackApiUrl,
+		IndexerURL:   INDEXER_URL,
+		DataDir:      dataDir,
+		DBPath:       dbFile,
+		Port:         listenPort,
+		MerkleScheme: merkleScheme,
+	}
+}
+
+// loadServerConfig applies the config file at path (if it exists) and the
+// environment on top of the current settings.
+func loadServerConfig(path string) (serverConfig, error) {
+	c := currentServerConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &c); err != nil {
+				return c, fmt.Errorf("parse %s: %w", path, err)
+			}
+		case !os.IsNotExist(err):
+			return c, err
+		}
+	}
+	c.NodeURL = getenv("IDENA_RPC_URL", c.NodeURL)
+	c.NodeKey = getenv("IDENA_RPC_KEY", c.NodeKey)
+	c.FallbackURL = getenv("FALLBACK_API_URL", c.FallbackURL)
+	c.IndexerURL = getenv("INDEXER_URL", c.IndexerURL)
+	c.DataDir = getenv("DATA_DIR", c.DataDir)
+	c.DBPath = getenv("DB_PATH", c.DBPath)
+	c.MerkleScheme = getenv("MERKLE_SCHEME", c.MerkleScheme)
+	if v := os.Getenv("PORT"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("PORT: %w", err)
+		}
+		c.Port = p
+	}
+	return c, nil
+}
+
+// applyFlags overrides c with the command-line flags that were set explicitly.
+func (c *serverConfig) applyFlags(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch f.Name {
+		case "rpc-url":
+			c.NodeURL = v
+		case "fallback-url":
+			c.FallbackURL = v
+		case "indexer-url":
+			c.IndexerURL = v
+		case "data-dir":
+			c.DataDir = v
+		case "db":
+			c.DBPath = v
+		case "port":
+			c.Port, _ = strconv.Atoi(v)
+		case "merkle-scheme":
+			c.MerkleScheme = v
+		}
+	})
+}
+
+func validateServiceURL(name, raw string, required bool) error {
+	if raw == "" {
+		if required {
+			return fmt.Errorf("%s is required", name)
+		}
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http(s) URL, got %q", name, raw)
+	}
+	return nil
+}
+
+func (c serverConfig) validate() error {
+	if err := validateServiceURL("node_url", c.NodeURL, true); err != nil {
+		return err
+	}
+	if err := validateServiceURL("fallback_url", c.FallbackURL, true); err != nil {
+		return err
+	}
+	if err := validateServiceURL("indexer_url", c.IndexerURL, false); err != nil {
+		return err
+	}
+	if c.DBPath == "" {
+		return fmt.Errorf("db_path is required")
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("data_dir is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d out of range", c.Port)
+	}
+	if _, err := merkle.Get(c.MerkleScheme); err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyServerConfig makes c the active configuration and rebuilds the
+// identity data sources.
+func applyServerConfig(c serverConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
+		return fmt.Errorf("data_dir: %w", err)
+	}
+	idenaRpcUrl = c.NodeURL
+	IDENA_RPC_KEY = c.NodeKey
+	fallbackApiUrl = c.FallbackURL
+	INDEXER_URL = c.IndexerURL
+	dataDir = c.DataDir
+	dbFile = c.DBPath
+	listenPort = c.Port
+	merkleScheme = c.MerkleScheme
+	checks.APIBase = c.FallbackURL
+
+	nodeSource = datasource.NewNodeSource(idenaRpcUrl, IDENA_RPC_KEY)
+	apiSource = datasource.NewAPISource(fallbackApiUrl, "")
+	identitySource = newIdentitySource()
+	sessionScan = sessionscan.New(nodeSource, SESSION_BLOCK_CACHE)
+	activeConfig = c
+	log.Printf("[CONFIG] node=%s fallback=%s data=%s db=%s port=%d", c.NodeURL, c.FallbackURL, c.DataDir, c.DBPath, c.Port)
+	return nil
+}
+
+// whitelistPath returns the whitelist file for epoch inside the data dir.
+func whitelistPath(epoch int) string {
+	return filepath.Join(dataDir, fmt.Sprintf("whitelist_epoch_%d.json", epoch))
+}
+
+// redacted returns a copy of c that is safe to display.
+func (c serverConfig) redacted() serverConfig {
+	if c.NodeKey != "" {
+		c.NodeKey = "<redacted>"
+	}
+	return c
+}
+
+// configHandler reports the active configuration with secrets redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, activeConfig.redacted())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func restoreServerConfig(t *testing.T) {
+	t.Helper()
+	saved := currentServerConfig()
+	t.Cleanup(func() {
+		if err := applyServerConfig(saved); err != nil {
+			t.Fatalf("restore config: %v", err)
+		}
+	})
+}
+
+func TestLoadServerConfigPrecedence(t *testing.T) {
+	restoreServerConfig(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.json")
+	file := `{"node_url":"http://node.lan:9009","fallback_url":"http://mirror.lan","port":4000}`
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	t.Setenv("FALLBACK_API_URL", "http://env-mirror.lan")
+	t.Setenv("DATA_DIR", filepath.Join(dir, "wl"))
+
+	cfg, err := loadServerConfig(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 3030, "")
+	if err := fs.Parse([]string{"-port", "5000"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	cfg.applyFlags(fs)
+
+	if cfg.NodeURL != "http://node.lan:9009" || cfg.FallbackURL != "http://env-mirror.lan" || cfg.Port != 5000 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if err := applyServerConfig(cfg); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if idenaRpcUrl != "http://node.lan:9009" || nodeSource.URL != idenaRpcUrl || apiSource.Base != "http://env-mirror.lan" {
+		t.Fatalf("sources not rebuilt: %s %s", nodeSource.URL, apiSource.Base)
+	}
+	if got := whitelistPath(7); got != filepath.Join(dir, "wl", "whitelist_epoch_7.json") {
+		t.Fatalf("unexpected whitelist path %s", got)
+	}
+}
+
+func TestServerConfigValidation(t *testing.T) {
+	base := currentServerConfig()
+	bad := []func(*serverConfig){
+		func(c *serverConfig) { c.NodeURL = "localhost:9009" },
+		func(c *serverConfig) { c.FallbackURL = "" },
+		func(c *serverConfig) { c.IndexerURL = "ftp://indexer" },
+		func(c *serverConfig) { c.Port = 0 },
+		func(c *serverConfig) { c.DBPath = "" },
+	}
+	for i, mutate := range bad {
+		c := base
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("case %d: expected validation error for %+v", i, c)
+		}
+	}
+	if err := base.validate(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+}
+
+func TestConfigHandlerRedactsKey(t *testing.T) {
+	restoreServerConfig(t)
+	cfg := currentServerConfig()
+	cfg.NodeKey = "super-secret"
+	if err := applyServerConfig(cfg); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	configHandler(rr, httptest.NewRequest("GET", "/config", nil))
+	if strings.Contains(rr.Body.String(), "super-secret") {
+		t.Fatalf("key leaked: %s", rr.Body.String())
+	}
+	var out serverConfig
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.NodeKey != "<redacted>" || out.NodeURL != idenaRpcUrl {
+		t.Fatalf("unexpected output %+v", out)
+	}
+}
@@ -0,0 +1,172 @@
+package datasource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// APISource reads identities from the public Idena REST API
+// (https://api.idena.io) or any server exposing the same routes.
+type APISource struct {
+	Base string
+	Key  string
+}
+
+// NewAPISource returns a source for the REST API at base.
+func NewAPISource(base, key string) *APISource {
+	return &APISource{Base: strings.TrimRight(base, "/"), Key: key}
+}
+
+func (a *APISource) Name() string { return "api" }
+
+// Get fetches path relative to the API base and decodes the JSON body into out.
+func (a *APISource) Get(path string, out interface{}) error {
+	u := a.Base + path
+	if a.Key != "" {
+		if strings.Contains(u, "?") {
+			u += "&apikey=" + url.QueryEscape(a.Key)
+		} else {
+			u += "?apikey=" + url.QueryEscape(a.Key)
+		}
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetIdentity combines /Identity/{addr} (state, age) with /Address/{addr}
+// (stake); the identity endpoint does not report the stake.
+func (a *APISource) GetIdentity(addr string) (*Identity, error) {
+	var idResp struct {
+		Result struct {
+			Address string `json:"address"`
+			State   string `json:"state"`
+			Age     int    `json:"age"`
+		} `json:"result"`
+	}
+	idErr := a.Get("/api/Identity/"+addr, &idResp)
+	var addrResp struct {
+		Result struct {
+			Stake string `json:"stake"`
+		} `json:"result"`
+	}
+	addrErr := a.Get("/api/Address/"+addr, &addrResp)
+	stake, _ := strconv.ParseFloat(addrResp.Result.Stake, 64)
+	if idResp.Result.State == "" && stake == 0 {
+		if err := errors.Join(idErr, addrErr); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("identity %s not found", addr)
+	}
+	return &Identity{
+		Address: addr,
+		State:   idResp.Result.State,
+		Stake:   stake,
+		Age:     idResp.Result.Age,
+	}, nil
+}
+
+// GetEpoch returns the latest epoch and its discrimination stake threshold.
+func (a *APISource) GetEpoch() (*EpochInfo, error) {
+	var out struct {
+		Result EpochInfo `json:"result"`
+	}
+	if err := a.Get("/api/Epoch/Last", &out); err != nil {
+		return nil, err
+	}
+	return &out.Result, nil
+}
+
+// EpochIdentities pages through /Epoch/{epoch}/Identities.
+func (a *APISource) EpochIdentities(epoch int) ([]Identity, error) {
+	return allPages(a, epoch)
+}
+
+// EpochIdentitiesPage returns one page of /Epoch/{epoch}/Identities.
+func (a *APISource) EpochIdentitiesPage(epoch int, token string) ([]Identity, string, error) {
+	path := fmt.Sprintf("/api/Epoch/%d/Identities?limit=100", epoch)
+	if token != "" {
+		path += "&continuationToken=" + url.QueryEscape(token)
+	}
+	var out struct {
+		Result       []identityWire `json:"result"`
+		Continuation string         `json:"continuationToken"`
+	}
+	if err := a.Get(path, &out); err != nil {
+		return nil, "", err
+	}
+	return wiresToIdentities(out.Result), out.Continuation, nil
+}
+
+// EpochIdentitiesCount serves /Epoch/{epoch}/Identities/Count.
+func (a *APISource) EpochIdentitiesCount(epoch int) (int, error) {
+	var out struct {
+		Result int `json:"result"`
+	}
+	if err := a.Get(fmt.Sprintf("/api/Epoch/%d/Identities/Count", epoch), &out); err != nil {
+		return 0, err
+	}
+	return out.Result, nil
+}
+
+// ValidationSummary serves /Epoch/{epoch}/Identity/{addr}/ValidationSummary.
+func (a *APISource) ValidationSummary(epoch int, addr string) (*ValidationSummary, error) {
+	var out struct {
+		Result struct {
+			State     string `json:"state"`
+			Stake     string `json:"stake"`
+			Approved  bool   `json:"approved"`
+			Penalized bool   `json:"penalized"`
+		} `json:"result"`
+	}
+	if err := a.Get(fmt.Sprintf("/api/Epoch/%d/Identity/%s/ValidationSummary", epoch, addr), &out); err != nil {
+		return nil, err
+	}
+	stake, _ := strconv.ParseFloat(out.Result.Stake, 64)
+	return &ValidationSummary{
+		State:     out.Result.State,
+		Stake:     stake,
+		Approved:  out.Result.Approved,
+		Penalized: out.Result.Penalized,
+	}, nil
+}
+
+// BadAuthors pages through /Epoch/{epoch}/Authors/Bad and returns the
+// lower-cased addresses.
+func (a *APISource) BadAuthors(epoch int) (map[string]struct{}, error) {
+	bad := make(map[string]struct{})
+	cont := ""
+	for {
+		path := fmt.Sprintf("/api/Epoch/%d/Authors/Bad?limit=100", epoch)
+		if cont != "" {
+			path += "&continuationToken=" + url.QueryEscape(cont)
+		}
+		var out struct {
+			Result []struct {
+				Address string `json:"address"`
+			} `json:"result"`
+			Continuation string `json:"continuationToken"`
+		}
+		if err := a.Get(path, &out); err != nil {
+			return bad, err
+		}
+		for _, r := range out.Result {
+			bad[strings.ToLower(r.Address)] = struct{}{}
+		}
+		if out.Continuation == "" {
+			return bad, nil
+		}
+		cont = out.Continuation
+	}
+}
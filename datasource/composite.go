@@ -0,0 +1,204 @@
+package datasource
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFailures consecutive errors mark a source unhealthy.
+	maxFailures = 3
+	// cooldown is how long an unhealthy source stays demoted.
+	cooldown = time.Minute
+)
+
+// Health reports the recent track record of one source.
+type Health struct {
+	Name        string    `json:"name"`
+	Healthy     bool      `json:"healthy"`
+	Failures    int       `json:"consecutive_failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+}
+
+// Composite tries its sources in order and returns the first usable answer.
+// Sources that failed repeatedly are tried last until their cooldown passes.
+// Empty answers (no state, no identities) fall through to the next source
+// without counting as failures; ErrNotSupported is ignored.
+type Composite struct {
+	sources []IdentitySource
+	mu      sync.Mutex
+	health  []Health
+}
+
+// NewComposite returns a composite over sources in priority order.
+func NewComposite(sources ...IdentitySource) *Composite {
+	c := &Composite{sources: sources, health: make([]Health, len(sources))}
+	for i, s := range sources {
+		c.health[i] = Health{Name: s.Name(), Healthy: true}
+	}
+	return c
+}
+
+func (c *Composite) Name() string {
+	names := make([]string, len(c.sources))
+	for i, s := range c.sources {
+		names[i] = s.Name()
+	}
+	return "composite(" + strings.Join(names, ",") + ")"
+}
+
+// Health returns a snapshot of every source's health.
+func (c *Composite) Health() []Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	out := make([]Health, len(c.health))
+	for i, h := range c.health {
+		h.Healthy = isHealthy(h, now)
+		out[i] = h
+	}
+	return out
+}
+
+func isHealthy(h Health, now time.Time) bool {
+	return h.Failures < maxFailures || now.Sub(h.LastFailure) >= cooldown
+}
+
+// order returns source indexes with healthy sources first, each group in
+// configured order.
+func (c *Composite) order() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var healthy, demoted []int
+	for i, h := range c.health {
+		if isHealthy(h, now) {
+			healthy = append(healthy, i)
+		} else {
+			demoted = append(demoted, i)
+		}
+	}
+	return append(healthy, demoted...)
+}
+
+func (c *Composite) record(i int, err error) {
+	if errors.Is(err, ErrNotSupported) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := &c.health[i]
+	if err == nil {
+		h.Failures = 0
+		h.LastSuccess = time.Now()
+		return
+	}
+	h.Failures++
+	h.LastError = err.Error()
+	h.LastFailure = time.Now()
+}
+
+// try runs fn against each source until it reports success. fn returns
+// ok=false for an empty but error-free answer.
+func (c *Composite) try(op string, fn func(IdentitySource) (bool, error)) error {
+	var errs []error
+	for _, i := range c.order() {
+		s := c.sources[i]
+		ok, err := fn(s)
+		c.record(i, err)
+		if err == nil && ok {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%s: no source returned data", op)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Composite) GetIdentity(addr string) (*Identity, error) {
+	var res *Identity
+	err := c.try("identity", func(s IdentitySource) (bool, error) {
+		id, err := s.GetIdentity(addr)
+		if err != nil {
+			return false, err
+		}
+		res = id
+		return id.State != "", nil
+	})
+	if res != nil {
+		return res, nil
+	}
+	return nil, err
+}
+
+// GetEpoch returns the first answer that carries the discrimination stake
+// threshold. An answer without it falls through to the next source and is
+// only returned if no source knows the threshold.
+func (c *Composite) GetEpoch() (*EpochInfo, error) {
+	var res *EpochInfo
+	err := c.try("epoch", func(s IdentitySource) (bool, error) {
+		info, err := s.GetEpoch()
+		if err != nil {
+			return false, err
+		}
+		if res == nil || info.Threshold > 0 {
+			res = info
+		}
+		return info.Threshold > 0, nil
+	})
+	if res != nil {
+		return res, nil
+	}
+	return nil, err
+}
+
+func (c *Composite) EpochIdentities(epoch int) ([]Identity, error) {
+	var res []Identity
+	err := c.try("epoch identities", func(s IdentitySource) (bool, error) {
+		list, err := s.EpochIdentities(epoch)
+		if err != nil {
+			return false, err
+		}
+		res = list
+		return len(list) > 0, nil
+	})
+	if len(res) > 0 {
+		return res, nil
+	}
+	return res, err
+}
+
+func (c *Composite) ValidationSummary(epoch int, addr string) (*ValidationSummary, error) {
+	var res *ValidationSummary
+	err := c.try("validation summary", func(s IdentitySource) (bool, error) {
+		sum, err := s.ValidationSummary(epoch, addr)
+		if err != nil {
+			return false, err
+		}
+		res = sum
+		return true, nil
+	})
+	return res, err
+}
+
+func (c *Composite) BadAuthors(epoch int) (map[string]struct{}, error) {
+	var res map[string]struct{}
+	err := c.try("bad authors", func(s IdentitySource) (bool, error) {
+		bad, err := s.BadAuthors(epoch)
+		if err != nil {
+			return false, err
+		}
+		res = bad
+		return true, nil
+	})
+	return res, err
+}
@@ -0,0 +1,169 @@
+package datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func stubClient(t *testing.T, fn roundTripFunc) {
+	t.Helper()
+	old := http.DefaultClient
+	http.DefaultClient = &http.Client{Transport: fn}
+	t.Cleanup(func() { http.DefaultClient = old })
+}
+
+func jsonResponse(code int, v interface{}) *http.Response {
+	b, _ := json.Marshal(v)
+	return &http.Response{StatusCode: code, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}
+}
+
+type fakeSource struct {
+	name  string
+	id    *Identity
+	err   error
+	calls int
+}
+
+func (f *fakeSource) Name() string { return f.name }
+func (f *fakeSource) GetIdentity(addr string) (*Identity, error) {
+	f.calls++
+	return f.id, f.err
+}
+func (f *fakeSource) GetEpoch() (*EpochInfo, error)                 { return nil, ErrNotSupported }
+func (f *fakeSource) EpochIdentities(epoch int) ([]Identity, error) { return nil, ErrNotSupported }
+func (f *fakeSource) ValidationSummary(epoch int, addr string) (*ValidationSummary, error) {
+	return nil, ErrNotSupported
+}
+func (f *fakeSource) BadAuthors(epoch int) (map[string]struct{}, error) { return nil, ErrNotSupported }
+
+func TestCompositeFailoverAndDemotion(t *testing.T) {
+	down := &fakeSource{name: "down", err: errors.New("connection refused")}
+	up := &fakeSource{name: "up", id: &Identity{State: "Human", Stake: 1}}
+	c := NewComposite(down, up)
+
+	for i := 0; i < maxFailures; i++ {
+		id, err := c.GetIdentity("0xabc")
+		if err != nil || id.State != "Human" {
+			t.Fatalf("expected failover result, got %+v %v", id, err)
+		}
+	}
+	h := c.Health()
+	if h[0].Healthy || h[0].Failures != maxFailures || h[0].LastError == "" {
+		t.Fatalf("expected demoted source, got %+v", h[0])
+	}
+	if !h[1].Healthy || h[1].LastSuccess.IsZero() {
+		t.Fatalf("expected healthy source, got %+v", h[1])
+	}
+
+	before := down.calls
+	if _, err := c.GetIdentity("0xabc"); err != nil {
+		t.Fatalf("get identity: %v", err)
+	}
+	if down.calls != before {
+		t.Fatalf("demoted source should be tried after healthy ones")
+	}
+}
+
+func TestCompositeEmptyStateFallsThrough(t *testing.T) {
+	empty := &fakeSource{name: "empty", id: &Identity{}}
+	full := &fakeSource{name: "full", id: &Identity{State: "Newbie"}}
+	c := NewComposite(empty, full)
+	id, err := c.GetIdentity("0xabc")
+	if err != nil || id.State != "Newbie" {
+		t.Fatalf("expected second source, got %+v %v", id, err)
+	}
+	if h := c.Health(); h[0].Failures != 0 {
+		t.Fatalf("empty answer should not count as failure: %+v", h[0])
+	}
+	if _, err := c.BadAuthors(1); err == nil {
+		t.Fatalf("expected error when no source supports bad authors")
+	}
+}
+
+func TestAPISourceBadAuthorsPaging(t *testing.T) {
+	var urls []string
+	stubClient(t, func(r *http.Request) (*http.Response, error) {
+		urls = append(urls, r.URL.String())
+		if r.URL.Query().Get("continuationToken") == "" {
+			return jsonResponse(200, map[string]interface{}{
+				"result":            []map[string]string{{"address": "0xAAA"}},
+				"continuationToken": "next",
+			}), nil
+		}
+		return jsonResponse(200, map[string]interface{}{
+			"result": []map[string]string{{"address": "0xBBB"}},
+		}), nil
+	})
+	bad, err := NewAPISource("https://api.example/", "k").BadAuthors(7)
+	if err != nil {
+		t.Fatalf("bad authors: %v", err)
+	}
+	if _, ok := bad["0xaaa"]; !ok || len(bad) != 2 {
+		t.Fatalf("unexpected result %v", bad)
+	}
+	want := []string{
+		"https://api.example/api/Epoch/7/Authors/Bad?limit=100&apikey=k",
+		"https://api.example/api/Epoch/7/Authors/Bad?limit=100&continuationToken=next&apikey=k",
+	}
+	if len(urls) != 2 || urls[0] != want[0] || urls[1] != want[1] {
+		t.Fatalf("unexpected urls %v", urls)
+	}
+}
+
+func TestNodeSourceRPCError(t *testing.T) {
+	stubClient(t, func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(200, map[string]interface{}{
+			"error": map[string]interface{}{"code": -32000, "message": "epoch not found"},
+		}), nil
+	})
+	_, err := NewNodeSource("http://node", "").EpochAt(3)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Message != "epoch not found" {
+		t.Fatalf("expected rpc error, got %v", err)
+	}
+}
+
+func TestEpochThresholdFromGlobalState(t *testing.T) {
+	globalState := true
+	stubClient(t, func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/api/Epoch/Last" {
+			return jsonResponse(200, map[string]interface{}{"result": map[string]interface{}{"epoch": 12, "discriminationStakeThreshold": 15000.5}}), nil
+		}
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Method == "dna_epoch":
+			return jsonResponse(200, map[string]interface{}{"result": map[string]interface{}{"epoch": 12}}), nil
+		case req.Method == "dna_globalState" && globalState:
+			return jsonResponse(200, map[string]interface{}{"result": map[string]interface{}{"discriminationStakeThreshold": "14000.25"}}), nil
+		}
+		return jsonResponse(200, map[string]interface{}{"error": map[string]interface{}{"code": -32601, "message": "method not available"}}), nil
+	})
+	node := NewNodeSource("http://node", "")
+	c := NewComposite(node, NewAPISource("http://api", ""))
+
+	info, err := c.GetEpoch()
+	if err != nil || info.Epoch != 12 || info.Threshold != 14000.25 {
+		t.Fatalf("expected the node's threshold, got %+v %v", info, err)
+	}
+
+	// a node without the threshold does not answer for it
+	globalState = false
+	if info, err := node.GetEpoch(); err != nil || info.Epoch != 12 || info.Threshold != 0 {
+		t.Fatalf("unexpected node epoch %+v %v", info, err)
+	}
+	info, err = c.GetEpoch()
+	if err != nil || info.Threshold != 15000.5 {
+		t.Fatalf("expected the API's threshold, got %+v %v", info, err)
+	}
+}
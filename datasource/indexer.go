@@ -0,0 +1,104 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// IndexerSource reads identities from the rolling indexer's HTTP API. The
+// indexer only keeps recent snapshots, so it can serve epoch identities for
+// the current epoch only.
+type IndexerSource struct {
+	Base string
+}
+
+// NewIndexerSource returns a source for the rolling indexer at base.
+func NewIndexerSource(base string) *IndexerSource {
+	return &IndexerSource{Base: strings.TrimRight(base, "/")}
+}
+
+func (x *IndexerSource) Name() string { return "indexer" }
+
+type indexerSnapshot struct {
+	Address string  `json:"address"`
+	State   string  `json:"state"`
+	Stake   float64 `json:"stake"`
+}
+
+func (x *IndexerSource) get(path string, out interface{}) error {
+	resp, err := http.Get(x.Base + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetIdentity returns the most recent snapshot of addr.
+func (x *IndexerSource) GetIdentity(addr string) (*Identity, error) {
+	var snaps []indexerSnapshot
+	if err := x.get("/identity/"+addr, &snaps); err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("identity %s not indexed", addr)
+	}
+	s := snaps[0]
+	return &Identity{Address: s.Address, State: s.State, Stake: s.Stake}, nil
+}
+
+// GetEpoch returns the epoch as reported by the indexer's node.
+func (x *IndexerSource) GetEpoch() (*EpochInfo, error) {
+	var out struct {
+		Result EpochInfo `json:"result"`
+	}
+	if err := x.get("/api/Epoch/Last", &out); err != nil {
+		return nil, err
+	}
+	if out.Result.Epoch == 0 {
+		return nil, fmt.Errorf("indexer has no epoch data")
+	}
+	return &out.Result, nil
+}
+
+// EpochIdentities returns the latest snapshot of every identity when epoch is
+// the current epoch.
+func (x *IndexerSource) EpochIdentities(epoch int) ([]Identity, error) {
+	info, err := x.GetEpoch()
+	if err != nil {
+		return nil, err
+	}
+	if info.Epoch != epoch {
+		return nil, ErrNotSupported
+	}
+	var snaps []indexerSnapshot
+	if err := x.get("/identities/latest", &snaps); err != nil {
+		return nil, err
+	}
+	list := make([]Identity, 0, len(snaps))
+	for _, s := range snaps {
+		list = append(list, Identity{Address: s.Address, State: s.State, Stake: s.Stake})
+	}
+	return list, nil
+}
+
+// EpochIdentitiesPage serves the whole snapshot as a single page.
+func (x *IndexerSource) EpochIdentitiesPage(epoch int, token string) ([]Identity, string, error) {
+	list, err := x.EpochIdentities(epoch)
+	return list, "", err
+}
+
+// ValidationSummary is not recorded by the indexer.
+func (x *IndexerSource) ValidationSummary(epoch int, addr string) (*ValidationSummary, error) {
+	return nil, ErrNotSupported
+}
+
+// BadAuthors is not recorded by the indexer.
+func (x *IndexerSource) BadAuthors(epoch int) (map[string]struct{}, error) {
+	return nil, ErrNotSupported
+}
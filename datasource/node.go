@@ -0,0 +1,178 @@
+package datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrEmptyResult is returned when a JSON-RPC response carries no result.
+var ErrEmptyResult = errors.New("empty result")
+
+// NodeSource reads identities from an Idena node's JSON-RPC API. The API key,
+// if set, is sent as the "key" field of each request body.
+type NodeSource struct {
+	URL string
+	Key string
+}
+
+// NewNodeSource returns a source for the node at url.
+func NewNodeSource(url, key string) *NodeSource {
+	return &NodeSource{URL: url, Key: key}
+}
+
+func (n *NodeSource) Name() string { return "node" }
+
+// CallRaw performs a JSON-RPC request and decodes the whole response body,
+// including error and continuation fields, into out.
+func (n *NodeSource) CallRaw(method string, params interface{}, out interface{}) error {
+	reqBody := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	}
+	if n.Key != "" {
+		reqBody["key"] = n.Key
+	}
+	b, _ := json.Marshal(reqBody)
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Call performs a JSON-RPC request and decodes the result field into result.
+// Errors reported by the node are returned as *RPCError.
+func (n *NodeSource) Call(method string, params interface{}, result interface{}) error {
+	var wrapper struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := n.CallRaw(method, params, &wrapper); err != nil {
+		return err
+	}
+	if wrapper.Error != nil && wrapper.Error.Message != "" {
+		return wrapper.Error
+	}
+	if len(wrapper.Result) == 0 {
+		return ErrEmptyResult
+	}
+	return json.Unmarshal(wrapper.Result, result)
+}
+
+// GetIdentity returns the current identity of addr.
+func (n *NodeSource) GetIdentity(addr string) (*Identity, error) {
+	return n.IdentityAt(addr, 0)
+}
+
+// IdentityAt returns the identity of addr as of the given epoch, or the
+// current one if epoch is 0.
+func (n *NodeSource) IdentityAt(addr string, epoch int) (*Identity, error) {
+	params := []interface{}{addr}
+	if epoch > 0 {
+		params = append(params, epoch)
+	}
+	var w identityWire
+	if err := n.Call("dna_identity", params, &w); err != nil {
+		return nil, err
+	}
+	id := w.identity()
+	return &id, nil
+}
+
+// Identities returns all identities known to the node.
+func (n *NodeSource) Identities() ([]Identity, error) {
+	var ws []identityWire
+	if err := n.Call("dna_identities", []interface{}{}, &ws); err != nil {
+		return nil, err
+	}
+	return wiresToIdentities(ws), nil
+}
+
+// GetEpoch returns the node's current epoch. dna_epoch does not carry the
+// discrimination stake threshold, so it is read from dna_globalState and
+// left at 0 if the node does not report it.
+func (n *NodeSource) GetEpoch() (*EpochInfo, error) {
+	info, err := n.EpochAt(0)
+	if err != nil {
+		return nil, err
+	}
+	var gs struct {
+		Threshold float64 `json:"discriminationStakeThreshold,string"`
+	}
+	if err := n.Call("dna_globalState", []interface{}{}, &gs); err == nil {
+		info.Threshold = gs.Threshold
+	}
+	return info, nil
+}
+
+// EpochAt returns the data of a past epoch, or the current one if epoch is 0.
+func (n *NodeSource) EpochAt(epoch int) (*EpochInfo, error) {
+	params := []interface{}{}
+	if epoch > 0 {
+		params = append(params, epoch)
+	}
+	var info EpochInfo
+	if err := n.Call("dna_epoch", params, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// EpochIdentities returns the identities recorded for epoch, following all
+// continuation tokens.
+func (n *NodeSource) EpochIdentities(epoch int) ([]Identity, error) {
+	return allPages(n, epoch)
+}
+
+// EpochIdentitiesPage returns one page of dna_epochIdentities. The first page
+// is requested with an empty token; next is empty on the last page.
+func (n *NodeSource) EpochIdentitiesPage(epoch int, token string) ([]Identity, string, error) {
+	var cont interface{} = 0
+	if token != "" {
+		cont = token
+	}
+	var out struct {
+		Result       []identityWire `json:"result"`
+		Continuation string         `json:"continuationToken"`
+		Error        *RPCError      `json:"error"`
+	}
+	if err := n.CallRaw("dna_epochIdentities", []interface{}{epoch, cont}, &out); err != nil {
+		return nil, "", err
+	}
+	if out.Error != nil && out.Error.Message != "" {
+		return nil, "", out.Error
+	}
+	return wiresToIdentities(out.Result), out.Continuation, nil
+}
+
+// ValidationSummary is only served by the REST API.
+func (n *NodeSource) ValidationSummary(epoch int, addr string) (*ValidationSummary, error) {
+	return nil, ErrNotSupported
+}
+
+// BadAuthors is only served by the REST API.
+func (n *NodeSource) BadAuthors(epoch int) (map[string]struct{}, error) {
+	return nil, ErrNotSupported
+}
+
+func wiresToIdentities(ws []identityWire) []Identity {
+	list := make([]Identity, 0, len(ws))
+	for _, w := range ws {
+		list = append(list, w.identity())
+	}
+	return list
+}
@@ -0,0 +1,106 @@
+// Package datasource provides a single interface for reading Idena identity
+// data from the local node, the public REST API or the rolling indexer.
+//
+// All implementations issue requests through http.DefaultClient at call time
+// so tests can stub the transport.
+package datasource
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrNotSupported is returned by sources that cannot serve a request, e.g.
+// validation summaries from a plain node.
+var ErrNotSupported = errors.New("not supported by this source")
+
+// Identity is the identity data shared by all sources.
+type Identity struct {
+	Address string   `json:"address"`
+	State   string   `json:"state"`
+	Stake   float64  `json:"stake"`
+	Age     int      `json:"age"`
+	Penalty string   `json:"penalty,omitempty"`
+	Flags   []string `json:"lastValidationFlags,omitempty"`
+}
+
+// EpochInfo describes the current epoch.
+type EpochInfo struct {
+	Epoch     int     `json:"epoch"`
+	Threshold float64 `json:"discriminationStakeThreshold"`
+}
+
+// ValidationSummary is the outcome of an identity's validation in an epoch.
+type ValidationSummary struct {
+	State     string  `json:"state"`
+	Stake     float64 `json:"stake"`
+	Approved  bool    `json:"approved"`
+	Penalized bool    `json:"penalized"`
+}
+
+// IdentitySource is implemented by every backend.
+type IdentitySource interface {
+	Name() string
+	GetIdentity(addr string) (*Identity, error)
+	GetEpoch() (*EpochInfo, error)
+	EpochIdentities(epoch int) ([]Identity, error)
+	ValidationSummary(epoch int, addr string) (*ValidationSummary, error)
+	BadAuthors(epoch int) (map[string]struct{}, error)
+}
+
+// EpochIdentityPager is implemented by sources that serve the identities of
+// an epoch in pages linked by continuation tokens.
+type EpochIdentityPager interface {
+	Name() string
+	EpochIdentitiesPage(epoch int, token string) (ids []Identity, next string, err error)
+}
+
+// allPages reads every page of an epoch's identities.
+func allPages(p EpochIdentityPager, epoch int) ([]Identity, error) {
+	var list []Identity
+	token := ""
+	for {
+		ids, next, err := p.EpochIdentitiesPage(epoch, token)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, ids...)
+		if next == "" || next == token {
+			return list, nil
+		}
+		token = next
+	}
+}
+
+// RPCError is an error object returned by the node's JSON-RPC API.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// identityWire is the identity object as returned by the node and the API.
+type identityWire struct {
+	Address string   `json:"address"`
+	State   string   `json:"state"`
+	Stake   string   `json:"stake"`
+	Age     int      `json:"age"`
+	Penalty string   `json:"penalty"`
+	Flags   []string `json:"lastValidationFlags"`
+}
+
+func (w identityWire) identity() Identity {
+	stake, _ := strconv.ParseFloat(w.Stake, 64)
+	return Identity{
+		Address: w.Address,
+		State:   w.State,
+		Stake:   stake,
+		Age:     w.Age,
+		Penalty: w.Penalty,
+		Flags:   w.Flags,
+	}
+}
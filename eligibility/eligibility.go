@@ -1,17 +1,29 @@
 package eligibility
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
 // IsEligibleSnapshot checks if a state+stake combination passes the Proof-of-Humanity
 // rules. Humans must meet the dynamic discrimination stake threshold, Verified and
 // Newbie identities need at least 10k iDNA. This helper was duplicated in several
 // packages (server, indexer, strictbuilder). It now lives here for reuse.
 func IsEligibleSnapshot(state string, stake float64, threshold float64) bool {
-	if state == "Human" && stake >= threshold {
-		return true
-	}
-	if (state == "Verified" || state == "Newbie") && stake >= 10000 {
-		return true
+	need, ok := MinStake(state, threshold)
+	return ok && stake >= need
+}
+
+// MinStake returns the stake an identity in state needs under the snapshot
+// rules. ok is false for states that are never eligible.
+func MinStake(state string, threshold float64) (float64, bool) {
+	switch state {
+	case "Human":
+		return threshold, true
+	case "Verified", "Newbie":
+		return 10000, true
 	}
-	return false
+	return 0, false
 }
 
 // IsEligibleFull applies the snapshot rules plus penalty/flip checks.
@@ -21,3 +33,17 @@ func IsEligibleFull(state string, stake float64, penalized, flip bool, threshold
 	}
 	return IsEligibleSnapshot(state, stake, threshold)
 }
+
+// RuleSet describes the whitelist rules applied by IsEligibleFull. Update it
+// whenever the rules change so snapshot manifests record which rules
+// produced a whitelist.
+const RuleSet = `v1
+Human: stake >= epoch threshold
+Verified, Newbie: stake >= 10000
+penalized or flip reported: excluded`
+
+// RuleSetHash returns the hex SHA-256 of RuleSet.
+func RuleSetHash() string {
+	h := sha256.Sum256([]byte(RuleSet))
+	return hex.EncodeToString(h[:])
+}
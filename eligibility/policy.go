@@ -0,0 +1,118 @@
+package eligibility
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Identity is the data a Policy is evaluated against.
+type Identity struct {
+	State string
+	Stake float64
+	Age   int
+}
+
+// Policy is a named set of login requirements. Policies are loaded from a JSON
+// file so different resources can ask for different identities, e.g. Human
+// only for voting while Newbies may read.
+type Policy struct {
+	Name        string `json:"-"`
+	Description string `json:"description,omitempty"`
+	// States lists the accepted identity states. Empty accepts any state,
+	// including unknown identities.
+	States []string `json:"states,omitempty"`
+	// MinStake is a fixed minimum stake in iDNA.
+	MinStake float64 `json:"min_stake,omitempty"`
+	// UseThreshold additionally requires the epoch's discrimination stake
+	// threshold.
+	UseThreshold bool `json:"use_threshold,omitempty"`
+	// MinAge is the minimum identity age in epochs.
+	MinAge int `json:"min_age,omitempty"`
+}
+
+// DefaultPolicy mirrors the server's original login rule.
+func DefaultPolicy() Policy {
+	return Policy{
+		Name:         "default",
+		Description:  "Human, Verified or Newbie with the epoch stake threshold",
+		States:       []string{"Human", "Verified", "Newbie"},
+		UseThreshold: true,
+	}
+}
+
+// SignaturePolicy accepts anyone who proves control of an address.
+func SignaturePolicy() Policy {
+	return Policy{Name: "signature", Description: "any identity with a valid signature"}
+}
+
+// NeedsAge reports whether evaluating p requires the identity age.
+func (p Policy) NeedsAge() bool {
+	return p.MinAge > 0
+}
+
+// Evaluate checks id against the policy and returns the reasons it failed.
+// An empty slice means the identity is accepted.
+func (p Policy) Evaluate(id Identity, threshold float64) []string {
+	var reasons []string
+	if len(p.States) > 0 {
+		if id.State == "" {
+			reasons = append(reasons, "Identity not found or status undefined.")
+		} else if !p.allowsState(id.State) {
+			reasons = append(reasons, fmt.Sprintf("Identity state %s is not eligible.", id.State))
+		}
+	}
+	required := p.MinStake
+	if p.UseThreshold && threshold > required {
+		required = threshold
+	}
+	if required > 0 && id.Stake < required {
+		reasons = append(reasons, fmt.Sprintf("Stake too low: %.3f (%.3f required).", id.Stake, required))
+	}
+	if p.MinAge > 0 && id.Age < p.MinAge {
+		reasons = append(reasons, fmt.Sprintf("Identity too young: age %d (%d required).", id.Age, p.MinAge))
+	}
+	return reasons
+}
+
+func (p Policy) allowsState(state string) bool {
+	for _, s := range p.States {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPolicies reads named policies from a JSON object mapping names to
+// policies. The built-in default and signature policies are always present
+// unless the file overrides them.
+func LoadPolicies(path string) (map[string]Policy, error) {
+	policies := map[string]Policy{
+		"default":   DefaultPolicy(),
+		"signature": SignaturePolicy(),
+	}
+	if path == "" {
+		return policies, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]Policy
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for name, p := range file {
+		if name == "" {
+			return nil, fmt.Errorf("%s: empty policy name", path)
+		}
+		if p.MinStake < 0 || p.MinAge < 0 {
+			return nil, fmt.Errorf("%s: policy %s has negative limits", path, name)
+		}
+		p.Name = name
+		policies[name] = p
+	}
+	return policies, nil
+}
@@ -0,0 +1,56 @@
+package eligibility
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultPolicyMatchesLoginRule(t *testing.T) {
+	p := DefaultPolicy()
+	if r := p.Evaluate(Identity{State: "Newbie", Stake: 12000}, 10000); len(r) != 0 {
+		t.Fatalf("expected eligible, got %v", r)
+	}
+	if r := p.Evaluate(Identity{State: "Human", Stake: 5000}, 10000); len(r) != 1 {
+		t.Fatalf("expected stake reason, got %v", r)
+	}
+	if r := p.Evaluate(Identity{State: "Suspended", Stake: 5000}, 10000); len(r) != 2 {
+		t.Fatalf("expected state and stake reasons, got %v", r)
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	data := `{"voting":{"states":["Human"],"use_threshold":true},"veteran":{"states":["Human"],"min_age":10}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := LoadPolicies(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := policies["default"]; !ok {
+		t.Fatalf("built-in default policy missing")
+	}
+	voting := policies["voting"]
+	if voting.Name != "voting" {
+		t.Fatalf("policy name not set: %+v", voting)
+	}
+	if r := voting.Evaluate(Identity{State: "Verified", Stake: 50000}, 10000); len(r) == 0 {
+		t.Fatalf("voting policy should reject Verified identities")
+	}
+	veteran := policies["veteran"]
+	if !veteran.NeedsAge() {
+		t.Fatalf("veteran policy should need the identity age")
+	}
+	if r := veteran.Evaluate(Identity{State: "Human", Age: 3}, 0); len(r) != 1 {
+		t.Fatalf("expected age reason, got %v", r)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"bad":{"min_age":-1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicies(path); err == nil {
+		t.Fatalf("expected error for negative limits")
+	}
+}
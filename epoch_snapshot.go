@@ -31,10 +31,26 @@ func ensureEpochSnapshotTable(db *sql.DB) error {
 
 // upsertEpochSnapshots inserts or updates eligibility records for an epoch.
 func upsertEpochSnapshots(db *sql.DB, epoch int, snaps []EpochSnapshot) error {
+	return writeEpochSnapshots(db, epoch, snaps, false)
+}
+
+// replaceEpochSnapshots stores snaps as the complete input set of an epoch,
+// removing records of identities that are no longer part of it.
+func replaceEpochSnapshots(db *sql.DB, epoch int, snaps []EpochSnapshot) error {
+	return writeEpochSnapshots(db, epoch, snaps, true)
+}
+
+func writeEpochSnapshots(db *sql.DB, epoch int, snaps []EpochSnapshot, replace bool) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM epoch_identity_snapshot WHERE epoch=?`, epoch); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO epoch_identity_snapshot(epoch,address,state,stake,penalized,flipReported) VALUES(?,?,?,?,?,?)`)
 	if err != nil {
 		tx.Rollback()
@@ -51,6 +67,28 @@ func upsertEpochSnapshots(db *sql.DB, epoch int, snaps []EpochSnapshot) error {
 	return tx.Commit()
 }
 
+// loadEpochSnapshots returns every stored record of an epoch ordered by
+// address.
+func loadEpochSnapshots(db *sql.DB, epoch int) ([]EpochSnapshot, error) {
+	rows, err := db.Query(`SELECT address, state, stake, penalized, flipReported FROM epoch_identity_snapshot WHERE epoch=? ORDER BY address`, epoch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var snaps []EpochSnapshot
+	for rows.Next() {
+		var s EpochSnapshot
+		var pen, fr int
+		if err := rows.Scan(&s.Address, &s.State, &s.Stake, &pen, &fr); err != nil {
+			return nil, err
+		}
+		s.Penalized = pen != 0
+		s.FlipReported = fr != 0
+		snaps = append(snaps, s)
+	}
+	return snaps, rows.Err()
+}
+
 // queryEpochSnapshot returns the stored eligibility snapshot for an address and epoch.
 // The ok flag is false if no record exists.
 func queryEpochSnapshot(db *sql.DB, epoch int, addr string) (state string, stake float64, penalized, flip bool, ok bool, err error) {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"idenauthgo/datasource"
+	"idenauthgo/epochids"
+)
+
+// Epoch identity lists are read page by page through the epochids package.
+// Pages are stored in the database until the list is complete, so a restart
+// resumes the enumeration, and a list shorter than the identity count the
+// public API reports for the epoch is never published.
+
+var (
+	EPOCH_PAGE_RETRIES    = getenvInt("EPOCH_PAGE_RETRIES", 3)
+	EPOCH_PAGE_BACKOFF_MS = getenvInt("EPOCH_PAGE_BACKOFF_MS", 1000)
+	// EPOCH_COUNT_CHECK compares every list with the public API's identity
+	// count. Without it a list is complete once its last page was read, so
+	// a node-only setup makes no public API call here.
+	EPOCH_COUNT_CHECK = getenv("EPOCH_COUNT_CHECK", "true") == "true"
+)
+
+var epochEnumStore epochids.Store
+
+func createEpochEnumTables() {
+	s, err := epochids.NewSQLStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	epochEnumStore = s
+}
+
+// enumerateEpochIdentities reads the identities of epoch from the first
+// source that returns a complete list. If a source could only deliver part
+// of the list and no other source completes it, the returned error wraps
+// epochids.ErrTruncated.
+func enumerateEpochIdentities(epoch int) ([]datasource.Identity, string, error) {
+	var errs []error
+	truncated := false
+	var count func(epoch int) (int, error)
+	if EPOCH_COUNT_CHECK {
+		count = apiSource.EpochIdentitiesCount
+	}
+	for _, src := range epochIdentityPagers() {
+		e := &epochids.Enumerator{
+			Source:  src,
+			Count:   count,
+			Store:   epochEnumStore,
+			Retries: EPOCH_PAGE_RETRIES,
+			Backoff: time.Duration(EPOCH_PAGE_BACKOFF_MS) * time.Millisecond,
+		}
+		res, err := e.Enumerate(epoch)
+		if err == nil && len(res.Identities) > 0 {
+			log.Printf("[EPOCHIDS] epoch %d: %d identities from %s in %d pages", epoch, len(res.Identities), src.Name(), res.Pages)
+			return res.Identities, src.Name(), nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: no identities", src.Name())
+		}
+		if errors.Is(err, epochids.ErrTruncated) {
+			truncated = true
+		}
+		log.Printf("[EPOCHIDS] epoch %d: %v", epoch, err)
+		errs = append(errs, err)
+	}
+	err := errors.Join(errs...)
+	if truncated {
+		return nil, "", fmt.Errorf("%w: no source delivered the complete list: %v", epochids.ErrTruncated, err)
+	}
+	return nil, "", err
+}
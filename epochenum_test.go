@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"idenauthgo/datasource"
+	"idenauthgo/epochids"
+)
+
+func TestEpochIdentityEnumeration(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldNode, oldAPI, oldRetries, oldCheck := dataDir, nodeSource, apiSource, EPOCH_PAGE_RETRIES, EPOCH_COUNT_CHECK
+	dataDir = t.TempDir()
+	EPOCH_PAGE_RETRIES = 0
+	defer func() {
+		dataDir, nodeSource, apiSource, EPOCH_PAGE_RETRIES, EPOCH_COUNT_CHECK = oldDir, oldNode, oldAPI, oldRetries, oldCheck
+	}()
+
+	secondPage := true
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method != "dna_epochIdentities" {
+			t.Errorf("unexpected node method %s", req.Method)
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": 1}
+		switch {
+		case req.Params[1] == float64(0):
+			resp["result"] = []map[string]string{
+				{"address": "0xAAA", "state": "Human", "stake": "20000", "penalty": "0"},
+				{"address": "0xbbb", "state": "Human", "stake": "20000", "penalty": "0"},
+			}
+			resp["continuationToken"] = "p2"
+		case secondPage:
+			resp["result"] = []map[string]string{
+				{"address": "0xaaa", "state": "Human", "stake": "20000", "penalty": "0"},
+				{"address": "0xccc", "state": "Human", "stake": "20000", "penalty": "0"},
+			}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32000, "message": "busy"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer node.Close()
+	counts := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/Identities/Count") {
+			counts++
+			json.NewEncoder(w).Encode(map[string]int{"result": 3})
+			return
+		}
+		http.Error(w, "offline", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+	nodeSource = datasource.NewNodeSource(node.URL, "")
+	apiSource = datasource.NewAPISource(api.URL, "")
+
+	ids, err := fetchEpochIdentities(10)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(ids) != 3 || ids[0].Address != "0xaaa" || ids[2].Address != "0xccc" || counts != 1 {
+		t.Fatalf("unexpected identities %+v after %d count requests", ids, counts)
+	}
+
+	// without the count check the node's list is taken as is
+	EPOCH_COUNT_CHECK = false
+	if ids, err = fetchEpochIdentities(10); err != nil || len(ids) != 3 || counts != 1 {
+		t.Fatalf("without count check: %d identities, %d count requests, %v", len(ids), counts, err)
+	}
+	EPOCH_COUNT_CHECK = true
+
+	// the second page fails and the API cannot serve the list either: the
+	// build must fail instead of publishing two of three identities
+	secondPage = false
+	saveCheckpoint(10, checkSourceNode, EpochSnapshot{Address: "0xaaa", State: "Human", Stake: 20000})
+	err = buildEpochWhitelist(10, 10000)
+	if !errors.Is(err, epochids.ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+	if _, err := os.Stat(whitelistPath(10)); !os.IsNotExist(err) {
+		t.Fatalf("truncated whitelist was written: %v", err)
+	}
+	if cps := loadCheckpoints(10, checkSourceNode); len(cps) != 0 {
+		t.Fatalf("checkpoints kept after a truncated build: %v", cps)
+	}
+}
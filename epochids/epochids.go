@@ -0,0 +1,189 @@
+// Package epochids enumerates all identities of an epoch page by page. Every
+// page is retried on failure and stored together with its continuation
+// token, so an interrupted enumeration resumes where it stopped. The result
+// is de-duplicated and compared with the identity count the epoch reports,
+// and an enumeration that could not be completed is reported as truncated
+// instead of returning a partial list.
+package epochids
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"idenauthgo/datasource"
+)
+
+// ErrTruncated is wrapped by errors of enumerations that did not read the
+// whole epoch. A truncated list must not be published.
+var ErrTruncated = errors.New("epoch identity list truncated")
+
+// DefaultMaxPages bounds an enumeration so a source that keeps returning new
+// continuation tokens cannot loop forever.
+const DefaultMaxPages = 10000
+
+// Store keeps the progress of unfinished enumerations.
+type Store interface {
+	// Load returns the next continuation token, the number of pages read
+	// and the identities stored so far.
+	Load(epoch int, source string) (token string, pages int, ids []datasource.Identity, err error)
+	// SavePage stores the identities of a page together with the token of
+	// the next page.
+	SavePage(epoch int, source string, ids []datasource.Identity, next string, pages int) error
+	// Clear drops the progress of an enumeration.
+	Clear(epoch int, source string) error
+}
+
+// Enumerator reads the identities of an epoch from Source.
+type Enumerator struct {
+	Source datasource.EpochIdentityPager
+	// Count returns the number of identities the epoch reports. It is
+	// optional; when it is nil or fails the check is skipped.
+	Count func(epoch int) (int, error)
+	// Store is optional; without it nothing is resumed.
+	Store Store
+	// Retries is the number of extra attempts per page, Backoff the delay
+	// before the first retry, doubled on every further attempt.
+	Retries int
+	Backoff time.Duration
+	// PageDelay is the pause between pages.
+	PageDelay time.Duration
+	MaxPages  int
+}
+
+// Result is a complete enumeration.
+type Result struct {
+	Identities []datasource.Identity
+	Pages      int
+	// Duplicates is the number of records dropped because their address had
+	// already been read.
+	Duplicates int
+	// Expected is the reported identity count, or -1 if it is unknown.
+	Expected int
+}
+
+// Enumerate reads every page of epoch. Addresses are lower-cased and the
+// first record of an address is kept. The error wraps ErrTruncated if pages
+// were read but the list could not be completed or is shorter than the
+// reported count.
+func (e *Enumerator) Enumerate(epoch int) (*Result, error) {
+	name := e.Source.Name()
+	token, pages := "", 0
+	var stored []datasource.Identity
+	if e.Store != nil {
+		var err error
+		if token, pages, stored, err = e.Store.Load(epoch, name); err != nil {
+			log.Printf("[EPOCHIDS] %s epoch %d: load progress: %v", name, epoch, err)
+			token, pages, stored = "", 0, nil
+		} else if pages > 0 {
+			log.Printf("[EPOCHIDS] %s epoch %d: resuming after %d pages, %d identities", name, epoch, pages, len(stored))
+		}
+	}
+	res := &Result{Expected: -1}
+	seen := make(map[string]struct{})
+	add := func(ids []datasource.Identity) []datasource.Identity {
+		var fresh []datasource.Identity
+		for _, id := range ids {
+			id.Address = strings.ToLower(id.Address)
+			if id.Address == "" {
+				continue
+			}
+			if _, ok := seen[id.Address]; ok {
+				res.Duplicates++
+				continue
+			}
+			seen[id.Address] = struct{}{}
+			fresh = append(fresh, id)
+		}
+		res.Identities = append(res.Identities, fresh...)
+		return fresh
+	}
+	add(stored)
+
+	maxPages := e.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+	tokens := map[string]struct{}{token: {}}
+	// a stored progress with an empty token has read its last page already
+	for pages == 0 || token != "" {
+		if pages >= maxPages {
+			return nil, fmt.Errorf("%w: %s epoch %d: more than %d pages", ErrTruncated, name, epoch, maxPages)
+		}
+		if pages > 0 && e.PageDelay > 0 {
+			time.Sleep(e.PageDelay)
+		}
+		ids, next, err := e.page(epoch, token)
+		if err != nil {
+			if pages == 0 {
+				return nil, fmt.Errorf("%s epoch %d: %w", name, epoch, err)
+			}
+			return nil, fmt.Errorf("%w: %s epoch %d: page %d: %v", ErrTruncated, name, epoch, pages+1, err)
+		}
+		if _, ok := tokens[next]; ok && next != "" {
+			return nil, fmt.Errorf("%w: %s epoch %d: continuation token %q repeated", ErrTruncated, name, epoch, next)
+		}
+		tokens[next] = struct{}{}
+		pages++
+		fresh := add(ids)
+		if e.Store != nil {
+			if err := e.Store.SavePage(epoch, name, fresh, next, pages); err != nil {
+				log.Printf("[EPOCHIDS] %s epoch %d: save page %d: %v", name, epoch, pages, err)
+			}
+		}
+		token = next
+	}
+	res.Pages = pages
+	if res.Duplicates > 0 {
+		log.Printf("[EPOCHIDS] %s epoch %d: dropped %d duplicate records", name, epoch, res.Duplicates)
+	}
+
+	if e.Count != nil {
+		n, err := e.Count(epoch)
+		if err != nil {
+			log.Printf("[EPOCHIDS] %s epoch %d: identity count unavailable: %v", name, epoch, err)
+		} else {
+			res.Expected = n
+		}
+	}
+	if res.Expected >= 0 && len(res.Identities) < res.Expected {
+		// there is no page left to resume from, the next attempt starts over
+		e.clear(epoch, name)
+		return nil, fmt.Errorf("%w: %s epoch %d: %d identities, epoch reports %d", ErrTruncated, name, epoch, len(res.Identities), res.Expected)
+	}
+	if res.Expected >= 0 && len(res.Identities) > res.Expected {
+		log.Printf("[EPOCHIDS] %s epoch %d: %d identities, more than the reported %d", name, epoch, len(res.Identities), res.Expected)
+	}
+	e.clear(epoch, name)
+	return res, nil
+}
+
+// page reads one page, retrying with exponential backoff.
+func (e *Enumerator) page(epoch int, token string) ([]datasource.Identity, string, error) {
+	backoff := e.Backoff
+	var err error
+	for attempt := 0; attempt <= e.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("[EPOCHIDS] %s epoch %d: retrying page after %v: %v", e.Source.Name(), epoch, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var ids []datasource.Identity
+		var next string
+		if ids, next, err = e.Source.EpochIdentitiesPage(epoch, token); err == nil {
+			return ids, next, nil
+		}
+	}
+	return nil, "", err
+}
+
+func (e *Enumerator) clear(epoch int, name string) {
+	if e.Store == nil {
+		return
+	}
+	if err := e.Store.Clear(epoch, name); err != nil {
+		log.Printf("[EPOCHIDS] %s epoch %d: clear progress: %v", name, epoch, err)
+	}
+}
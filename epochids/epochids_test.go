@@ -0,0 +1,155 @@
+package epochids
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"idenauthgo/datasource"
+)
+
+// fakePager serves pages keyed by token. Tokens listed in fail fail that
+// many times before they are served.
+type fakePager struct {
+	pages map[string]fakePage
+	fail  map[string]int
+	calls []string
+}
+
+type fakePage struct {
+	addrs []string
+	next  string
+}
+
+func (f *fakePager) Name() string { return "fake" }
+
+func (f *fakePager) EpochIdentitiesPage(epoch int, token string) ([]datasource.Identity, string, error) {
+	f.calls = append(f.calls, token)
+	if f.fail[token] > 0 {
+		f.fail[token]--
+		return nil, "", fmt.Errorf("page %q unavailable", token)
+	}
+	p, ok := f.pages[token]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown token %q", token)
+	}
+	var ids []datasource.Identity
+	for _, a := range p.addrs {
+		ids = append(ids, datasource.Identity{Address: a, State: "Human", Stake: 100, Flags: []string{"AtLeastOneFlipReported"}})
+	}
+	return ids, p.next, nil
+}
+
+func threePages() *fakePager {
+	return &fakePager{
+		pages: map[string]fakePage{
+			"":   {[]string{"0xAAA", "0xbbb"}, "t1"},
+			"t1": {[]string{"0xccc", "0xaaa"}, "t2"},
+			"t2": {[]string{"0xddd"}, ""},
+		},
+		fail: map[string]int{},
+	}
+}
+
+func count(n int) func(int) (int, error) {
+	return func(int) (int, error) { return n, nil }
+}
+
+func newStore(t *testing.T) *SQLStore {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	s, err := NewSQLStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func addresses(ids []datasource.Identity) []string {
+	var out []string
+	for _, id := range ids {
+		out = append(out, id.Address)
+	}
+	return out
+}
+
+func TestEnumerateDedupAndRetry(t *testing.T) {
+	src := threePages()
+	src.fail["t1"] = 2
+	e := &Enumerator{Source: src, Count: count(4), Retries: 2}
+	res, err := e.Enumerate(7)
+	if err != nil {
+		t.Fatalf("enumerate: %v", err)
+	}
+	if got := fmt.Sprint(addresses(res.Identities)); got != "[0xaaa 0xbbb 0xccc 0xddd]" {
+		t.Fatalf("unexpected identities %s", got)
+	}
+	if res.Pages != 3 || res.Duplicates != 1 || res.Expected != 4 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestEnumerateTruncated(t *testing.T) {
+	src := threePages()
+	src.fail["t2"] = 5
+	e := &Enumerator{Source: src, Retries: 1}
+	if _, err := e.Enumerate(7); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated for a failing page, got %v", err)
+	}
+
+	// a short list is truncated even if every page was read
+	e = &Enumerator{Source: threePages(), Count: count(10)}
+	if _, err := e.Enumerate(7); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated below the reported count, got %v", err)
+	}
+
+	// a failing first page is not a truncation
+	src = threePages()
+	src.fail[""] = 1
+	e = &Enumerator{Source: src}
+	if _, err := e.Enumerate(7); err == nil || errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected a plain error, got %v", err)
+	}
+
+	loop := threePages()
+	loop.pages["t2"] = fakePage{[]string{"0xddd"}, "t1"}
+	e = &Enumerator{Source: loop}
+	if _, err := e.Enumerate(7); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated for a token loop, got %v", err)
+	}
+}
+
+func TestEnumerateResumes(t *testing.T) {
+	store := newStore(t)
+	src := threePages()
+	src.fail["t2"] = 1
+	e := &Enumerator{Source: src, Count: count(4), Store: store}
+	if _, err := e.Enumerate(7); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+
+	src.calls = nil
+	res, err := e.Enumerate(7)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if fmt.Sprint(src.calls) != "[t2]" {
+		t.Fatalf("resumed enumeration read pages %q", src.calls)
+	}
+	if got := fmt.Sprint(addresses(res.Identities)); got != "[0xaaa 0xbbb 0xccc 0xddd]" {
+		t.Fatalf("unexpected identities %s", got)
+	}
+	if len(res.Identities[0].Flags) != 1 || res.Identities[0].Stake != 100 {
+		t.Fatalf("stored identity not restored: %+v", res.Identities[0])
+	}
+	if token, pages, ids, err := store.Load(7, "fake"); err != nil || token != "" || pages != 0 || len(ids) != 0 {
+		t.Fatalf("progress not cleared: %q %d %v %v", token, pages, ids, err)
+	}
+}
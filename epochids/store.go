@@ -0,0 +1,110 @@
+package epochids
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"idenauthgo/datasource"
+)
+
+// SQLStore keeps enumeration progress in the epoch_enum_progress and
+// epoch_enum_identities tables.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore creates the tables if needed.
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS epoch_enum_progress (
+            epoch INTEGER,
+            source TEXT,
+            token TEXT,
+            pages INTEGER,
+            ts INTEGER,
+            PRIMARY KEY (epoch, source)
+        );
+        CREATE TABLE IF NOT EXISTS epoch_enum_identities (
+            epoch INTEGER,
+            source TEXT,
+            address TEXT,
+            state TEXT,
+            stake REAL,
+            age INTEGER,
+            penalty TEXT,
+            flags TEXT,
+            PRIMARY KEY (epoch, source, address)
+        )`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// Load implements Store.
+func (s *SQLStore) Load(epoch int, source string) (string, int, []datasource.Identity, error) {
+	var token string
+	var pages int
+	err := s.db.QueryRow(`SELECT token, pages FROM epoch_enum_progress WHERE epoch=? AND source=?`, epoch, source).Scan(&token, &pages)
+	if err == sql.ErrNoRows {
+		return "", 0, nil, nil
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+	rows, err := s.db.Query(`SELECT address, state, stake, age, penalty, flags FROM epoch_enum_identities WHERE epoch=? AND source=? ORDER BY rowid`, epoch, source)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer rows.Close()
+	var ids []datasource.Identity
+	for rows.Next() {
+		var id datasource.Identity
+		var flags string
+		if err := rows.Scan(&id.Address, &id.State, &id.Stake, &id.Age, &id.Penalty, &flags); err != nil {
+			return "", 0, nil, err
+		}
+		json.Unmarshal([]byte(flags), &id.Flags)
+		ids = append(ids, id)
+	}
+	return token, pages, ids, rows.Err()
+}
+
+// SavePage implements Store. The page and the token are written in one
+// transaction, so a crash cannot store one without the other.
+func (s *SQLStore) SavePage(epoch int, source string, ids []datasource.Identity, next string, pages int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		flags, _ := json.Marshal(id.Flags)
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO epoch_enum_identities(epoch, source, address, state, stake, age, penalty, flags) VALUES(?,?,?,?,?,?,?,?)`,
+			epoch, source, id.Address, id.State, id.Stake, id.Age, id.Penalty, string(flags)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO epoch_enum_progress(epoch, source, token, pages, ts) VALUES(?,?,?,?,?)`,
+		epoch, source, next, pages, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Clear implements Store.
+func (s *SQLStore) Clear(epoch int, source string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM epoch_enum_identities WHERE epoch=? AND source=?`, epoch, source); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM epoch_enum_progress WHERE epoch=? AND source=?`, epoch, source); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
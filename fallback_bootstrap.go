@@ -1,155 +1,73 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	"os"
-	"sort"
 	"strconv"
-	"strings"
-	"time"
 
 	"idenauthgo/checks"
-	"idenauthgo/eligibility"
+	"idenauthgo/sessionscan"
 )
 
-// requiredBlocks defines how many consecutive blocks after short session start
-// are scanned for validation transactions. Overridable in tests.
-var requiredBlocks = 7
-
-// apiGet performs a GET request to the fallback API and decodes the JSON result
-func apiGet(path string, out interface{}) error {
-	url := strings.TrimRight(fallbackApiUrl, "/") + path
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %s", resp.Status)
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
-}
-
-// validation summary helper removed; use checks package
-
+// buildEpochWhitelistAPI builds the whitelist when the node cannot list the
+// epoch's identities. The candidates are the short answer senders of the
+// validation session found in the node's blocks; their validation outcome is
+// read from the public API. The scan walks back from the epoch's first
+// block, so the node must already be in epoch.
 func buildEpochWhitelistAPI(epoch int, threshold float64) error {
 	lastEpoch := epoch - 1
-	var epInfo struct {
-		Result struct {
-			ValidationFirstBlock int `json:"validationFirstBlockHeight"`
-		} `json:"result"`
-	}
-	if err := apiGet(fmt.Sprintf("/api/Epoch/%d", lastEpoch), &epInfo); err != nil {
-		return fmt.Errorf("epoch info: %w", err)
-	}
-	guess := epInfo.Result.ValidationFirstBlock + 15
-	shortStart := 0
-	for h := guess; h < guess+20; h++ {
-		var blk struct {
-			Result struct {
-				Flags []string `json:"flags"`
-			} `json:"result"`
-		}
-		if err := apiGet(fmt.Sprintf("/api/Block/%d", h), &blk); err != nil {
-			continue
-		}
-		for _, f := range blk.Result.Flags {
-			if f == "ShortSessionStarted" {
-				shortStart = h
-				break
-			}
-		}
-		if shortStart != 0 {
-			break
-		}
-	}
-	if shortStart == 0 {
-		return fmt.Errorf("ShortSessionStarted block not found")
-	}
-	unique := make(map[string]struct{})
-	height := shortStart
-	blocks := 0
-	for blocks < requiredBlocks {
-		cont := ""
-		hasTx := false
-		for {
-			path := fmt.Sprintf("/api/Block/%d/Txs?limit=100", height)
-			if cont != "" {
-				path += "&continuationToken=" + cont
-			}
-			var txRes struct {
-				Result []struct {
-					From string `json:"from"`
-				} `json:"result"`
-				Continuation string `json:"continuationToken"`
-			}
-			if err := apiGet(path, &txRes); err != nil {
-				break
-			}
-			for _, tx := range txRes.Result {
-				if tx.From != "" {
-					unique[strings.ToLower(tx.From)] = struct{}{}
-					hasTx = true
-				}
-			}
-			if txRes.Continuation == "" {
-				break
-			}
-			cont = txRes.Continuation
-			time.Sleep(100 * time.Millisecond)
-		}
-		if hasTx {
-			blocks++
-		}
-		height++
+	rep, err := sessionScan.ScanEpoch(epoch)
+	if err != nil {
+		return fmt.Errorf("validation session: %w", err)
 	}
-	addresses := make([]string, 0, len(unique))
-	for a := range unique {
-		addresses = append(addresses, a)
+	logSessionReport(rep)
+	addresses := rep.Senders
+	bad, err := checks.BadAuthors(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch)
+	if err != nil {
+		return fmt.Errorf("bad authors: %w", err)
 	}
-	var snaps []EpochSnapshot
-	var list []string
-	for _, addr := range addresses {
+	checked, _ := runChecks(epoch, checkSourceSummary, addresses, func(addr string) (EpochSnapshot, error) {
 		sum, err := checks.FetchValidationSummary(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch, addr)
 		if err != nil {
-			continue
-		}
-		penalized, flip, err := checks.CheckPenaltyFlipForEpoch(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch, addr)
-		if err != nil {
-			continue
+			return EpochSnapshot{}, err
 		}
 		stake, _ := strconv.ParseFloat(sum.Stake, 64)
-		snaps = append(snaps, EpochSnapshot{
-			Address:      addr,
+		_, flip := bad[addr]
+		return EpochSnapshot{
 			State:        sum.State,
 			Stake:        stake,
-			Penalized:    penalized,
+			Penalized:    sum.Penalized || !sum.Approved,
 			FlipReported: flip,
-		})
-		if eligibility.IsEligibleFull(sum.State, stake, penalized, flip, threshold) {
-			list = append(list, addr)
+		}, nil
+	})
+	// addresses whose checks failed are left out
+	snaps := make([]EpochSnapshot, 0, len(checked))
+	for _, addr := range addresses {
+		if s, ok := checked[addr]; ok {
+			snaps = append(snaps, s)
 		}
 	}
-	if err := upsertEpochSnapshots(db, epoch, snaps); err != nil {
-		return err
+	list, root, err := publishEpochWhitelist(epoch, threshold, manifestSourceAPI, "", blockRef{Height: rep.ShortStart, Hash: rep.ShortStartHash}, snaps, len(addresses), len(addresses)-len(checked))
+	if err == nil || errors.Is(err, errWhitelistQuarantined) {
+		// a candidate keeps its own records, the next build starts over
+		clearCheckpoints(epoch)
 	}
-	sort.Strings(list)
-	root := computeMerkleRoot(list)
-	saveMerkleRoot(epoch, root)
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
-	data, _ := json.MarshalIndent(map[string]interface{}{
-		"merkle_root": root,
-		"addresses":   list,
-	}, "", "  ")
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err != nil {
 		return err
 	}
-	wlMu.Lock()
-	currentWhitelist = list
-	wlMu.Unlock()
-	log.Printf("[WHITELIST] built via official API for epoch %d with %d addresses root=%s", epoch, len(list), root)
+	log.Printf("[WHITELIST] built from the node's session scan for epoch %d with %d addresses root=%s", epoch, len(list), root)
 	return nil
 }
+
+// logSessionReport logs the session window and the submissions of every
+// block that had any, so operators can check the scan covered the whole
+// window.
+func logSessionReport(rep *sessionscan.Report) {
+	log.Printf("[SESSION] epoch %d: %s", rep.Epoch, rep.Summary())
+	for _, c := range rep.Blocks {
+		if c.AnswerHashes+c.ShortAnswers > 0 {
+			log.Printf("[SESSION] block %d (%s): %d answer hashes, %d short answers", c.Height, c.Phase, c.AnswerHashes, c.ShortAnswers)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -46,16 +47,17 @@ func blockHasFlag(b *block, flag string) bool {
 	return false
 }
 
-// watchEpochFinalization waits for the EpochFinalized flag and triggers the
-// whitelist snapshot exactly once per epoch.
+// watchEpochFinalization waits for the EpochFinalized flag and builds the
+// whitelist of the new epoch. A build that fails, for example because the
+// identity list was truncated, is retried on every tick until the list is
+// published. A quarantined list is not rebuilt; it waits for
+// /admin/whitelist/approve.
 func watchEpochFinalization() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	wlMu.RLock()
-	last := currentEpoch
-	wlMu.RUnlock()
-
+	// a build that failed before the watcher started is retried as well
+	retry := true
 	for {
 		blk, err := fetchLastBlock(idenaRpcUrl, IDENA_RPC_KEY)
 		if err != nil {
@@ -63,24 +65,32 @@ func watchEpochFinalization() {
 			<-ticker.C
 			continue
 		}
-		if blockHasFlag(blk, "EpochFinalized") && blk.Epoch > last {
+		wlMu.RLock()
+		cur := currentEpoch
+		wlMu.RUnlock()
+		if (retry || blockHasFlag(blk, "EpochFinalized")) && blk.Epoch > cur {
 			epoch, thr, err := fetchEpochData()
 			if err != nil {
 				log.Printf("[FINALIZE] epoch fetch: %v", err)
-				<-ticker.C
-				continue
-			}
-			if err := buildEpochWhitelist(epoch, thr); err != nil {
-				log.Printf("[FINALIZE] build whitelist: %v", err)
+				retry = true
 			} else {
-				wlMu.Lock()
-				currentEpoch = epoch
-				wlMu.Unlock()
-				setConfigInt("current_epoch", epoch)
-				saveSnapshotMeta(epoch, blk.Height)
-				last = epoch
+				retry = buildFinalizedEpoch(epoch, thr, blk.Height)
 			}
 		}
 		<-ticker.C
 	}
 }
+
+// buildFinalizedEpoch builds and publishes the whitelist of epoch and
+// reports whether the build has to be retried.
+func buildFinalizedEpoch(epoch int, thr float64, height int) bool {
+	if _, err := loadCandidate(epoch); err == nil {
+		return false
+	}
+	if err := buildEpochWhitelist(epoch, thr); err != nil {
+		log.Printf("[FINALIZE] build whitelist: %v", err)
+		return !errors.Is(err, errWhitelistQuarantined)
+	}
+	saveSnapshotMeta(epoch, height)
+	return false
+}
@@ -114,6 +114,8 @@ export default function MerkleWhitelistGenerator() {
     const opts = { ...options };
     opts.headers = opts.headers || {};
     if (token) opts.headers["Authorization"] = `Bearer ${token}`;
+    // the session cookie set after Idena sign-in is sent along as well
+    opts.credentials = "include";
     return fetch(url, opts);
   };
 
@@ -121,37 +123,37 @@ export default function MerkleWhitelistGenerator() {
     e.preventDefault();
     fetch(`${apiBase}/whoami`, {
       headers: { Authorization: `Bearer ${authInput}` },
+      credentials: "include",
     })
       .then((res) => (res.ok ? res.json() : Promise.reject()))
       .then((user) => {
         setToken(authInput);
         sessionStorage.setItem("idenaApiToken", authInput);
         setAuthStatus(
-          user?.username ? `Logged in as ${user.username}` : "Logged in",
+          user?.address ? `Logged in as ${user.address}` : "Logged in",
         );
       })
       .catch(() => setAuthStatus("Invalid key"));
   };
 
   const handleLogout = () => {
+    fetch(`${apiBase}/logout`, { method: "POST", credentials: "include" }).catch(
+      () => {},
+    );
     setToken("");
     sessionStorage.removeItem("idenaApiToken");
     setAuthStatus("");
     setAccountInfo(null);
   };
 
-  // Fetch account info when token changes
+  // Fetch account info when token changes or a session cookie exists
   useEffect(() => {
-    if (token) {
-      fetch(`${apiBase}/whoami`, {
-        headers: { Authorization: `Bearer ${token}` },
-      })
-        .then((res) => res.json())
-        .then((info) => setAccountInfo(info))
-        .catch(() => setAccountInfo(null));
-    } else {
-      setAccountInfo(null);
-    }
+    const opts = { credentials: "include" };
+    if (token) opts.headers = { Authorization: `Bearer ${token}` };
+    fetch(`${apiBase}/whoami`, opts)
+      .then((res) => (res.ok ? res.json() : Promise.reject()))
+      .then((info) => setAccountInfo(info))
+      .catch(() => setAccountInfo(null));
   }, [token, apiBase]);
 
   // Color map for status badges
@@ -400,9 +402,9 @@ export default function MerkleWhitelistGenerator() {
           </span>
         </div>
         <div className="flex items-center gap-2">
-          {token ? (
+          {token || accountInfo ? (
             <span className="text-green-700">
-              API key active.{" "}
+              {token ? "API key active." : "Signed in with Idena."}{" "}
               <button onClick={handleLogout} className="ml-2 underline">
                 Logout
               </button>
@@ -553,11 +555,11 @@ export default function MerkleWhitelistGenerator() {
         {/* Settings Panel */}
         <SettingsPanel />
 
-        {token && accountInfo && (
+        {accountInfo && (
           <div className="mb-3 text-xs text-gray-700">
             Logged in as:{' '}
             <span className="font-semibold">
-              {accountInfo.username || 'API user'}
+              {accountInfo.address || 'API user'}
             </span>
           </div>
         )}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"idenauthgo/checks"
+	"idenauthgo/datasource"
+)
+
+// CHECK_CROSSCHECK compares penalty and flip status derived from the local
+// node with the public API and logs every disagreement. The comparison runs
+// in the background once the whitelist is published.
+var CHECK_CROSSCHECK = getenv("CHECK_CROSSCHECK", "false") == "true"
+
+// penaltyFlipStatus returns the penalty and flip status of the epoch's
+// identities. Once the local node has applied the validation that opened the
+// epoch, the status is taken from the identity records, reading dna_identity
+// for records without a penalty field, so no public API call is needed.
+// Otherwise every address is checked against the public API. The returned
+// source tells which of the two was used.
+func penaltyFlipStatus(epoch int, ids []epochIdentity) (map[string]EpochSnapshot, map[string]error, string) {
+	lastEpoch := epoch - 1
+	local := checks.NewLocalChecker(nodeSource)
+	status := make(map[string]EpochSnapshot)
+	var pending []string
+	var check func(addr string) (EpochSnapshot, error)
+	source := checkSourceNode
+	if err := local.ValidationApplied(epoch); err != nil {
+		log.Printf("[CHECK] epoch %d: using public API for penalty checks: %v", epoch, err)
+		// only warms the cache: the workers below read the bad flip authors
+		// from it instead of all fetching them at once
+		if _, err := checks.BadAuthors(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch); err != nil {
+			log.Printf("[CHECK] bad authors of epoch %d: %v", lastEpoch, err)
+		}
+		for _, id := range ids {
+			pending = append(pending, id.Address)
+		}
+		source = checkSourceAPI
+		check = func(addr string) (EpochSnapshot, error) {
+			penalized, flip, err := checks.CheckPenaltyFlipForEpoch(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch, addr)
+			return EpochSnapshot{Penalized: penalized, FlipReported: flip}, err
+		}
+	} else {
+		for _, id := range ids {
+			penalized, flip, ok := checks.FromIdentity(datasource.Identity{Penalty: id.Penalty, Flags: id.Flags})
+			if !ok {
+				pending = append(pending, id.Address)
+				continue
+			}
+			status[id.Address] = EpochSnapshot{Address: id.Address, Penalized: penalized, FlipReported: flip}
+		}
+		check = func(addr string) (EpochSnapshot, error) {
+			penalized, flip, err := local.CheckPenaltyFlip(addr)
+			return EpochSnapshot{Penalized: penalized, FlipReported: flip}, err
+		}
+	}
+	checked, failed := runChecks(epoch, source, pending, check)
+	for addr, s := range checked {
+		status[addr] = s
+	}
+	return status, failed, source
+}
+
+// crossCheckPenalties compares locally derived status with the public API.
+// Disagreements are only logged; the local result is kept. Note that the API
+// also reports identities that failed validation as penalized.
+func crossCheckPenalties(lastEpoch int, status map[string]EpochSnapshot) {
+	addrs := make([]string, 0, len(status))
+	for a := range status {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	var mu sync.Mutex
+	mismatches := 0
+	failed := sharedCheckPool().Run(context.Background(), addrs, func(ctx context.Context, addr string) error {
+		penalized, flip, err := checks.CheckPenaltyFlipForEpoch(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch, addr)
+		if err != nil {
+			return err
+		}
+		s := status[addr]
+		if penalized != s.Penalized || flip != s.FlipReported {
+			log.Printf("[CHECK] cross-check %s: node penalized=%t flip=%t, api penalized=%t flip=%t", addr, s.Penalized, s.FlipReported, penalized, flip)
+			mu.Lock()
+			mismatches++
+			mu.Unlock()
+		}
+		return nil
+	}, nil)
+	log.Printf("[CHECK] cross-check of epoch %d: %d addresses, %d disagree, %d not checked", lastEpoch, len(addrs), mismatches, len(failed))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"idenauthgo/datasource"
+)
+
+func TestBuildEpochWhitelistFromLocalNode(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldNode, oldAPI, oldFetch := dataDir, nodeSource, fallbackApiUrl, fetchEpochIdentitiesFn
+	dataDir = t.TempDir()
+	defer func() {
+		dataDir, nodeSource, fallbackApiUrl, fetchEpochIdentitiesFn = oldDir, oldNode, oldAPI, oldFetch
+	}()
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var res interface{}
+		switch req.Method {
+		case "dna_epoch":
+			res = map[string]interface{}{"epoch": 10, "startBlock": 500}
+		case "bcn_blockAt":
+			res = map[string]interface{}{"height": 500, "flags": []string{"ValidationFinished"}}
+		case "bcn_lastBlock":
+			res = map[string]interface{}{"height": 510, "hash": "0xblock"}
+		case "dna_identity":
+			res = map[string]interface{}{"address": req.Params[0], "state": "Human", "stake": "15000", "penalty": "3"}
+		default:
+			t.Errorf("unexpected node method %s", req.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": res})
+	}))
+	defer node.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("public API called: %s", r.URL)
+		http.Error(w, "offline", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+	nodeSource = datasource.NewNodeSource(node.URL, "")
+	fallbackApiUrl = api.URL
+
+	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) {
+		return []epochIdentity{
+			{Address: "0xaaa", State: "Human", Stake: 15000, Penalty: "0"},
+			{Address: "0xbbb", State: "Human", Stake: 15000, Penalty: "0", Flags: []string{"AtLeastOneFlipReported"}},
+			{Address: "0xccc", State: "Human", Stake: 15000, Penalty: "12.5"},
+			// no penalty field: read from dna_identity
+			{Address: "0xddd", State: "Human", Stake: 15000},
+		}, nil
+	}
+	// results of an earlier attempt that used the public API are not reused
+	saveCheckpoint(10, checkSourceAPI, EpochSnapshot{Address: "0xddd", State: "Human", Stake: 15000})
+	if err := buildEpochWhitelist(10, 12000); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	list, err := getWhitelist()
+	if err != nil {
+		t.Fatalf("get whitelist: %v", err)
+	}
+	if !reflect.DeepEqual(list, []string{"0xaaa"}) {
+		t.Fatalf("unexpected whitelist %v", list)
+	}
+	if cps := loadCheckpoints(10, checkSourceAPI); len(cps) != 0 {
+		t.Fatalf("checkpoints kept after the build: %v", cps)
+	}
+}
+
+func TestBuildUsesThresholdMissingFromNode(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldNode, oldAPI, oldIdentity, oldFetch := dataDir, nodeSource, apiSource, identitySource, fetchEpochIdentitiesFn
+	dataDir = t.TempDir()
+	defer func() {
+		dataDir, nodeSource, apiSource, identitySource, fetchEpochIdentitiesFn = oldDir, oldNode, oldAPI, oldIdentity, oldFetch
+	}()
+
+	// the node's dna_epoch has no threshold and dna_globalState fails
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": 1}
+		switch req.Method {
+		case "dna_epoch":
+			resp["result"] = map[string]interface{}{"epoch": 10, "startBlock": 500}
+		case "bcn_blockAt":
+			resp["result"] = map[string]interface{}{"height": 500, "flags": []string{"ValidationFinished"}}
+		case "bcn_lastBlock":
+			resp["result"] = map[string]interface{}{"height": 510, "hash": "0xblock"}
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "method not available"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer node.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/Epoch/Last" {
+			t.Errorf("unexpected API call %s", r.URL)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"epoch": 10, "discriminationStakeThreshold": 15000}})
+	}))
+	defer api.Close()
+	nodeSource = datasource.NewNodeSource(node.URL, "")
+	apiSource = datasource.NewAPISource(api.URL, "")
+	identitySource = datasource.NewComposite(nodeSource, apiSource)
+	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) {
+		return []epochIdentity{
+			{Address: "0xaaa", State: "Human", Stake: 20000, Penalty: "0"},
+			{Address: "0xbbb", State: "Human", Stake: 12000, Penalty: "0"},
+		}, nil
+	}
+
+	epoch, thr, err := fetchEpochData()
+	if err != nil || epoch != 10 || thr != 15000 {
+		t.Fatalf("epoch data %d %v %v", epoch, thr, err)
+	}
+	if err := buildEpochWhitelist(epoch, thr); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	m, err := loadManifest(10)
+	if err != nil || m.Threshold != 15000 || m.AddressCount != 1 {
+		t.Fatalf("unexpected manifest %+v %v", m, err)
+	}
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -27,6 +27,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"idenauthgo/checks"
 	"idenauthgo/eligibility"
+	"idenauthgo/epochids"
+	"idenauthgo/merkle"
+	"idenauthgo/wlformat"
 )
 
 // Environment variables, with fallback for local/dev usage
@@ -35,14 +38,7 @@ var (
 	IDENA_RPC_KEY = getenv("IDENA_RPC_KEY", "")
 )
 
-var port = flag.Int("port", 3030, "Port to run the HTTP server on")
-
-const (
-	sessionDuration = 60 * 60 // Session duration in seconds
-	dbFile          = "./sessions.db"
-	idenaRpcUrl     = "http://localhost:9009"
-	fallbackApiUrl  = "https://api.idena.io"
-)
+const sessionDuration = 60 * 60 // Session duration in seconds
 
 var (
 	db             *sql.DB
@@ -118,75 +114,74 @@ func sanitizeBaseURL(u string) string {
 	return u
 }
 
+// fetchEpochData returns the current epoch and its discrimination stake
+// threshold. A missing threshold is an error: with 0 every Human with any
+// stake would be eligible.
 func fetchEpochData() (int, float64, error) {
-	url := idenaRpcUrl + "/api/Epoch/Last"
-	if IDENA_RPC_KEY != "" {
-		url += "?apikey=" + IDENA_RPC_KEY
-	}
-	resp, err := http.Get(url)
+	info, err := identitySource.GetEpoch()
 	if err != nil {
 		return 0, 0, err
 	}
-	defer resp.Body.Close()
-	var result struct {
-		Result struct {
-			Epoch     int     `json:"epoch"`
-			Threshold float64 `json:"discriminationStakeThreshold"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, 0, err
+	if info.Threshold <= 0 {
+		return info.Epoch, 0, fmt.Errorf("epoch %d: no source reported the discrimination stake threshold", info.Epoch)
 	}
-	return result.Result.Epoch, result.Result.Threshold, nil
+	return info.Epoch, info.Threshold, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerifyCLI(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiffCLI(os.Args[2:]))
+	}
 	indexNow := flag.Bool("index", false, "build whitelist for the current epoch and exit")
 	epochFlag := flag.Int("epoch", 0, "override epoch number when used with -index")
+	reproduce := flag.Int("reproduce", 0, "rebuild the whitelist of an epoch from its recorded inputs, compare it with the recorded root and exit")
+	configFile := flag.String("config", getenv("CONFIG_FILE", "config/server.json"), "JSON config file (optional)")
+	flag.String("rpc-url", idenaRpcUrl, "Idena node JSON-RPC URL")
+	flag.String("fallback-url", fallbackApiUrl, "public API URL used as fallback")
+	flag.String("indexer-url", "", "rolling indexer URL (optional)")
+	flag.String("data-dir", dataDir, "directory for whitelist files")
+	flag.String("db", dbFile, "SQLite database path")
+	flag.Int("port", listenPort, "Port to run the HTTP server on")
+	flag.String("merkle-scheme", merkleScheme, "default Merkle tree scheme")
 	flag.Parse()
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.SetOutput(io.MultiWriter(os.Stdout, logWriter{}))
+	cfg, err := loadServerConfig(*configFile)
+	if err != nil {
+		log.Fatalf("[CONFIG] %v", err)
+	}
+	cfg.applyFlags(flag.CommandLine)
+	if err := applyServerConfig(cfg); err != nil {
+		log.Fatalf("[CONFIG] %v", err)
+	}
 	if *indexNow {
 		runIndexerCLI(*epochFlag)
 		return
 	}
 
-	var err error
-	db, err = sql.Open("sqlite3", dbFile)
-	if err != nil {
-		log.Fatalf("Failed to open DB: %v", err)
-	}
+	openDatabase()
 	defer db.Close()
-	createSessionTable()
-	createSnapshotTable()
-	createEpochSnapshotTable()
-	createSnapshotMetaTable()
-	createConfigTable()
-	createEpochTable()
-	createMerkleRootTable()
-	createPenaltyTable()
+	if *reproduce > 0 {
+		os.Exit(runReproduceCLI(*reproduce))
+	}
+	loadSessionSecret()
+	loadPolicyFile(POLICY_FILE)
+	if err := loadOIDCKey(OIDC_KEY_FILE); err != nil {
+		log.Printf("WARNING: OIDC signing key unavailable: %v", err)
+	}
 	epoch, thr, err := fetchEpochData()
 	if err != nil {
+		// without the threshold nothing is built; the last published
+		// whitelist stays live
 		log.Printf("WARNING: Failed to fetch epoch data: %v (will continue...)", err)
-	}
-	stakeThreshold = thr
-	currentEpoch = getConfigInt("current_epoch")
-	if currentEpoch != epoch {
-		currentEpoch = epoch
-		if err := buildEpochWhitelist(epoch, thr); err != nil {
-			log.Printf("initial whitelist build: %v", err)
-		}
-		saveSnapshotMeta(epoch, 0)
-		setConfigInt("current_epoch", epoch)
+		currentEpoch = getConfigInt("current_epoch")
 	} else {
-		if _, err := getWhitelist(); err != nil {
-			if err := buildEpochWhitelist(epoch, thr); err != nil {
-				log.Printf("whitelist load failed: %v", err)
-			} else {
-				saveSnapshotMeta(epoch, 0)
-			}
-		}
+		stakeThreshold = thr
+		initWhitelist(epoch, thr)
 	}
 	resultTmpl = mustLoadTemplate("templates/result.html")
 
@@ -197,21 +192,40 @@ func main() {
 	http.HandleFunc("/auth/v1/start-session", startSessionHandler)
 	http.HandleFunc("/auth/v1/authenticate", authenticateHandler)
 	http.HandleFunc("/callback", callbackHandler)
+	http.HandleFunc("/.well-known/openid-configuration", openIDConfigurationHandler)
+	http.HandleFunc("/oidc/jwks", jwksHandler)
+	http.HandleFunc("/oidc/authorize", oidcAuthorizeHandler)
+	http.HandleFunc("/oidc/token", oidcTokenHandler)
+	http.HandleFunc("/oidc/userinfo", oidcUserinfoHandler)
+	http.HandleFunc("/admin/clients", adminClientsHandler)
+	http.HandleFunc("/admin/clients/", adminClientHandler)
+	http.HandleFunc("/admin/whitelist/candidates", adminWhitelistCandidatesHandler)
+	http.HandleFunc("/admin/whitelist/approve", adminWhitelistApproveHandler)
+	http.HandleFunc("/whoami", whoamiHandler)
+	http.HandleFunc("/logout", logoutHandler)
+	http.HandleFunc("/policies", policiesHandler)
+	http.HandleFunc("/health/sources", sourcesHealthHandler)
+	http.HandleFunc("/config", configHandler)
 	http.HandleFunc("/whitelist", whitelistCurrentHandler)
 	http.HandleFunc("/whitelist/current", whitelistCurrentHandler)
 	http.HandleFunc("/whitelist/epoch/", whitelistEpochHandler)
+	http.HandleFunc("/whitelist/diff", whitelistDiffHandler)
 	http.HandleFunc("/whitelist/check", whitelistCheckHandler)
 	http.HandleFunc("/eligibility", eligibilitySnapshotHandler)
 	http.HandleFunc("/merkle_root", merkleRootHandler)
 	http.HandleFunc("/merkle_proof", merkleProofHandler)
+	http.HandleFunc("/merkle_proofs", merkleProofsHandler)
+	http.HandleFunc("/merkle_proofs/epoch/", merkleBundleHandler)
+	http.HandleFunc("/merkle_multiproof", merkleMultiProofHandler)
+	http.HandleFunc("/merkle_exclusion_proof", merkleExclusionProofHandler)
 	http.HandleFunc("/logs/stream", logsStreamHandler)
 	http.HandleFunc("/epochs", epochsHandler)
 	http.HandleFunc("/api/Epoch/Last", epochLastHandler)
 	http.HandleFunc("/api/Identity/", identityHandler)
 
 	go cleanupExpiredSessions()
-	log.Printf("Server running at http://localhost:%d", *port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+	log.Printf("Server running at http://localhost:%d", listenPort)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", listenPort), nil); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -241,12 +255,20 @@ func createSessionTable() {
             authenticated INTEGER DEFAULT 0,
             identity_state TEXT,
             stake REAL,
-            created INTEGER
+            created INTEGER,
+            status TEXT DEFAULT 'created',
+            nonce_issued_at INTEGER DEFAULT 0,
+            attempts INTEGER DEFAULT 0,
+            policy TEXT,
+            reason TEXT
         )
     `)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := migrateSessionTable(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func createSnapshotTable() {
@@ -283,13 +305,60 @@ func createSnapshotMetaTable() {
 func createMerkleRootTable() {
 	_, err := db.Exec(`
         CREATE TABLE IF NOT EXISTS epoch_merkle_roots (
-            epoch INTEGER PRIMARY KEY,
+            epoch INTEGER,
+            scheme TEXT NOT NULL DEFAULT 'sha256-legacy',
             merkle_root TEXT,
-            ts INTEGER
+            ts INTEGER,
+            address_count INTEGER,
+            snapshot_block INTEGER,
+            signer TEXT,
+            signature TEXT,
+            PRIMARY KEY (epoch, scheme)
         )`)
 	if err != nil {
 		log.Fatal(err)
 	}
+	migrateMerkleRootTable()
+	// attestation columns
+	cols := []struct{ name, decl string }{
+		{"address_count", "INTEGER"},
+		{"snapshot_block", "INTEGER"},
+		{"signer", "TEXT"},
+		{"signature", "TEXT"},
+	}
+	for _, c := range cols {
+		if err := addColumnIfMissing("epoch_merkle_roots", c.name, c.decl); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// migrateMerkleRootTable converts the old one-root-per-epoch table, whose
+// roots all use the legacy scheme, to the keyed (epoch, scheme) layout.
+func migrateMerkleRootTable() {
+	ok, err := hasColumn("epoch_merkle_roots", "scheme")
+	if err != nil || ok {
+		return
+	}
+	stmts := []string{
+		`ALTER TABLE epoch_merkle_roots RENAME TO epoch_merkle_roots_old`,
+		`CREATE TABLE epoch_merkle_roots (
+            epoch INTEGER,
+            scheme TEXT NOT NULL DEFAULT 'sha256-legacy',
+            merkle_root TEXT,
+            ts INTEGER,
+            PRIMARY KEY (epoch, scheme)
+        )`,
+		`INSERT INTO epoch_merkle_roots(epoch,scheme,merkle_root,ts)
+            SELECT epoch, 'sha256-legacy', merkle_root, ts FROM epoch_merkle_roots_old`,
+		`DROP TABLE epoch_merkle_roots_old`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			log.Fatalf("[DB] migrate epoch_merkle_roots: %v", err)
+		}
+	}
+	log.Printf("[DB] epoch_merkle_roots keyed by scheme")
 }
 
 func createConfigTable() {
@@ -343,6 +412,17 @@ func setConfigInt(key string, val int) {
 	_, _ = db.Exec("INSERT OR REPLACE INTO config(key,value) VALUES(?,?)", key, strconv.Itoa(val))
 }
 
+func getConfigString(key string) string {
+	row := db.QueryRow("SELECT value FROM config WHERE key=?", key)
+	var v string
+	_ = row.Scan(&v)
+	return v
+}
+
+func setConfigString(key, val string) {
+	_, _ = db.Exec("INSERT OR REPLACE INTO config(key,value) VALUES(?,?)", key, val)
+}
+
 func recordIdentitySnapshot(address, state string, stake float64) {
 	_, err := db.Exec(`INSERT INTO identity_snapshots(address,state,stake,ts) VALUES(?,?,?,?)`,
 		address, state, stake, time.Now().Unix())
@@ -369,15 +449,20 @@ func hasPenalty(epoch int, address string) bool {
 	return row.Scan(&x) == nil
 }
 
-func saveMerkleRoot(epoch int, root string) {
-	_, err := db.Exec(`INSERT OR REPLACE INTO epoch_merkle_roots(epoch,merkle_root,ts) VALUES(?,?,?)`, epoch, root, time.Now().Unix())
+func saveMerkleRoot(epoch int, scheme, root string) {
+	_, err := db.Exec(`INSERT OR REPLACE INTO epoch_merkle_roots(epoch,scheme,merkle_root,ts) VALUES(?,?,?,?)`, epoch, scheme, root, time.Now().Unix())
 	if err != nil {
 		log.Printf("[MERKLE] save root: %v", err)
 	}
 }
 
-func getMerkleRoot(epoch int) (string, bool) {
-	row := db.QueryRow("SELECT merkle_root FROM epoch_merkle_roots WHERE epoch=?", epoch)
+func storedRootIs(epoch int, scheme, root string) bool {
+	got, ok := getMerkleRoot(epoch, scheme)
+	return ok && got == root
+}
+
+func getMerkleRoot(epoch int, scheme string) (string, bool) {
+	row := db.QueryRow("SELECT merkle_root FROM epoch_merkle_roots WHERE epoch=? AND scheme=?", epoch, scheme)
 	var root string
 	if err := row.Scan(&root); err == nil {
 		return root, true
@@ -389,7 +474,9 @@ func saveSnapshotMeta(epoch, block int) {
 	_, err := db.Exec(`INSERT OR REPLACE INTO epoch_snapshot_meta(epoch, block) VALUES(?,?)`, epoch, block)
 	if err != nil {
 		log.Printf("[SNAPSHOT_META] save: %v", err)
+		return
 	}
+	attestEpoch(epoch)
 }
 
 func getSnapshotBlock(epoch int) int {
@@ -411,144 +498,105 @@ func latestSnapshotEpoch() (int, int) {
 }
 
 func getWhitelist() ([]string, error) {
+	_, list, err := liveWhitelist()
+	return list, err
+}
+
+// liveWhitelist returns the published epoch together with its whitelist,
+// read under wlMu so the two always belong together.
+func liveWhitelist() (int, []string, error) {
 	wlMu.RLock()
+	epoch := currentEpoch
 	list := append([]string(nil), currentWhitelist...)
 	wlMu.RUnlock()
 	if len(list) > 0 {
-		return list, nil
+		return epoch, list, nil
 	}
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", currentEpoch)
-	data, err := os.ReadFile(path)
+	list, _, _, err := loadWhitelistData(epoch)
 	if err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal(data, &list); err != nil {
-		return nil, err
+		return 0, nil, err
 	}
+	sort.Strings(list)
 	wlMu.Lock()
-	currentWhitelist = append([]string(nil), list...)
+	if currentEpoch == epoch && len(currentWhitelist) == 0 {
+		currentWhitelist = append([]string(nil), list...)
+	}
 	wlMu.Unlock()
-	sort.Strings(list)
-	return list, nil
+	return epoch, list, nil
 }
 
-// loadWhitelistData reads addresses and root from a saved whitelist file.
-func loadWhitelistData(epoch int) ([]string, string, error) {
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
-	data, err := os.ReadFile(path)
+// loadWhitelistData reads addresses, root and root scheme from a saved
+// whitelist file in any format wlformat reads. Files without a scheme field
+// use the legacy scheme.
+func loadWhitelistData(epoch int) ([]string, string, string, error) {
+	data, err := os.ReadFile(whitelistPath(epoch))
 	if err != nil {
-		return nil, "", err
+		return nil, "", "", err
 	}
-	var out struct {
-		MerkleRoot string   `json:"merkle_root"`
-		Addresses  []string `json:"addresses"`
+	wl, _, err := wlformat.Parse(data)
+	if err != nil {
+		return nil, "", "", err
 	}
-	if err := json.Unmarshal(data, &out); err != nil {
-		// try plain address array
-		if err2 := json.Unmarshal(data, &out.Addresses); err2 != nil {
-			return nil, "", err
-		}
+	scheme := wl.Scheme
+	if scheme == "" {
+		scheme = merkle.Legacy
 	}
-	return out.Addresses, out.MerkleRoot, nil
+	return wl.Addresses(), wl.Root, scheme, nil
 }
 
-func computeMerkleRoot(list []string) string {
-	if len(list) == 0 {
-		return ""
-	}
-	var hashes [][]byte
-	for _, a := range list {
-		h := sha256.Sum256([]byte(strings.ToLower(a)))
-		hashes = append(hashes, h[:])
-	}
-	for len(hashes) > 1 {
-		var next [][]byte
-		for i := 0; i < len(hashes); i += 2 {
-			if i+1 == len(hashes) {
-				next = append(next, hashes[i])
-			} else {
-				h := sha256.Sum256(append(hashes[i], hashes[i+1]...))
-				next = append(next, h[:])
-			}
-		}
-		hashes = next
-	}
-	return hex.EncodeToString(hashes[0])
-}
+// ProofStep is a sibling hash in a Merkle proof.
+type ProofStep = merkle.ProofStep
 
-type ProofStep struct {
-	Hash string `json:"hash"`
-	Left bool   `json:"left"`
+// computeMerkleRoot returns the legacy SHA-256 root of list.
+func computeMerkleRoot(list []string) string {
+	root, _ := merkle.MustGet(merkle.Legacy).Root(list, 0)
+	return root
 }
 
+// computeMerkleProof returns the legacy SHA-256 proof for target.
 func computeMerkleProof(list []string, target string) ([]ProofStep, bool) {
-	if len(list) == 0 {
-		return nil, false
-	}
-	var hashes [][]byte
-	idx := -1
-	for i, a := range list {
-		h := sha256.Sum256([]byte(strings.ToLower(a)))
-		hashes = append(hashes, h[:])
-		if strings.EqualFold(a, target) {
-			idx = i
-		}
-	}
-	if idx == -1 {
-		return nil, false
-	}
-	pos := idx
-	var proof []ProofStep
-	for len(hashes) > 1 {
-		var next [][]byte
-		for i := 0; i < len(hashes); i += 2 {
-			if i+1 == len(hashes) {
-				if pos == i {
-					pos = len(next)
-				}
-				next = append(next, hashes[i])
-				continue
-			}
-			left := hashes[i]
-			right := hashes[i+1]
-			if pos == i {
-				proof = append(proof, ProofStep{Hash: hex.EncodeToString(right), Left: false})
-				pos = len(next)
-			} else if pos == i+1 {
-				proof = append(proof, ProofStep{Hash: hex.EncodeToString(left), Left: true})
-				pos = len(next)
-			}
-			h := sha256.Sum256(append(left, right...))
-			next = append(next, h[:])
-		}
-		hashes = next
-	}
-	return proof, true
+	proof, err := merkle.MustGet(merkle.Legacy).Proof(list, 0, target)
+	return proof, err == nil
 }
 
 func verifyMerkleProof(address string, proof []ProofStep, root string) bool {
-	h := sha256.Sum256([]byte(strings.ToLower(address)))
-	cur := h[:]
-	for _, step := range proof {
-		sib, err := hex.DecodeString(step.Hash)
-		if err != nil {
-			return false
-		}
-		if step.Left {
-			h := sha256.Sum256(append(sib, cur...))
-			cur = h[:]
-		} else {
-			h := sha256.Sum256(append(cur, sib...))
-			cur = h[:]
-		}
+	return merkle.MustGet(merkle.Legacy).Verify(address, 0, proof, root)
+}
+
+// whitelistRoot returns the root of an epoch's whitelist under the configured
+// scheme. If the stored tree already holds list it is used as is, so
+// re-exporting an unchanged list neither rebuilds nor re-signs anything.
+// Otherwise the tree is built and stored for proof requests, and the trees
+// and roots of other schemes are dropped since they describe the old list.
+// It holds treeBuildMu so a proof request never loads a half replaced tree.
+func whitelistRoot(epoch int, list []string) (string, string, error) {
+	treeBuildMu.Lock()
+	defer treeBuildMu.Unlock()
+	scheme, err := merkle.Get(merkleScheme)
+	if err != nil {
+		return "", "", err
+	}
+	if t, ok := storedMerkleTree(epoch, scheme.Name()); ok && sameLeaves(t, list) && storedRootIs(epoch, scheme.Name(), t.Root()) {
+		// unchanged list: keep the stored trees, roots and attestations
+		return t.Root(), scheme.Name(), nil
+	}
+	t, err := merkle.Build(scheme, list, epoch)
+	if err != nil {
+		return "", "", err
 	}
-	return hex.EncodeToString(cur) == root
+	dropMerkleTrees(epoch)
+	if err := storeMerkleTree(t); err != nil {
+		return "", "", err
+	}
+	return t.Root(), scheme.Name(), nil
 }
 
 type epochIdentity struct {
-	Address string  `json:"address"`
-	State   string  `json:"state"`
-	Stake   float64 `json:"stake,string"`
+	Address string   `json:"address"`
+	State   string   `json:"state"`
+	Stake   float64  `json:"stake,string"`
+	Penalty string   `json:"penalty"`
+	Flags   []string `json:"lastValidationFlags"`
 }
 
 // Identity represents a record in snapshot.json. Stake may be encoded as a
@@ -570,36 +618,16 @@ type EligibilityResponse struct {
 	Prediction string  `json:"prediction,omitempty"`
 }
 
+// fetchEpochIdentities enumerates every identity of epoch, see
+// enumerateEpochIdentities.
 func fetchEpochIdentities(epoch int) ([]epochIdentity, error) {
-	req := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_epochIdentities",
-		"params":  []interface{}{epoch, 0},
-		"id":      1,
-	}
-	if IDENA_RPC_KEY != "" {
-		req["key"] = IDENA_RPC_KEY
-	}
-	body, _ := json.Marshal(req)
-	resp, err := http.Post(idenaRpcUrl, "application/json", bytes.NewReader(body))
+	ids, _, err := enumerateEpochIdentities(epoch)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	var out struct {
-		Result []struct {
-			Address string `json:"address"`
-			State   string `json:"state"`
-			Stake   string `json:"stake"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	list := make([]epochIdentity, 0, len(out.Result))
-	for _, r := range out.Result {
-		st, _ := strconv.ParseFloat(r.Stake, 64)
-		list = append(list, epochIdentity{Address: r.Address, State: r.State, Stake: st})
+	list := make([]epochIdentity, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, epochIdentity{Address: id.Address, State: id.State, Stake: id.Stake, Penalty: id.Penalty, Flags: id.Flags})
 	}
 	return list, nil
 }
@@ -644,50 +672,72 @@ func predictNextEpoch(snapshotEligible bool, liveState string, liveStake float64
 
 func buildEpochWhitelist(epoch int, threshold float64) error {
 	ids, err := fetchEpochIdentitiesFn(epoch)
+	if errors.Is(err, epochids.ErrTruncated) {
+		// the session scan would not be more complete; keep the current
+		// whitelist until the full list can be read
+		log.Printf("[WHITELIST] epoch %d not published: %v", epoch, err)
+		clearCheckpoints(epoch)
+		return err
+	}
 	if err != nil || len(ids) == 0 {
-		log.Printf("[WHITELIST] local node missing data for epoch %d; using official API fallback", epoch)
+		log.Printf("[WHITELIST] local node missing data for epoch %d; using the session scan fallback", epoch)
 		return buildEpochWhitelistAPI(epoch, threshold)
 	}
+	status, failed, source := penaltyFlipStatus(epoch, ids)
 	var snaps []EpochSnapshot
-	var list []string
-	lastEpoch := epoch - 1
 	for _, id := range ids {
-		penalized, flip, err := checks.CheckPenaltyFlipForEpoch(fallbackApiUrl, IDENA_RPC_KEY, lastEpoch, id.Address)
-		if err != nil {
+		if err, ok := failed[id.Address]; ok {
 			log.Printf("[CHECK] %s: %v", id.Address, err)
 		}
+		c := status[id.Address]
 		snaps = append(snaps, EpochSnapshot{
 			Address:      id.Address,
 			State:        id.State,
 			Stake:        id.Stake,
-			Penalized:    penalized,
-			FlipReported: flip,
+			Penalized:    c.Penalized,
+			FlipReported: c.FlipReported,
 		})
-		if eligibility.IsEligibleFull(id.State, id.Stake, penalized, flip, threshold) {
-			list = append(list, id.Address)
-		}
 	}
-	if err := upsertEpochSnapshots(db, epoch, snaps); err != nil {
-		return err
+	list, root, err := publishEpochWhitelist(epoch, threshold, manifestSourceNode, identitySource.Name(), nodeBlock(), snaps, len(ids), len(failed))
+	if err == nil || errors.Is(err, errWhitelistQuarantined) {
+		// a candidate keeps its own records, the next build starts over
+		clearCheckpoints(epoch)
 	}
-	sort.Strings(list)
-	root := computeMerkleRoot(list)
-	saveMerkleRoot(epoch, root)
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
-	data, _ := json.MarshalIndent(map[string]interface{}{
-		"merkle_root": root,
-		"addresses":   list,
-	}, "", "  ")
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err != nil {
 		return err
 	}
-	wlMu.Lock()
-	currentWhitelist = list
-	wlMu.Unlock()
 	log.Printf("[WHITELIST] built for epoch %d with %d addresses root=%s", epoch, len(list), root)
+	if CHECK_CROSSCHECK && source == checkSourceNode {
+		// only logs disagreements, so it does not hold up publishing
+		go crossCheckPenalties(epoch-1, status)
+	}
 	return nil
 }
 
+// initWhitelist loads the whitelist of the epoch recorded in the config and
+// builds the one of epoch if the node moved on. currentEpoch only advances
+// when finishEpochWhitelist publishes the new list; a failed or truncated build keeps the
+// previous list live and is retried by watchEpochFinalization, while a
+// quarantined one waits for approval.
+func initWhitelist(epoch int, thr float64) {
+	currentEpoch = getConfigInt("current_epoch")
+	if currentEpoch != epoch {
+		if err := buildEpochWhitelist(epoch, thr); err != nil {
+			log.Printf("initial whitelist build: %v", err)
+			return
+		}
+		saveSnapshotMeta(epoch, 0)
+		return
+	}
+	if _, err := getWhitelist(); err != nil {
+		if err := buildEpochWhitelist(epoch, thr); err != nil {
+			log.Printf("whitelist load failed: %v", err)
+		} else {
+			saveSnapshotMeta(epoch, 0)
+		}
+	}
+}
+
 func watchEpochChanges() {
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
@@ -704,11 +754,6 @@ func watchEpochChanges() {
 				stakeThreshold = thr
 				if err := buildEpochWhitelist(epoch, thr); err != nil {
 					log.Printf("[EPOCH] build whitelist: %v", err)
-				} else {
-					wlMu.Lock()
-					currentEpoch = epoch
-					wlMu.Unlock()
-					setConfigInt("current_epoch", epoch)
 				}
 			}
 		}
@@ -716,19 +761,19 @@ func watchEpochChanges() {
 	}
 }
 
+// exportWhitelist rewrites the whitelist file of the published epoch.
 func exportWhitelist() {
-	list, err := getWhitelist()
+	epoch, list, err := liveWhitelist()
 	if err != nil {
 		log.Printf("[WHITELIST] query error: %v", err)
 		return
 	}
-	data := map[string]interface{}{
-		"merkle_root": computeMerkleRoot(list),
-		"addresses":   list,
+	root, scheme, err := whitelistRoot(epoch, list)
+	if err != nil {
+		log.Printf("[WHITELIST] merkle root: %v", err)
+		return
 	}
-	b, _ := json.MarshalIndent(data, "", "  ")
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", currentEpoch)
-	if err := os.WriteFile(path, b, 0644); err != nil {
+	if err := writeWhitelistFile(epoch, list, root, scheme); err != nil {
 		log.Printf("[WHITELIST] failed to write whitelist.json: %v", err)
 	}
 }
@@ -739,17 +784,18 @@ func randHex(n int) string {
 	return hex.EncodeToString(b)
 }
 
-// Start sign-in flow, redirect to Idena app (BASE_URL is used everywhere)
-func signinHandler(w http.ResponseWriter, r *http.Request) {
+// newSignInSession stores a fresh sign-in session evaluated against the
+// named policy and returns its token.
+func newSignInSession(policy string) (string, error) {
 	token := "signin-" + randHex(16)
 	now := time.Now().Unix()
-	_, err := db.Exec("INSERT INTO sessions(token, created) VALUES (?, ?)", token, now)
-	if err != nil {
-		log.Printf("[SIGNIN] DB error storing session: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	idenaUrl := fmt.Sprintf(
+	_, err := db.Exec("INSERT INTO sessions(token, status, policy, created) VALUES (?, ?, ?, ?)", token, sessionCreated, policy, now)
+	return token, err
+}
+
+// idenaSigninURL builds the deep link that opens the Idena app for token.
+func idenaSigninURL(token string) string {
+	return fmt.Sprintf(
 		"https://app.idena.io/dna/signin?token=%s&callback_url=%s&nonce_endpoint=%s&authentication_endpoint=%s&favicon_url=%s",
 		token,
 		url.QueryEscape(fmt.Sprintf("%s/callback?token=%s", BASE_URL, token)),
@@ -757,6 +803,66 @@ func signinHandler(w http.ResponseWriter, r *http.Request) {
 		url.QueryEscape(BASE_URL+"/auth/v1/authenticate"),
 		url.QueryEscape(BASE_URL+"/favicon.ico"),
 	)
+}
+
+// Start sign-in flow, redirect to Idena app (BASE_URL is used everywhere).
+// Registered apps pass client_id and redirect_uri to be sent back with a
+// one-time code once the login succeeded. The optional policy parameter
+// selects the eligibility rules; registered clients always use their own.
+func signinHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var client *oidcClient
+	redirectURI := q.Get("redirect_uri")
+	policy := q.Get("policy")
+	if id := q.Get("client_id"); id != "" {
+		var ok bool
+		client, ok = lookupOIDCClient(id)
+		if !ok {
+			http.Error(w, "unknown client_id", http.StatusBadRequest)
+			return
+		}
+		if redirectURI == "" && len(client.RedirectURIs) == 1 {
+			redirectURI = client.RedirectURIs[0]
+		}
+		if !client.allowsRedirect(redirectURI) {
+			log.Printf("[SIGNIN] rejected redirect_uri %q for client %s", redirectURI, id)
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+		if client.public() {
+			// without a code challenge the code could not be redeemed
+			http.Error(w, "public clients must use /oidc/authorize with PKCE", http.StatusBadRequest)
+			return
+		}
+		if policy != "" && policy != client.Policy {
+			http.Error(w, "policy is fixed by the client registration", http.StatusBadRequest)
+			return
+		}
+		policy = client.Policy
+	}
+	if policy == "" {
+		policy = policyDefault
+	}
+	if _, ok := lookupPolicy(policy); !ok {
+		http.Error(w, "unknown policy", http.StatusBadRequest)
+		return
+	}
+	token, err := newSignInSession(policy)
+	if err != nil {
+		log.Printf("[SIGNIN] DB error storing session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if client != nil {
+		err := storeOIDCRequest(&oidcRequest{Token: token, ClientID: client.ID, RedirectURI: redirectURI, State: q.Get("state")})
+		if err != nil {
+			log.Printf("[SIGNIN] DB error storing client request: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[SIGNIN] session token=%s for client %s", token, client.ID)
+	}
+	idenaUrl := idenaSigninURL(token)
 	log.Printf("[SIGNIN] New session token=%s", token)
 	log.Printf("[SIGNIN] Redirecting to: %s", idenaUrl)
 	http.Redirect(w, r, idenaUrl, http.StatusFound)
@@ -770,6 +876,7 @@ func startSessionHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	log.Printf("[NONCE_ENDPOINT] Called: %s %s", r.Method, r.URL.Path)
+	var token, addr string
 	switch r.Method {
 	case http.MethodPost:
 		var req struct {
@@ -781,24 +888,15 @@ func startSessionHandler(w http.ResponseWriter, r *http.Request) {
 			writeError(w, "Invalid request")
 			return
 		}
-		nonce := "signin-" + randHex(16)
-		_, err := db.Exec("UPDATE sessions SET address=?, nonce=? WHERE token=?", req.Address, nonce, req.Token)
-		if err != nil {
-			log.Printf("[NONCE_ENDPOINT][POST] DB error: %v", err)
-			writeError(w, "DB error")
+		token, addr = req.Token, req.Address
+		if token == "" || addr == "" {
+			writeError(w, "Invalid request")
 			return
 		}
-		log.Printf("[NONCE_ENDPOINT][POST] Nonce issued for token %s, address %s, nonce %s", req.Token, req.Address, nonce)
-		writeJSON(w, map[string]interface{}{
-			"success": true,
-			"data": map[string]string{
-				"nonce": nonce,
-			},
-		})
 	case http.MethodGet:
 		log.Printf("[NONCE_ENDPOINT][GET] Query: %v", r.URL.Query())
-		token := r.URL.Query().Get("token")
-		addr := r.URL.Query().Get("address")
+		token = r.URL.Query().Get("token")
+		addr = r.URL.Query().Get("address")
 		if token == "" && addr == "" {
 			log.Println("[NONCE_ENDPOINT][GET] Empty params – returning 200 OK for health-check")
 			w.WriteHeader(http.StatusOK)
@@ -809,24 +907,25 @@ func startSessionHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
-		nonce := "signin-" + randHex(16)
-		_, err := db.Exec("UPDATE sessions SET address=?, nonce=? WHERE token=?", addr, nonce, token)
-		if err != nil {
-			log.Printf("[NONCE_ENDPOINT][GET] DB error: %v", err)
-			writeError(w, "DB error")
-			return
-		}
-		log.Printf("[NONCE_ENDPOINT][GET] Nonce issued for token %s, address %s, nonce %s", token, addr, nonce)
-		writeJSON(w, map[string]interface{}{
-			"success": true,
-			"data": map[string]string{
-				"nonce": nonce,
-			},
-		})
 	default:
 		log.Printf("[NONCE_ENDPOINT][%s] Method not allowed", r.Method)
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
 	}
+
+	nonce, err := issueNonce(token, addr)
+	if err != nil {
+		log.Printf("[NONCE_ENDPOINT][%s] Refused nonce for token %s, address %s: %v", r.Method, token, addr, err)
+		writeError(w, err.Error())
+		return
+	}
+	log.Printf("[NONCE_ENDPOINT][%s] Nonce issued for token %s, address %s, nonce %s", r.Method, token, addr, nonce)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"data": map[string]string{
+			"nonce": nonce,
+		},
+	})
 }
 
 // Authenticate nonce signature
@@ -853,15 +952,18 @@ func authenticateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	row := db.QueryRow("SELECT nonce, address FROM sessions WHERE token=?", req.Token)
-	var nonce, address string
-	if err := row.Scan(&nonce, &address); err != nil {
-		log.Printf("[AUTH] Token not found: %s", req.Token)
+	nonce, address, err := claimNonce(req.Token)
+	if err != nil {
+		log.Printf("[AUTH] Rejected token %s: %v", req.Token, err)
+		reason := "Session not found."
+		if err != errSessionNotFound {
+			reason = "Login session already used or expired. Please try again."
+		}
 		writeJSON(w, map[string]interface{}{
 			"success": true,
 			"data": map[string]interface{}{
 				"authenticated": false,
-				"reason":        "Session not found.",
+				"reason":        reason,
 			},
 		})
 		return
@@ -873,19 +975,17 @@ func authenticateHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[AUTH] Signature verification failed for address %s", address)
 	}
 
-	state, stake := getIdentity(address)
-	eligible, reason := evaluateEligibility(sigOK, state, stake)
-	log.Printf("[AUTH] Identity state: %s, stake: %.3f, eligible: %t", state, stake, eligible)
+	state, stake := identityFetcher(address)
+	policy := sessionPolicy(req.Token)
+	eligible, reason := evaluatePolicy(policy, sigOK, address, state, stake)
+	log.Printf("[AUTH] Identity state: %s, stake: %.3f, policy: %s, eligible: %t", state, stake, policy, eligible)
 
-	_, err := db.Exec(`UPDATE sessions SET authenticated=?, identity_state=?, stake=? WHERE token=?`,
-		boolToInt(eligible), state, stake, req.Token)
-	if err != nil {
+	if err := finishAuthentication(req.Token, eligible, state, stake, reason); err != nil {
 		log.Printf("[AUTH] DB error updating session: %v", err)
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
 	recordIdentitySnapshot(address, state, stake)
-	exportWhitelist()
 
 	writeJSON(w, map[string]interface{}{
 		"success": true,
@@ -907,11 +1007,11 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[CALLBACK] Request params: %v", r.URL.Query())
 	log.Printf("[CALLBACK] User-Agent: %s", r.Header.Get("User-Agent"))
 	log.Printf("[CALLBACK] Token: %s", token)
-	row := db.QueryRow("SELECT address, authenticated, identity_state, stake FROM sessions WHERE token=?", token)
-	var address, state string
+	row := db.QueryRow("SELECT address, authenticated, identity_state, stake, status FROM sessions WHERE token=?", token)
+	var address, state, status string
 	var authenticated int
 	var stake float64
-	err := row.Scan(&address, &authenticated, &state, &stake)
+	err := row.Scan(&address, &authenticated, &state, &stake, &status)
 
 	data := struct {
 		Headline string
@@ -926,24 +1026,36 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 		data.Headline = "Session not found"
 		data.Reason = "Your login session could not be found or has expired.<br>Please try logging in again."
 		log.Printf("[CALLBACK][DENIED] session not found for token %s", token)
+	} else if status == sessionConsumed {
+		data.Headline = "Session already used"
+		data.Reason = "This login session has already been completed.<br>Please start a new login."
+		log.Printf("[CALLBACK][DENIED] session %s already consumed", token)
 	} else {
 		data.Address = address
 		data.State = state
 		data.Stake = stake
 		eligible := authenticated == 1
-		var reason string
-		if eligible {
-			_, reason = evaluateEligibility(true, state, stake)
-		} else {
-			ok, r := evaluateEligibility(true, state, stake)
-			if ok {
-				reason = "Invalid signature."
+		reason := sessionReason(token)
+		if reason == "" {
+			if eligible {
+				reason = "Eligible for login."
 			} else {
-				reason = r
+				reason = "Login was not completed."
 			}
 		}
 		data.Reason = reason
+		if eligible && !consumeSession(token) {
+			// only an authenticated session can be turned into a login
+			eligible = false
+			reason = "Login was not completed."
+			data.Reason = reason
+		}
+		if req, ok := getOIDCRequest(token); ok {
+			finishOIDCAuthorization(w, r, req, eligible, reason)
+			return
+		}
 		if eligible {
+			setSessionCookie(w, token)
 			data.Headline = "Access granted!"
 			log.Printf("[CALLBACK][GRANTED] %s %s %.3f", address, state, stake)
 		} else {
@@ -973,82 +1085,23 @@ func whitelistHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]interface{}{"addresses": list})
 }
 
-// whitelistCurrentHandler returns the whitelist file for the node's current epoch.
-// It queries the local Idena node via JSON-RPC (dna_epoch) to determine the
-// epoch, then serves the corresponding snapshot file. All steps are logged so
-// that issues can be diagnosed easily.
+// whitelistCurrentHandler serves the whitelist of the epoch the server last
+// built one for. See serveWhitelist for the query parameters.
 func whitelistCurrentHandler(w http.ResponseWriter, r *http.Request) {
-	// Log request with timestamp and path
-	log.Printf("[WHITELIST][CURRENT] %s %s", time.Now().Format(time.RFC3339), r.URL.Path)
-
-	// Build JSON-RPC request to fetch the current epoch from the node
-	reqBody := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_epoch",
-		"id":      1,
-	}
-	if IDENA_RPC_KEY != "" {
-		reqBody["key"] = IDENA_RPC_KEY // include API key if configured
-	}
-	b, _ := json.Marshal(reqBody)
-	resp, err := http.Post("http://127.0.0.1:9009", "application/json", bytes.NewReader(b))
-	if err != nil {
-		log.Printf("[WHITELIST][CURRENT][ERROR] epoch RPC request failed: %v", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Parse the epoch from the RPC response
-	var rpcResp struct {
-		Result struct {
-			Epoch int `json:"epoch"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
-		log.Printf("[WHITELIST][CURRENT][ERROR] decoding epoch response: %v", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-	epoch := rpcResp.Result.Epoch
-	log.Printf("[WHITELIST][CURRENT] fetched epoch=%d", epoch)
-
-	// Construct the file path for this epoch's whitelist
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
-	log.Printf("[WHITELIST][CURRENT] path=%s", path)
-
-	// Read and return the file. Errors are logged and reported as 500.
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Printf("[WHITELIST][CURRENT][ERROR] reading %s: %v", path, err)
-		http.Error(w, "server error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
+	wlMu.RLock()
+	epoch := currentEpoch
+	wlMu.RUnlock()
+	serveWhitelist(w, r, epoch)
 }
 
+// whitelistEpochHandler serves GET /whitelist/epoch/{n}.
 func whitelistEpochHandler(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	epochStr := parts[len(parts)-1]
-	epoch, err := strconv.Atoi(epochStr)
+	epoch, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/whitelist/epoch/"))
 	if err != nil {
 		http.Error(w, "bad epoch", 400)
 		return
 	}
-	path := fmt.Sprintf("data/whitelist_epoch_%d.json", epoch)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
-	}
-	var list []string
-	if err := json.Unmarshal(data, &list); err != nil {
-		http.Error(w, "server error", 500)
-		return
-	}
-	writeJSON(w, map[string]interface{}{"addresses": list, "epoch": epoch})
+	serveWhitelist(w, r, epoch)
 }
 
 // whitelistCheckHandler fetches identity details for the given address and
@@ -1181,6 +1234,16 @@ func eligibilitySnapshotHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// requestScheme returns the Merkle scheme named by the scheme query
+// parameter, or the configured default.
+func requestScheme(r *http.Request) (merkle.Scheme, error) {
+	name := r.URL.Query().Get("scheme")
+	if name == "" {
+		name = merkleScheme
+	}
+	return merkle.Get(name)
+}
+
 func merkleRootHandler(w http.ResponseWriter, r *http.Request) {
 	wlMu.RLock()
 	epoch := currentEpoch
@@ -1190,22 +1253,25 @@ func merkleRootHandler(w http.ResponseWriter, r *http.Request) {
 			epoch = ep
 		}
 	}
+	scheme, err := requestScheme(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	root, ok := getMerkleRoot(epoch)
+	root, ok := getMerkleRoot(epoch, scheme.Name())
 	if !ok {
-		list, fileRoot, err := loadWhitelistData(epoch)
-		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
+		t, ok := epochTree(w, epoch, scheme)
+		if !ok {
 			return
 		}
-		if fileRoot != "" {
-			root = fileRoot
-		} else {
-			root = computeMerkleRoot(list)
-		}
-		saveMerkleRoot(epoch, root)
+		root = t.Root()
+	}
+	resp := map[string]interface{}{"merkle_root": root, "epoch": epoch, "scheme": scheme.Name()}
+	if a, ok := getAttestation(epoch, scheme.Name()); ok && a.MerkleRoot == root {
+		resp["attestation"] = a
 	}
-	writeJSON(w, map[string]interface{}{"merkle_root": root, "epoch": epoch})
+	writeJSON(w, resp)
 }
 
 func merkleProofHandler(w http.ResponseWriter, r *http.Request) {
@@ -1231,37 +1297,31 @@ func merkleProofHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var list []string
-	var root string
-	var err error
-	if epoch == currentEpoch {
-		list, err = getWhitelist()
-		if err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
-			return
-		}
-		root, _ = getMerkleRoot(epoch)
-	} else {
-		list, root, err = loadWhitelistData(epoch)
-		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
-		}
-	}
-	if root == "" {
-		root = computeMerkleRoot(list)
+	scheme, err := requestScheme(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	proof, ok := computeMerkleProof(list, addr)
+	t, ok := epochTree(w, epoch, scheme)
 	if !ok {
+		return
+	}
+	proof, err := t.Proof(addr)
+	if err != nil {
 		http.Error(w, "address not found", http.StatusNotFound)
 		return
 	}
+	if r.URL.Query().Get("format") == "abi" {
+		writeABIProof(w, t, addr, proof)
+		return
+	}
 
 	writeJSON(w, map[string]interface{}{
-		"merkle_root": root,
+		"merkle_root": t.Root(),
 		"proof":       proof,
 		"epoch":       epoch,
+		"scheme":      scheme.Name(),
 	})
 }
 
@@ -1297,7 +1357,7 @@ func logsStreamHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func epochsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT epoch FROM epoch_merkle_roots ORDER BY epoch DESC LIMIT 20")
+	rows, err := db.Query("SELECT DISTINCT epoch FROM epoch_merkle_roots ORDER BY epoch DESC LIMIT 20")
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
@@ -1343,69 +1403,13 @@ func verifySignature(nonce, address, signatureHex string) bool {
 
 // Get identity from node or public API as fallback
 func getIdentity(address string) (string, float64) {
-	rpcReq := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_identity",
-		"params":  []string{address},
-		"id":      1,
-	}
-	if IDENA_RPC_KEY != "" {
-		rpcReq["key"] = IDENA_RPC_KEY
-	}
-	body, _ := json.Marshal(rpcReq)
-	req, _ := http.NewRequest("POST", idenaRpcUrl, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil && resp.StatusCode == 200 {
-		var rpcResp struct {
-			Result struct {
-				State string  `json:"state"`
-				Stake float64 `json:"stake,string"`
-			} `json:"result"`
-			Error struct {
-				Code    int    `json:"code"`
-				Message string `json:"message"`
-			} `json:"error"`
-		}
-		_ = json.NewDecoder(resp.Body).Decode(&rpcResp)
-		if rpcResp.Error.Message == "" || rpcResp.Error.Code == 0 {
-			if rpcResp.Result.State != "" {
-				log.Printf("[IDENTITY][RPC] Success: state=%s, stake=%.3f", rpcResp.Result.State, rpcResp.Result.Stake)
-				return rpcResp.Result.State, rpcResp.Result.Stake
-			}
-		}
-		if rpcResp.Error.Message != "" {
-			log.Printf("[IDENTITY][RPC] Node returned error: %+v", rpcResp.Error)
-		}
-	} else {
-		log.Printf("[IDENTITY][RPC] RPC call failed: %v", err)
-	}
-	log.Printf("[IDENTITY][FALLBACK] Using public indexer for %s", address)
-	var state string
-	resp2, err := http.Get(fallbackApiUrl + "/api/Identity/" + address)
-	if err == nil && resp2.StatusCode == 200 {
-		var apiResp struct {
-			Result struct {
-				State string `json:"state"`
-			} `json:"result"`
-		}
-		_ = json.NewDecoder(resp2.Body).Decode(&apiResp)
-		state = apiResp.Result.State
-	}
-	var stake float64
-	resp3, err := http.Get(fallbackApiUrl + "/api/Address/" + address)
-	if err == nil && resp3.StatusCode == 200 {
-		var addrResp struct {
-			Result struct {
-				Stake string `json:"stake"`
-			} `json:"result"`
-		}
-		_ = json.NewDecoder(resp3.Body).Decode(&addrResp)
-		stake, _ = strconv.ParseFloat(addrResp.Result.Stake, 64)
+	id, err := identitySource.GetIdentity(address)
+	if err != nil {
+		log.Printf("[IDENTITY] %s: %v", address, err)
+		return "", 0
 	}
-	log.Printf("[IDENTITY][FALLBACK] Indexer: state=%s, stake=%.3f", state, stake)
-	return state, stake
+	log.Printf("[IDENTITY] state=%s, stake=%.3f", id.State, id.Stake)
+	return id.State, id.Stake
 }
 
 func boolToInt(b bool) int {
@@ -1415,33 +1419,12 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-// evaluateEligibility checks signature validity, identity state and stake
-// against the configured threshold. It returns whether the user is eligible
-// and a human readable reason for the result.
-func evaluateEligibility(sigOK bool, state string, stake float64) (bool, string) {
-	reasons := []string{}
-	if !sigOK {
-		reasons = append(reasons, "Invalid signature.")
-	}
-	if state == "" {
-		reasons = append(reasons, "Identity not found or status undefined.")
-	} else if state != "Human" && state != "Verified" && state != "Newbie" {
-		reasons = append(reasons, fmt.Sprintf("Identity state %s is not eligible.", state))
-	}
-	if stake < stakeThreshold {
-		reasons = append(reasons, fmt.Sprintf("Stake too low: %.3f (%.3f required).", stake, stakeThreshold))
-	}
-	if len(reasons) == 0 {
-		return true, "Eligible for login."
-	}
-	return false, strings.Join(reasons, " ")
-}
-
 // Clean up expired sessions regularly
 func cleanupExpiredSessions() {
 	for {
-		_, _ = db.Exec("DELETE FROM sessions WHERE created < ?", time.Now().Add(-1*time.Hour).Unix())
+		_, _ = db.Exec("DELETE FROM sessions WHERE created < ?", time.Now().Unix()-sessionDuration)
 		cleanupOldSnapshots()
+		cleanupOIDC()
 		exportWhitelist()
 		log.Println("[CLEANUP] housekeeping done")
 		time.Sleep(15 * time.Minute)
@@ -1596,95 +1579,24 @@ func getEpochSnapshot(epoch int, addr string) (string, float64, bool, bool, bool
 	return "", 0, false, false, false
 }
 
-// fetchIdentityFromNode queries the local node for an identity.
-func fetchIdentityFromNode(addr string) (string, float64, error) {
-	var resp struct {
-		Result struct {
-			State string  `json:"state"`
-			Stake float64 `json:"stake,string"`
-		} `json:"result"`
-		Error *struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-		} `json:"error"`
-	}
-	if err := callLocalRPC("dna_identity", []interface{}{addr}, &resp); err != nil {
-		return "", 0, err
-	}
-	if resp.Error != nil && resp.Error.Message != "" {
-		return "", 0, fmt.Errorf(resp.Error.Message)
-	}
-	if resp.Result.State == "" {
-		return "", 0, fmt.Errorf("empty state")
-	}
-	return resp.Result.State, resp.Result.Stake, nil
-}
-
-// fetchIdentityFromAPI queries the public API for identity state and stake.
-func fetchIdentityFromAPI(addr string) (string, float64, error) {
-	var state string
-	resp, err := http.Get(fallbackApiUrl + "/api/Identity/" + addr)
-	if err == nil && resp.StatusCode == http.StatusOK {
-		var apiResp struct {
-			Result struct {
-				State string `json:"state"`
-			} `json:"result"`
-		}
-		_ = json.NewDecoder(resp.Body).Decode(&apiResp)
-		state = apiResp.Result.State
-	}
-	resp2, err2 := http.Get(fallbackApiUrl + "/api/Address/" + addr)
-	var stake float64
-	if err2 == nil && resp2.StatusCode == http.StatusOK {
-		var addrResp struct {
-			Result struct {
-				Stake string `json:"stake"`
-			} `json:"result"`
-		}
-		_ = json.NewDecoder(resp2.Body).Decode(&addrResp)
-		stake, _ = strconv.ParseFloat(addrResp.Result.Stake, 64)
-	}
-	if state == "" && stake == 0 {
-		return "", 0, fmt.Errorf("api error")
-	}
-	return state, stake, nil
-}
-
 // updateIdentityCache refreshes the cached identity information.
 func updateIdentityCache(addr string) (string, float64, error) {
-	state, stake, err := fetchIdentityFromNode(addr)
-	if err != nil || state == "" {
-		state, stake, err = fetchIdentityFromAPI(addr)
+	id, err := identitySource.GetIdentity(addr)
+	if err != nil {
+		return "", 0, err
 	}
-	if err == nil && state != "" {
-		recordIdentitySnapshot(addr, state, stake)
+	if id.State != "" {
+		recordIdentitySnapshot(addr, id.State, id.Stake)
 	}
-	return state, stake, err
+	return id.State, id.Stake, nil
 }
 
 func fetchValidationPenalty(epoch int, addr string) (bool, error) {
-	url := fmt.Sprintf("%s/api/Epoch/%d/Identity/%s/ValidationSummary", fallbackApiUrl, epoch, addr)
-	resp, err := http.Get(url)
+	sum, err := apiSource.ValidationSummary(epoch, addr)
 	if err != nil {
 		return false, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("status %s", resp.Status)
-	}
-	var out struct {
-		Result struct {
-			Penalized bool   `json:"penalized"`
-			Reason    string `json:"penaltyReason"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return false, err
-	}
-	if out.Result.Penalized {
-		return true, nil
-	}
-	return false, nil
+	return sum.Penalized, nil
 }
 
 func getPenaltyStatus(epoch int, addr string) bool {
@@ -1793,16 +1705,23 @@ func identityHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// runIndexerCLI builds the whitelist for the given epoch and prints the Merkle root.
-// If epoch is 0, the latest epoch from the node is used.
-func runIndexerCLI(epoch int) {
+// openDatabase opens dbFile, creates every table and loads the attestation
+// key. The server and the -index mode share it, so a whitelist built from
+// the command line lands in the same schema and is signed like one built by
+// the server.
+func openDatabase() {
 	var err error
 	db, err = sql.Open("sqlite3", dbFile)
 	if err != nil {
-		log.Fatalf("open db: %v", err)
+		log.Fatalf("Failed to open DB: %v", err)
 	}
-	defer db.Close()
+	createTables()
+	if err := loadAttestationKey(ATTESTATION_KEY_FILE); err != nil {
+		log.Printf("WARNING: attestation key unavailable, roots stay unsigned: %v", err)
+	}
+}
 
+func createTables() {
 	createSessionTable()
 	createSnapshotTable()
 	createEpochSnapshotTable()
@@ -1810,7 +1729,21 @@ func runIndexerCLI(epoch int) {
 	createConfigTable()
 	createEpochTable()
 	createMerkleRootTable()
+	createMerkleTreeTable()
+	createManifestTable()
+	createCheckpointTable()
+	createEpochEnumTables()
+	createCandidateTable()
 	createPenaltyTable()
+	createOIDCTables()
+	createClientTable()
+}
+
+// runIndexerCLI builds the whitelist for the given epoch and prints the Merkle root.
+// If epoch is 0, the latest epoch from the node is used.
+func runIndexerCLI(epoch int) {
+	openDatabase()
+	defer db.Close()
 
 	ep, thr, err := fetchEpochData()
 	if err != nil {
@@ -1824,6 +1757,6 @@ func runIndexerCLI(epoch int) {
 		log.Fatalf("build whitelist: %v", err)
 	}
 	saveSnapshotMeta(ep, 0)
-	root, _ := getMerkleRoot(ep)
+	root, _ := getMerkleRoot(ep, merkleScheme)
 	fmt.Printf("epoch %d merkle root %s\n", ep, root)
 }
@@ -15,6 +15,8 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
+
+	"idenauthgo/sessionscan"
 )
 
 type roundTripFunc func(*http.Request) (*http.Response, error)
@@ -133,33 +135,79 @@ func TestStartSessionHandlerMissingAddress(t *testing.T) {
 	}
 }
 
+// sessionNodeResponse answers the session scanner's JSON-RPC requests for a
+// validation whose short session starts at block 115, long session at 120,
+// after long session at 125 and whose epoch 10 starts at block 130. senders
+// maps block heights to the senders of their short answer transactions; a
+// nil entry is a block without transactions.
+func sessionNodeResponse(req *http.Request, senders map[int64][]string) (*http.Response, error) {
+	var call struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+		return nil, err
+	}
+	var result interface{}
+	switch call.Method {
+	case "dna_epoch":
+		result = map[string]interface{}{"epoch": 10, "startBlock": 130}
+	case "bcn_blockAt":
+		h := int64(call.Params[0].(float64))
+		blk := map[string]interface{}{"height": h, "hash": fmt.Sprintf("0xblock%d", h)}
+		switch h {
+		case 115:
+			blk["flags"] = []string{"ShortSessionStarted"}
+		case 120:
+			blk["flags"] = []string{"LongSessionStarted"}
+		case 125:
+			blk["flags"] = []string{"AfterLongSessionStarted"}
+		case 130:
+			blk["flags"] = []string{"ValidationFinished"}
+		}
+		var txs []string
+		for i := range senders[h] {
+			txs = append(txs, fmt.Sprintf("0xtx%d_%d", h, i))
+		}
+		blk["transactions"] = txs
+		result = blk
+	case "bcn_transaction":
+		var h int64
+		var i int
+		fmt.Sscanf(call.Params[0].(string), "0xtx%d_%d", &h, &i)
+		typ := "submitAnswersHash"
+		if h >= 120 {
+			typ = "submitShortAnswers"
+		}
+		result = map[string]interface{}{"hash": call.Params[0], "type": typ, "from": senders[h][i]}
+	default:
+		return nil, fmt.Errorf("unexpected node method %s", call.Method)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
+}
+
 func TestBuildEpochWhitelistFallback(t *testing.T) {
 	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
 
 	oldFetch := fetchEpochIdentitiesFn
 	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) { return nil, fmt.Errorf("fail") }
 	defer func() { fetchEpochIdentitiesFn = oldFetch }()
 
-	requiredBlocks = 1
-	defer func() { requiredBlocks = 7 }()
+	oldScan := sessionScan
+	sessionScan = sessionscan.New(nodeSource, SESSION_BLOCK_CACHE)
+	defer func() { sessionScan = oldScan }()
 
 	oldClient := http.DefaultClient
 	defer func() { http.DefaultClient = oldClient }()
 
 	http.DefaultClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
 		switch req.URL.String() {
-		case fallbackApiUrl + "/api/Epoch/9":
-			resp := map[string]interface{}{"result": map[string]int{"validationFirstBlockHeight": 100}}
-			b, _ := json.Marshal(resp)
-			return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
-		case fallbackApiUrl + "/api/Block/115":
-			resp := map[string]interface{}{"result": map[string]interface{}{"flags": []string{"ShortSessionStarted"}}}
-			b, _ := json.Marshal(resp)
-			return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
-		case fallbackApiUrl + "/api/Block/115/Txs?limit=100":
-			resp := map[string]interface{}{"result": []map[string]string{{"from": "0xabc"}}}
-			b, _ := json.Marshal(resp)
-			return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
+		case idenaRpcUrl:
+			return sessionNodeResponse(req, map[int64][]string{115: {"0xabc"}})
 		case fallbackApiUrl + "/api/Epoch/9/Authors/Bad?limit=100":
 			resp := map[string]interface{}{"result": []interface{}{}, "continuationToken": ""}
 			b, _ := json.Marshal(resp)
@@ -188,44 +236,35 @@ func TestBuildEpochWhitelistFallback(t *testing.T) {
 
 func TestBuildEpochWhitelistSkipEmptyBlock(t *testing.T) {
 	cases := []struct {
-		name  string
-		empty interface{}
+		name    string
+		senders map[int64][]string
 	}{
-		{"null", nil},
-		{"empty", []interface{}{}},
+		{"short session start empty", map[int64][]string{116: {"0xdef"}}},
+		{"gap after short session start", map[int64][]string{118: {"0xdef"}}},
+		{"late answers in long session", map[int64][]string{124: {"0xdef"}}},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			setupTestDB(t)
+			oldDir := dataDir
+			dataDir = t.TempDir()
+			defer func() { dataDir = oldDir }()
 
 			oldFetch := fetchEpochIdentitiesFn
 			fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) { return nil, fmt.Errorf("fail") }
 			defer func() { fetchEpochIdentitiesFn = oldFetch }()
 
-			requiredBlocks = 1
-			defer func() { requiredBlocks = 7 }()
+			oldScan := sessionScan
+			sessionScan = sessionscan.New(nodeSource, SESSION_BLOCK_CACHE)
+			defer func() { sessionScan = oldScan }()
 
 			oldClient := http.DefaultClient
 			defer func() { http.DefaultClient = oldClient }()
 
 			http.DefaultClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
 				switch req.URL.String() {
-				case fallbackApiUrl + "/api/Epoch/9":
-					resp := map[string]interface{}{"result": map[string]int{"validationFirstBlockHeight": 100}}
-					b, _ := json.Marshal(resp)
-					return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
-				case fallbackApiUrl + "/api/Block/115":
-					resp := map[string]interface{}{"result": map[string]interface{}{"flags": []string{"ShortSessionStarted"}}}
-					b, _ := json.Marshal(resp)
-					return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
-				case fallbackApiUrl + "/api/Block/115/Txs?limit=100":
-					resp := map[string]interface{}{"result": tc.empty}
-					b, _ := json.Marshal(resp)
-					return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
-				case fallbackApiUrl + "/api/Block/116/Txs?limit=100":
-					resp := map[string]interface{}{"result": []map[string]string{{"from": "0xdef"}}}
-					b, _ := json.Marshal(resp)
-					return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(b)), Header: make(http.Header)}, nil
+				case idenaRpcUrl:
+					return sessionNodeResponse(req, tc.senders)
 				case fallbackApiUrl + "/api/Epoch/9/Authors/Bad?limit=100":
 					resp := map[string]interface{}{"result": []interface{}{}, "continuationToken": ""}
 					b, _ := json.Marshal(resp)
@@ -271,11 +310,9 @@ func setupTestDB(t *testing.T) {
 	if err != nil {
 		t.Fatalf("open db: %v", err)
 	}
-	createSessionTable()
-	createEpochSnapshotTable()
-	createSnapshotMetaTable()
-	createPenaltyTable()
-	createMerkleRootTable()
+	createTables()
+	loadSessionSecret()
+	merkleTrees = newTreeLRU(merkleTreeCacheSize)
 	resultTmpl = mustLoadTemplate("templates/result.html")
 }
 
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"idenauthgo/artifacts"
+	"idenauthgo/eligibility"
+	"idenauthgo/merkle"
+)
+
+// Every whitelist build records a manifest of what produced it: the block the
+// identity data was read at, the threshold, the data source, the rule set and
+// a hash of the identity records. The records themselves stay in
+// epoch_identity_snapshot, so `-reproduce <epoch>` can rebuild the whitelist
+// offline and compare it with the recorded root.
+
+// Whitelist sources recorded in manifests.
+const (
+	// manifestSourceNode is the epoch identity list of the identity source
+	// chain (local node, indexer, public API).
+	manifestSourceNode = "node"
+	// manifestSourceAPI is buildEpochWhitelistAPI, which takes the short
+	// answer senders from the local node's session scan and their
+	// validation outcome from the public API.
+	manifestSourceAPI = "api-fallback"
+)
+
+type snapshotManifest struct {
+	Epoch             int     `json:"epoch"`
+	SnapshotBlock     int64   `json:"snapshot_block"`
+	SnapshotBlockHash string  `json:"snapshot_block_hash,omitempty"`
+	Threshold         float64 `json:"threshold"`
+	Source            string  `json:"source"`
+	Provider          string  `json:"provider,omitempty"`
+	RuleSetHash       string  `json:"rule_set_hash"`
+	InputHash         string  `json:"input_hash"`
+	InputCount        int     `json:"input_count"`
+	AddressCount      int     `json:"address_count"`
+	MerkleScheme      string  `json:"merkle_scheme"`
+	MerkleRoot        string  `json:"merkle_root"`
+	ToolVersion       string  `json:"tool_version"`
+	Timestamp         string  `json:"timestamp"`
+}
+
+// blockRef identifies the block a whitelist's identity data was read at.
+type blockRef struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+func createManifestTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS epoch_manifests (
+            epoch INTEGER PRIMARY KEY,
+            manifest TEXT NOT NULL,
+            ts INTEGER
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func manifestPath(epoch int) string {
+	return filepath.Join(dataDir, fmt.Sprintf("whitelist_epoch_%d.manifest.json", epoch))
+}
+
+// nodeBlock returns the node's current head. Failures leave the block
+// unknown rather than failing the build.
+func nodeBlock() blockRef {
+	var b blockRef
+	if err := nodeSource.Call("bcn_lastBlock", []interface{}{}, &b); err != nil {
+		log.Printf("[MANIFEST] snapshot block unavailable: %v", err)
+	}
+	return b
+}
+
+// toolVersion identifies the running binary by module version and VCS
+// revision when the build recorded them.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var rev, dirty string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if rev != "" {
+		version += "+" + rev + dirty
+	}
+	return version
+}
+
+// inputSetHash hashes the identity records a whitelist is built from, one
+// canonical line per identity in address order.
+func inputSetHash(snaps []EpochSnapshot) string {
+	lines := make([]string, len(snaps))
+	for i, s := range snaps {
+		lines[i] = strings.Join([]string{
+			strings.ToLower(s.Address),
+			s.State,
+			strconv.FormatFloat(s.Stake, 'f', -1, 64),
+			strconv.Itoa(boolToInt(s.Penalized)),
+			strconv.Itoa(boolToInt(s.FlipReported)),
+		}, ",")
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		h.Write([]byte(l + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// eligibleAddresses applies the whitelist rules to snaps and returns the
+// sorted lowercase addresses that pass.
+func eligibleAddresses(snaps []EpochSnapshot, threshold float64) []string {
+	var list []string
+	for _, s := range snaps {
+		if eligibility.IsEligibleFull(s.State, s.Stake, s.Penalized, s.FlipReported, threshold) {
+			list = append(list, strings.ToLower(s.Address))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// finishEpochWhitelist stores the identity records of an epoch, derives the
+// whitelist and its root from them, records the build manifest and
+// publishes the list as the current one unless a later epoch is live.
+func finishEpochWhitelist(epoch int, threshold float64, source, provider string, blk blockRef, snaps []EpochSnapshot) ([]string, string, error) {
+	if err := replaceEpochSnapshots(db, epoch, snaps); err != nil {
+		return nil, "", err
+	}
+	list := eligibleAddresses(snaps, threshold)
+	root, scheme, err := whitelistRoot(epoch, list)
+	if err != nil {
+		return nil, "", err
+	}
+	m := snapshotManifest{
+		Epoch:             epoch,
+		SnapshotBlock:     blk.Height,
+		SnapshotBlockHash: blk.Hash,
+		Threshold:         threshold,
+		Source:            source,
+		Provider:          provider,
+		RuleSetHash:       eligibility.RuleSetHash(),
+		InputHash:         inputSetHash(snaps),
+		InputCount:        len(snaps),
+		AddressCount:      len(list),
+		MerkleScheme:      scheme,
+		MerkleRoot:        root,
+		ToolVersion:       toolVersion(),
+		Timestamp:         time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := saveManifest(&m); err != nil {
+		return nil, "", fmt.Errorf("manifest: %w", err)
+	}
+	// the roots were signed before the manifest recorded the snapshot block
+	attestEpochRoots(epoch)
+	// written after the manifest so the file carries it in its header
+	if err := writeWhitelistFile(epoch, list, root, scheme); err != nil {
+		return nil, "", err
+	}
+	// the list goes live together with its epoch; rebuilding an older epoch
+	// leaves the published one alone
+	wlMu.Lock()
+	advanced := epoch >= currentEpoch
+	if advanced {
+		currentEpoch = epoch
+		currentWhitelist = list
+	}
+	wlMu.Unlock()
+	if advanced {
+		setConfigInt("current_epoch", epoch)
+	}
+	return list, root, nil
+}
+
+// saveManifest writes m next to the whitelist file and into epoch_manifests.
+func saveManifest(m *snapshotManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT OR REPLACE INTO epoch_manifests(epoch, manifest, ts) VALUES(?,?,?)`,
+		m.Epoch, string(data), time.Now().Unix()); err != nil {
+		return err
+	}
+	return writeArtifact(manifestPath(m.Epoch), data)
+}
+
+// ARTIFACT_VERSIONS is the number of prior versions kept of every whitelist
+// and manifest file, see the artifacts package.
+var ARTIFACT_VERSIONS = getenvInt("ARTIFACT_VERSIONS", artifacts.DefaultRetain)
+
+var artifactStore = newArtifactStore()
+
+func newArtifactStore() *artifacts.Store {
+	s := artifacts.Default()
+	s.SetRetain(ARTIFACT_VERSIONS)
+	return s
+}
+
+// writeArtifact atomically replaces a file in the data dir. All whitelist
+// and manifest writes go through it, so they are performed one at a time.
+func writeArtifact(path string, data []byte) error {
+	v, err := artifactStore.Write(path, data, 0644)
+	if err != nil {
+		return err
+	}
+	if !v.Unchanged {
+		log.Printf("[ARTIFACT] wrote %s sha256=%s", path, v.Hash)
+	}
+	return nil
+}
+
+// loadManifest returns the recorded manifest of an epoch.
+func loadManifest(epoch int) (*snapshotManifest, error) {
+	var data string
+	err := db.QueryRow(`SELECT manifest FROM epoch_manifests WHERE epoch=?`, epoch).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no manifest recorded for epoch %d", epoch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m snapshotManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// reproduction compares a rebuild of an epoch whitelist with its manifest.
+type reproduction struct {
+	Manifest    *snapshotManifest
+	InputHash   string
+	InputCount  int
+	InputsMatch bool
+	RulesMatch  bool
+	Root        string
+	RootMatches bool
+	// Added and Removed list the rebuilt whitelist's differences from the
+	// recorded whitelist file.
+	Added   []string
+	Removed []string
+}
+
+// reproduceEpoch rebuilds the whitelist of epoch from its stored identity
+// records with the recorded threshold and scheme. Nothing is written.
+func reproduceEpoch(epoch int) (*reproduction, error) {
+	m, err := loadManifest(epoch)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := loadEpochSnapshots(db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := merkle.Get(m.MerkleScheme)
+	if err != nil {
+		return nil, err
+	}
+	list := eligibleAddresses(snaps, m.Threshold)
+	root, err := scheme.Root(list, epoch)
+	if err != nil {
+		return nil, err
+	}
+	r := &reproduction{
+		Manifest:    m,
+		InputHash:   inputSetHash(snaps),
+		InputCount:  len(snaps),
+		RulesMatch:  m.RuleSetHash == eligibility.RuleSetHash(),
+		Root:        root,
+		RootMatches: root == m.MerkleRoot,
+	}
+	r.InputsMatch = r.InputHash == m.InputHash
+	if recorded, _, _, err := loadWhitelistData(epoch); err == nil {
+		r.Added, r.Removed = diffAddresses(recorded, list)
+	}
+	return r, nil
+}
+
+// diffAddresses returns the addresses of next missing from prev and those of
+// prev missing from next, compared case-insensitively.
+func diffAddresses(prev, next []string) (added, removed []string) {
+	old := make(map[string]bool, len(prev))
+	for _, a := range prev {
+		old[strings.ToLower(a)] = true
+	}
+	cur := make(map[string]bool, len(next))
+	for _, a := range next {
+		a = strings.ToLower(a)
+		cur[a] = true
+		if !old[a] {
+			added = append(added, a)
+		}
+	}
+	for a := range old {
+		if !cur[a] {
+			removed = append(removed, a)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// runReproduceCLI implements -reproduce: it prints the comparison of a
+// rebuild with the recorded manifest and returns the exit code.
+func runReproduceCLI(epoch int) int {
+	r, err := reproduceEpoch(epoch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reproduce epoch %d: %v\n", epoch, err)
+		return 1
+	}
+	m := r.Manifest
+	fmt.Printf("epoch %d built %s by %s from %s at block %d %s, threshold %g\n",
+		m.Epoch, m.Timestamp, m.ToolVersion, m.Source, m.SnapshotBlock, m.SnapshotBlockHash, m.Threshold)
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "MISMATCH"
+	}
+	fmt.Printf("inputs: %d identities, hash %s (recorded %d, %s)\n", r.InputCount, status(r.InputsMatch), m.InputCount, m.InputHash)
+	if !r.RulesMatch {
+		fmt.Printf("rules: WARNING recorded rule set %s differs from current %s\n", m.RuleSetHash, eligibility.RuleSetHash())
+	}
+	fmt.Printf("root: %s %s (recorded %s)\n", m.MerkleScheme, status(r.RootMatches), m.MerkleRoot)
+	if r.Root != m.MerkleRoot {
+		fmt.Printf("rebuilt root: %s\n", r.Root)
+	}
+	for _, a := range r.Added {
+		fmt.Printf("+ %s\n", a)
+	}
+	for _, a := range r.Removed {
+		fmt.Printf("- %s\n", a)
+	}
+	if !r.RootMatches || !r.InputsMatch {
+		return 1
+	}
+	return 0
+}
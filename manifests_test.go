@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"idenauthgo/artifacts"
+	"idenauthgo/eligibility"
+	"idenauthgo/wlformat"
+)
+
+func TestEpochManifestReproduce(t *testing.T) {
+	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+
+	snaps := []EpochSnapshot{
+		{Address: "0xAAA", State: "Human", Stake: 15000},
+		{Address: "0xbbb", State: "Verified", Stake: 10000},
+		{Address: "0xccc", State: "Human", Stake: 20000, Penalized: true},
+		{Address: "0xddd", State: "Newbie", Stake: 500},
+	}
+	list, root, err := finishEpochWhitelist(7, 12000, manifestSourceAPI, "", blockRef{Height: 115, Hash: "0xh"}, snaps)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if len(list) != 2 || list[0] != "0xaaa" || list[1] != "0xbbb" {
+		t.Fatalf("unexpected whitelist %v", list)
+	}
+
+	data, err := os.ReadFile(manifestPath(7))
+	if err != nil {
+		t.Fatalf("manifest file: %v", err)
+	}
+	var m snapshotManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if m.Epoch != 7 || m.SnapshotBlock != 115 || m.SnapshotBlockHash != "0xh" || m.Threshold != 12000 ||
+		m.Source != manifestSourceAPI || m.MerkleRoot != root || m.InputCount != 4 || m.AddressCount != 2 ||
+		m.RuleSetHash != eligibility.RuleSetHash() || m.InputHash != inputSetHash(snaps) || m.ToolVersion == "" {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	data, err = os.ReadFile(whitelistPath(7))
+	if err != nil {
+		t.Fatalf("whitelist file: %v", err)
+	}
+	wl, f, err := wlformat.Parse(data)
+	if err != nil || f != wlformat.Canonical || wl.Epoch != 7 || wl.Root != root || len(wl.Manifest) == 0 {
+		t.Fatalf("unexpected whitelist file %+v %s %v", wl, f, err)
+	}
+	if len(wl.Entries) != 2 || wl.Entries[0].Address != "0xaaa" || wl.Entries[0].State != "Human" || wl.Entries[0].Stake != 15000 ||
+		wl.Entries[1].State != "Verified" {
+		t.Fatalf("unexpected entries %+v", wl.Entries)
+	}
+
+	r, err := reproduceEpoch(7)
+	if err != nil {
+		t.Fatalf("reproduce: %v", err)
+	}
+	if !r.RootMatches || !r.InputsMatch || !r.RulesMatch || len(r.Added) != 0 || len(r.Removed) != 0 {
+		t.Fatalf("expected exact reproduction, got %+v", r)
+	}
+
+	// a changed input record changes the rebuilt root
+	if _, err := db.Exec(`UPDATE epoch_identity_snapshot SET stake=9000 WHERE epoch=7 AND address='0xbbb'`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	r, err = reproduceEpoch(7)
+	if err != nil {
+		t.Fatalf("reproduce: %v", err)
+	}
+	if r.RootMatches || r.InputsMatch || len(r.Removed) != 1 || r.Removed[0] != "0xbbb" {
+		t.Fatalf("expected mismatch, got %+v", r)
+	}
+
+	if _, err := reproduceEpoch(8); err == nil {
+		t.Fatalf("expected error without manifest")
+	}
+}
+
+func TestInputSetHashOrderIndependent(t *testing.T) {
+	a := []EpochSnapshot{{Address: "0xabc", State: "Human", Stake: 1.5}, {Address: "0xdef", State: "Newbie", Stake: 10000}}
+	b := []EpochSnapshot{a[1], {Address: "0xABC", State: "Human", Stake: 1.5}}
+	if inputSetHash(a) != inputSetHash(b) {
+		t.Fatalf("hash depends on order or case")
+	}
+	b[0].FlipReported = true
+	if inputSetHash(a) == inputSetHash(b) {
+		t.Fatalf("hash ignores flip flag")
+	}
+}
+
+func TestWhitelistRebuildKeepsPriorVersion(t *testing.T) {
+	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+
+	first := []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}
+	if _, _, err := finishEpochWhitelist(8, 12000, manifestSourceNode, "", blockRef{}, first); err != nil {
+		t.Fatalf("first build: %v", err)
+	}
+	before := mustRead(t, whitelistPath(8))
+	second := append(first, EpochSnapshot{Address: "0xbbb", State: "Human", Stake: 15000})
+	if _, _, err := finishEpochWhitelist(8, 12000, manifestSourceNode, "", blockRef{}, second); err != nil {
+		t.Fatalf("second build: %v", err)
+	}
+	// writing the same content again does not add a version
+	if err := writeArtifact(whitelistPath(8), mustRead(t, whitelistPath(8))); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	vs, err := artifacts.Versions(whitelistPath(8))
+	if err != nil || len(vs) != 1 || vs[0].Hash != artifacts.Hash(before) {
+		t.Fatalf("unexpected versions %+v %v", vs, err)
+	}
+}
+
+func TestOlderRebuildKeepsLiveWhitelist(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldEpoch, oldList := dataDir, currentEpoch, currentWhitelist
+	dataDir, currentEpoch, currentWhitelist = t.TempDir(), 0, nil
+	defer func() { dataDir, currentEpoch, currentWhitelist = oldDir, oldEpoch, oldList }()
+
+	live := []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}
+	if _, _, err := finishEpochWhitelist(9, 12000, manifestSourceNode, "", blockRef{}, live); err != nil {
+		t.Fatalf("epoch 9: %v", err)
+	}
+	older := append(live, EpochSnapshot{Address: "0xbbb", State: "Human", Stake: 15000})
+	if _, _, err := finishEpochWhitelist(8, 12000, manifestSourceNode, "", blockRef{}, older); err != nil {
+		t.Fatalf("epoch 8: %v", err)
+	}
+	epoch, list, err := liveWhitelist()
+	if err != nil || epoch != 9 || len(list) != 1 || getConfigInt("current_epoch") != 9 {
+		t.Fatalf("live whitelist %d %v %v", epoch, list, err)
+	}
+	exportWhitelist()
+	if wl, _, err := wlformat.Parse(mustRead(t, whitelistPath(9))); err != nil || len(wl.Addresses()) != 1 {
+		t.Fatalf("exported epoch 9: %v %v", wl, err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,133 @@
+package merkle
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Neighbor is a leaf next to an excluded address together with its position
+// and inclusion proof.
+type Neighbor struct {
+	Address string      `json:"address"`
+	Index   int         `json:"index"`
+	Proof   []ProofStep `json:"proof"`
+}
+
+// ExclusionProof shows that an address is absent from a tree whose leaves
+// are sorted by lowercase address: its neighbors sit at adjacent positions,
+// or the address sorts before the first or after the last leaf. Positions
+// are bound by the order-dependent hashing of the legacy scheme. The root
+// does not fix the leaf count, so LeafCount is only trusted once it matches
+// the address count attested for the root; see VerifyExclusion.
+type ExclusionProof struct {
+	Address   string    `json:"address"`
+	Epoch     int       `json:"epoch"`
+	Scheme    string    `json:"scheme"`
+	LeafCount int       `json:"leaf_count"`
+	Left      *Neighbor `json:"left"`
+	Right     *Neighbor `json:"right"`
+}
+
+var (
+	// ErrInTree is returned when exclusion is requested for a leaf.
+	ErrInTree = errors.New("address is in tree")
+	// ErrNotSorted is returned for trees built from an unsorted list.
+	ErrNotSorted = errors.New("tree leaves are not sorted")
+	// ErrExclusionUnsupported is returned for schemes whose hashing does not
+	// bind leaf positions.
+	ErrExclusionUnsupported = errors.New("scheme does not support exclusion proofs")
+)
+
+// SupportsExclusion reports whether scheme hashes siblings in position
+// order, so that a proof fixes the index of its leaf. keccak256-sorted
+// orders each pair by value and the standard tree hashes positions away, so
+// only sha256-legacy qualifies.
+func SupportsExclusion(scheme string) bool {
+	return scheme == Legacy
+}
+
+// ExclusionProof proves that addr is not a leaf of t.
+func (t *Tree) ExclusionProof(addr string) (*ExclusionProof, error) {
+	if t.array || !SupportsExclusion(t.scheme) {
+		return nil, ErrExclusionUnsupported
+	}
+	if t.Contains(addr) {
+		return nil, ErrInTree
+	}
+	leaves := t.Addresses()
+	if len(leaves) != t.Len() || !sort.SliceIsSorted(leaves, func(i, j int) bool { return leaves[i] < leaves[j] }) {
+		return nil, ErrNotSorted
+	}
+	p := &ExclusionProof{Address: strings.ToLower(addr), Epoch: t.epoch, Scheme: t.scheme, LeafCount: len(leaves)}
+	k := sort.SearchStrings(leaves, p.Address)
+	neighbor := func(i int) (*Neighbor, error) {
+		proof, err := t.Proof(leaves[i])
+		if err != nil {
+			return nil, err
+		}
+		return &Neighbor{Address: leaves[i], Index: i, Proof: proof}, nil
+	}
+	var err error
+	if k > 0 {
+		if p.Left, err = neighbor(k - 1); err != nil {
+			return nil, err
+		}
+	}
+	if k < len(leaves) {
+		if p.Right, err = neighbor(k); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// VerifyExclusion checks p against root and leafCount, the number of
+// addresses attested for root. A proof claiming another leaf count is
+// rejected, since neighbors adjacent in a shorter or longer tree prove
+// nothing about the real one.
+func VerifyExclusion(p *ExclusionProof, root string, leafCount int) bool {
+	if p == nil || !SupportsExclusion(p.Scheme) || p.LeafCount != leafCount {
+		return false
+	}
+	s, err := Get(p.Scheme)
+	if err != nil {
+		return false
+	}
+	addr := strings.ToLower(p.Address)
+	n := p.LeafCount
+	if n == 0 {
+		return root == "" && p.Left == nil && p.Right == nil
+	}
+	check := func(nb *Neighbor) bool {
+		return nb.Index >= 0 && nb.Index < n &&
+			shapeMatches(nb.Proof, nb.Index, n) &&
+			s.Verify(nb.Address, p.Epoch, nb.Proof, root)
+	}
+	switch {
+	case p.Left != nil && p.Right != nil:
+		return strings.ToLower(p.Left.Address) < addr && addr < strings.ToLower(p.Right.Address) &&
+			p.Right.Index == p.Left.Index+1 && check(p.Left) && check(p.Right)
+	case p.Left != nil:
+		return strings.ToLower(p.Left.Address) < addr && p.Left.Index == n-1 && check(p.Left)
+	case p.Right != nil:
+		return addr < strings.ToLower(p.Right.Address) && p.Right.Index == 0 && check(p.Right)
+	}
+	return false
+}
+
+// shapeMatches reports whether proof has the sibling sides of leaf index in
+// a layered tree of n leaves, where an odd last node is promoted unpaired.
+func shapeMatches(proof []ProofStep, index, n int) bool {
+	step := 0
+	for m := n; m > 1; m = (m + 1) / 2 {
+		if sib := index ^ 1; sib < m {
+			if step == len(proof) || proof[step].Left != (sib < index) {
+				return false
+			}
+			step++
+		}
+		index /= 2
+	}
+	return step == len(proof)
+}
@@ -0,0 +1,69 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExclusionProofs(t *testing.T) {
+	s, _ := Get(Legacy)
+	for n := 1; n <= 9; n++ {
+		// even addresses are on the list, odd ones are probed
+		var addrs []string
+		for i := 0; i < n; i++ {
+			addrs = append(addrs, fmt.Sprintf("0x%040x", 2*i+2))
+		}
+		tree, err := Build(s, addrs, 0)
+		if err != nil {
+			t.Fatalf("build: %v", err)
+		}
+		for i := 0; i <= n; i++ {
+			probe := fmt.Sprintf("0x%040x", 2*i+1)
+			p, err := tree.ExclusionProof(probe)
+			if err != nil {
+				t.Fatalf("exclusion %s: %v", probe, err)
+			}
+			if !VerifyExclusion(p, tree.Root(), tree.Len()) {
+				t.Fatalf("exclusion of %s in %d leaves does not verify", probe, n)
+			}
+		}
+		if p, _ := tree.ExclusionProof(fmt.Sprintf("0x%040x", 1)); VerifyExclusion(p, tree.Root(), n+1) {
+			t.Fatalf("exclusion verifies against a wrong leaf count")
+		}
+		if _, err := tree.ExclusionProof(addrs[0]); !errors.Is(err, ErrInTree) {
+			t.Fatalf("expected ErrInTree, got %v", err)
+		}
+	}
+}
+
+func TestExclusionProofRejectsGaps(t *testing.T) {
+	addrs := []string{
+		fmt.Sprintf("0x%040x", 2),
+		fmt.Sprintf("0x%040x", 4),
+		fmt.Sprintf("0x%040x", 6),
+	}
+	tree, _ := Build(schemes[Legacy], addrs, 0)
+	p, _ := tree.ExclusionProof(fmt.Sprintf("0x%040x", 3))
+	// claim 0x..3 is excluded between the first and the last leaf
+	far, _ := tree.Proof(addrs[2])
+	p.Right = &Neighbor{Address: addrs[2], Index: 1, Proof: far}
+	if VerifyExclusion(p, tree.Root(), tree.Len()) {
+		t.Fatalf("non-adjacent neighbors should not verify")
+	}
+	p.Right.Index = 2
+	if VerifyExclusion(p, tree.Root(), tree.Len()) {
+		t.Fatalf("neighbors with a gap should not verify")
+	}
+}
+
+func TestExclusionProofRequirements(t *testing.T) {
+	unsorted, _ := Build(schemes[Legacy], []string{fmt.Sprintf("0x%040x", 4), fmt.Sprintf("0x%040x", 2)}, 0)
+	if _, err := unsorted.ExclusionProof(fmt.Sprintf("0x%040x", 3)); !errors.Is(err, ErrNotSorted) {
+		t.Fatalf("expected ErrNotSorted, got %v", err)
+	}
+	keccak, _ := Build(schemes[KeccakSorted], testAddrs(2), 0)
+	if _, err := keccak.ExclusionProof(fmt.Sprintf("0x%040x", 9)); !errors.Is(err, ErrExclusionUnsupported) {
+		t.Fatalf("expected ErrExclusionUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Package merkle builds whitelist Merkle trees under interchangeable hashing
+// schemes. The legacy scheme reproduces the original SHA-256 tree; the keccak
+// schemes produce roots and proofs accepted by OpenZeppelin's MerkleProof.
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Scheme names.
+const (
+	Legacy       = "sha256-legacy"
+	KeccakSorted = "keccak256-sorted"
+	Standard     = "oz-standard"
+)
+
+// ProofStep is one sibling hash on the path from a leaf to the root. Left
+// reports whether the sibling sits on the left; sorted-pair schemes ignore it.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// Scheme builds roots and inclusion proofs for an address list. The epoch is
+// only hashed by schemes whose leaves encode it.
+type Scheme interface {
+	Name() string
+	Root(addrs []string, epoch int) (string, error)
+	Proof(addrs []string, epoch int, addr string) ([]ProofStep, error)
+	Verify(addr string, epoch int, proof []ProofStep, root string) bool
+}
+
+// ErrNotInTree is returned when a proof is requested for an absent address.
+var ErrNotInTree = fmt.Errorf("address not in tree")
+
+var schemes = map[string]Scheme{
+	Legacy:       legacyScheme{},
+	KeccakSorted: keccakSortedScheme{},
+	Standard:     standardScheme{},
+}
+
+// Get returns the scheme registered under name.
+func Get(name string) (Scheme, error) {
+	s, ok := schemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown merkle scheme %q", name)
+	}
+	return s, nil
+}
+
+// MustGet is like Get but panics on an unknown name.
+func MustGet(name string) Scheme {
+	s, err := Get(name)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Names lists the available schemes in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(schemes))
+	for n := range schemes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addressBytes parses a 0x-prefixed hex address into its 20 bytes.
+func addressBytes(addr string) ([]byte, error) {
+	if !common.IsHexAddress(addr) {
+		return nil, fmt.Errorf("invalid address %q", addr)
+	}
+	return common.HexToAddress(addr).Bytes(), nil
+}
+
+func decodeHash(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+// layeredTree hashes leaves pairwise level by level, promoting an odd last
+// node unchanged to the next level.
+type layeredTree struct {
+	leaf func(addr string) ([]byte, error)
+	pair func(a, b []byte) []byte
+}
+
+func (t layeredTree) leaves(addrs []string) ([][]byte, error) {
+	hashes := make([][]byte, 0, len(addrs))
+	for _, a := range addrs {
+		h, err := t.leaf(a)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// build hashes the leaves and every level above them.
+func (t layeredTree) build(scheme string, addrs []string, epoch int) (*Tree, error) {
+	hashes, err := t.leaves(addrs)
+	if err != nil {
+		return nil, err
+	}
+	layers := [][][]byte{hashes}
+	for len(hashes) > 1 {
+		var next [][]byte
+		for i := 0; i < len(hashes); i += 2 {
+			if i+1 == len(hashes) {
+				next = append(next, hashes[i])
+			} else {
+				next = append(next, t.pair(hashes[i], hashes[i+1]))
+			}
+		}
+		layers = append(layers, next)
+		hashes = next
+	}
+	return &Tree{scheme: scheme, epoch: epoch, layers: layers, index: indexAddrs(addrs)}, nil
+}
+
+// treeRoot and treeProof implement Scheme.Root and Scheme.Proof on top of a
+// freshly built tree.
+func treeRoot(s Scheme, addrs []string, epoch int) (string, error) {
+	t, err := Build(s, addrs, epoch)
+	if err != nil {
+		return "", err
+	}
+	return t.Root(), nil
+}
+
+func treeProof(s Scheme, addrs []string, epoch int, addr string) ([]ProofStep, error) {
+	t, err := Build(s, addrs, epoch)
+	if err != nil {
+		return nil, err
+	}
+	return t.Proof(addr)
+}
+
+func (t layeredTree) verify(addr string, proof []ProofStep, root []byte) bool {
+	cur, err := t.leaf(addr)
+	if err != nil {
+		return false
+	}
+	for _, step := range proof {
+		sib, err := decodeHash(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			cur = t.pair(sib, cur)
+		} else {
+			cur = t.pair(cur, sib)
+		}
+	}
+	return string(cur) == string(root)
+}
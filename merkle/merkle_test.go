@@ -0,0 +1,96 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("0x%040x", i+1)
+	}
+	return addrs
+}
+
+func TestLegacyRootUnchanged(t *testing.T) {
+	root, err := schemes[Legacy].Root(testAddrs(3), 0)
+	if err != nil {
+		t.Fatalf("root: %v", err)
+	}
+	want := "839d9a6ca43af7a125e9ece32839c12217469d40453b82e8a46b91da964f1e03"
+	if root != want {
+		t.Fatalf("expected %s, got %s", want, root)
+	}
+}
+
+func TestProofsVerifyForAllSchemes(t *testing.T) {
+	for _, name := range Names() {
+		s, _ := Get(name)
+		for n := 1; n <= 9; n++ {
+			addrs := testAddrs(n)
+			root, err := s.Root(addrs, 42)
+			if err != nil {
+				t.Fatalf("%s root: %v", name, err)
+			}
+			for _, a := range addrs {
+				proof, err := s.Proof(addrs, 42, a)
+				if err != nil {
+					t.Fatalf("%s proof %s: %v", name, a, err)
+				}
+				if !s.Verify(a, 42, proof, root) {
+					t.Fatalf("%s: proof for %s of %d leaves does not verify", name, a, n)
+				}
+			}
+			if _, err := s.Proof(addrs, 42, "0x00000000000000000000000000000000000000ff"); err != ErrNotInTree {
+				t.Fatalf("%s: expected ErrNotInTree, got %v", name, err)
+			}
+		}
+	}
+}
+
+func TestStandardEpochIsHashed(t *testing.T) {
+	s, _ := Get(Standard)
+	a, _ := s.Root(testAddrs(4), 1)
+	b, _ := s.Root(testAddrs(4), 2)
+	if a == b {
+		t.Fatalf("roots of different epochs should differ")
+	}
+}
+
+// Root from the @openzeppelin/merkle-tree README example.
+func TestStandardTreeMatchesOpenZeppelin(t *testing.T) {
+	values := []struct {
+		addr  string
+		value string
+	}{
+		{"0x1111111111111111111111111111111111111111", "5000000000000000000"},
+		{"0x2222222222222222222222222222222222222222", "2500000000000000000"},
+	}
+	var leaves [][]byte
+	for _, v := range values {
+		n, _ := new(big.Int).SetString(v.value, 10)
+		leaves = append(leaves, standardLeaf(common.HexToAddress(v.addr).Bytes(), n))
+	}
+	sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i], leaves[j]) < 0 })
+	root := hex.EncodeToString(buildStandardTree(leaves)[0])
+	if root != "d4dee0beab2d53f2cc83e567171bd2820e49898130a22622b10ead383e90bd77" {
+		t.Fatalf("unexpected root %s", root)
+	}
+}
+
+func TestKeccakRejectsInvalidAddress(t *testing.T) {
+	s, _ := Get(KeccakSorted)
+	if _, err := s.Root([]string{"0xabc"}, 0); err == nil {
+		t.Fatalf("expected error for short address")
+	}
+	if _, err := Get("nope"); err == nil {
+		t.Fatalf("expected unknown scheme error")
+	}
+}
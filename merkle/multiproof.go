@@ -0,0 +1,161 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MultiProof proves several leaves of an oz-standard tree at once, sharing
+// the siblings they have in common. Leaves, Proof and ProofFlags are the
+// arguments of OpenZeppelin's MerkleProof.multiProofVerify; Addresses names
+// the account behind each leaf in the same order.
+type MultiProof struct {
+	Epoch      int      `json:"epoch"`
+	Addresses  []string `json:"addresses"`
+	Leaves     []string `json:"leaves"`
+	Proof      []string `json:"proof"`
+	ProofFlags []bool   `json:"proof_flags"`
+}
+
+// ErrMultiProofUnsupported is returned for schemes whose trees cannot be
+// verified by multiProofVerify.
+var ErrMultiProofUnsupported = errors.New("scheme does not support multiproofs")
+
+// MultiProof builds the multiproof of addrs the way getMultiProof of
+// @openzeppelin/merkle-tree does. Duplicates are proven once.
+func (t *Tree) MultiProof(addrs []string) (*MultiProof, error) {
+	if !t.array {
+		return nil, fmt.Errorf("%w: %s", ErrMultiProofUnsupported, t.scheme)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to prove")
+	}
+	byIndex := make(map[int]string, len(addrs))
+	indices := make([]int, 0, len(addrs))
+	for _, a := range addrs {
+		key := strings.ToLower(a)
+		i, ok := t.index[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", a, ErrNotInTree)
+		}
+		if _, dup := byIndex[i]; !dup {
+			byIndex[i] = key
+			indices = append(indices, i)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(indices)))
+
+	nodes := t.layers[0]
+	mp := &MultiProof{Epoch: t.epoch, Proof: []string{}, ProofFlags: []bool{}}
+	for _, i := range indices {
+		mp.Addresses = append(mp.Addresses, byIndex[i])
+		mp.Leaves = append(mp.Leaves, t.encode(nodes[i]))
+	}
+	// walk up from the deepest nodes; a sibling that is itself on the
+	// queue is computed by the verifier and flagged instead of sent
+	queue := append([]int(nil), indices...)
+	for len(queue) > 0 && queue[0] > 0 {
+		j := queue[0]
+		queue = queue[1:]
+		sib := j + 1
+		if j%2 == 0 {
+			sib = j - 1
+		}
+		if len(queue) > 0 && queue[0] == sib {
+			mp.ProofFlags = append(mp.ProofFlags, true)
+			queue = queue[1:]
+		} else {
+			mp.ProofFlags = append(mp.ProofFlags, false)
+			mp.Proof = append(mp.Proof, t.encode(nodes[sib]))
+		}
+		queue = append(queue, (j-1)/2)
+	}
+	return mp, nil
+}
+
+// VerifyMultiProof checks mp against an oz-standard root, replaying
+// MerkleProof.processMultiProof. The leaves must match the addresses.
+func VerifyMultiProof(mp *MultiProof, root string) bool {
+	if mp == nil || len(mp.Leaves) != len(mp.Addresses) {
+		return false
+	}
+	leaves := make([][]byte, len(mp.Leaves))
+	for i, l := range mp.Leaves {
+		b, err := decodeHash(l)
+		if err != nil {
+			return false
+		}
+		want, err := StandardLeaf(mp.Addresses[i], mp.Epoch)
+		if err != nil || !bytes.Equal(b, want) {
+			return false
+		}
+		leaves[i] = b
+	}
+	proof := make([][]byte, len(mp.Proof))
+	for i, p := range mp.Proof {
+		b, err := decodeHash(p)
+		if err != nil {
+			return false
+		}
+		proof[i] = b
+	}
+	want, err := decodeHash(root)
+	if err != nil {
+		return false
+	}
+	got, ok := processMultiProof(leaves, proof, mp.ProofFlags)
+	return ok && bytes.Equal(got, want)
+}
+
+func processMultiProof(leaves, proof [][]byte, flags []bool) ([]byte, bool) {
+	total := len(flags)
+	if len(leaves)+len(proof) != total+1 {
+		return nil, false
+	}
+	hashes := make([][]byte, 0, total)
+	leafPos, hashPos, proofPos := 0, 0, 0
+	next := func() ([]byte, bool) {
+		if leafPos < len(leaves) {
+			leafPos++
+			return leaves[leafPos-1], true
+		}
+		if hashPos < len(hashes) {
+			hashPos++
+			return hashes[hashPos-1], true
+		}
+		return nil, false
+	}
+	for _, flag := range flags {
+		a, ok := next()
+		if !ok {
+			return nil, false
+		}
+		var b []byte
+		if flag {
+			if b, ok = next(); !ok {
+				return nil, false
+			}
+		} else {
+			if proofPos == len(proof) {
+				return nil, false
+			}
+			b = proof[proofPos]
+			proofPos++
+		}
+		hashes = append(hashes, hashSortedPair(a, b))
+	}
+	switch {
+	case total > 0:
+		if proofPos != len(proof) {
+			return nil, false
+		}
+		return hashes[total-1], true
+	case len(leaves) > 0:
+		return leaves[0], true
+	default:
+		return proof[0], true
+	}
+}
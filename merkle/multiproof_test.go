@@ -0,0 +1,63 @@
+package merkle
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMultiProofVerifies(t *testing.T) {
+	s, _ := Get(Standard)
+	for n := 1; n <= 9; n++ {
+		addrs := testAddrs(n)
+		tree, err := Build(s, addrs, 3)
+		if err != nil {
+			t.Fatalf("build: %v", err)
+		}
+		// every contiguous subset exercises shared and unshared siblings
+		for i := 0; i < n; i++ {
+			for j := i + 1; j <= n; j++ {
+				mp, err := tree.MultiProof(addrs[i:j])
+				if err != nil {
+					t.Fatalf("multiproof: %v", err)
+				}
+				if !VerifyMultiProof(mp, tree.Root()) {
+					t.Fatalf("multiproof of %v in %d leaves does not verify", addrs[i:j], n)
+				}
+			}
+		}
+	}
+}
+
+func TestMultiProofSharesSiblings(t *testing.T) {
+	addrs := testAddrs(8)
+	tree, _ := Build(schemes[Standard], addrs, 3)
+	mp, err := tree.MultiProof(addrs)
+	if err != nil {
+		t.Fatalf("multiproof: %v", err)
+	}
+	if len(mp.Proof) != 0 || len(mp.ProofFlags) != 7 {
+		t.Fatalf("full multiproof should need no siblings, got %d proof %d flags", len(mp.Proof), len(mp.ProofFlags))
+	}
+}
+
+func TestMultiProofRejects(t *testing.T) {
+	addrs := testAddrs(4)
+	tree, _ := Build(schemes[Standard], addrs, 3)
+	mp, _ := tree.MultiProof(addrs[:2])
+	mp.Addresses[0], mp.Addresses[1] = mp.Addresses[1], mp.Addresses[0]
+	if VerifyMultiProof(mp, tree.Root()) {
+		t.Fatalf("leaves not matching their addresses should not verify")
+	}
+	mp, _ = tree.MultiProof(addrs[:2])
+	mp.ProofFlags = mp.ProofFlags[1:]
+	if VerifyMultiProof(mp, tree.Root()) {
+		t.Fatalf("truncated flags should not verify")
+	}
+	if _, err := tree.MultiProof([]string{"0x00000000000000000000000000000000000000ff"}); !errors.Is(err, ErrNotInTree) {
+		t.Fatalf("expected ErrNotInTree, got %v", err)
+	}
+	legacy, _ := Build(schemes[Legacy], addrs, 3)
+	if _, err := legacy.MultiProof(addrs); !errors.Is(err, ErrMultiProofUnsupported) {
+		t.Fatalf("expected ErrMultiProofUnsupported, got %v", err)
+	}
+}
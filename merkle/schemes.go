@@ -0,0 +1,91 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// legacyScheme hashes the lowercase address string with SHA-256 and
+// concatenates siblings in position order. Roots are plain hex.
+type legacyScheme struct{}
+
+var legacyTree = layeredTree{
+	leaf: func(addr string) ([]byte, error) {
+		h := sha256.Sum256([]byte(strings.ToLower(addr)))
+		return h[:], nil
+	},
+	pair: func(a, b []byte) []byte {
+		h := sha256.Sum256(append(append([]byte(nil), a...), b...))
+		return h[:]
+	},
+}
+
+func (legacyScheme) Name() string { return Legacy }
+
+func (legacyScheme) build(addrs []string, epoch int) (*Tree, error) {
+	return legacyTree.build(Legacy, addrs, epoch)
+}
+
+func (s legacyScheme) Root(addrs []string, epoch int) (string, error) {
+	return treeRoot(s, addrs, epoch)
+}
+
+func (s legacyScheme) Proof(addrs []string, epoch int, addr string) ([]ProofStep, error) {
+	return treeProof(s, addrs, epoch, addr)
+}
+
+func (legacyScheme) Verify(addr string, epoch int, proof []ProofStep, root string) bool {
+	want, err := decodeHash(root)
+	return err == nil && legacyTree.verify(addr, proof, want)
+}
+
+// hashSortedPair is OpenZeppelin's commutative keccak256 node hash.
+func hashSortedPair(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256(a, b)
+}
+
+func hexPrefixed(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+// keccakSortedScheme hashes the 20-byte address with keccak256 and combines
+// siblings in sorted order, as verified by MerkleProof.verify with a leaf of
+// keccak256(abi.encodePacked(account)). Roots are 0x-prefixed hex.
+type keccakSortedScheme struct{}
+
+var keccakTree = layeredTree{
+	leaf: func(addr string) ([]byte, error) {
+		b, err := addressBytes(addr)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(b), nil
+	},
+	pair: hashSortedPair,
+}
+
+func (keccakSortedScheme) Name() string { return KeccakSorted }
+
+func (keccakSortedScheme) build(addrs []string, epoch int) (*Tree, error) {
+	return keccakTree.build(KeccakSorted, addrs, epoch)
+}
+
+func (s keccakSortedScheme) Root(addrs []string, epoch int) (string, error) {
+	return treeRoot(s, addrs, epoch)
+}
+
+func (s keccakSortedScheme) Proof(addrs []string, epoch int, addr string) ([]ProofStep, error) {
+	return treeProof(s, addrs, epoch, addr)
+}
+
+func (keccakSortedScheme) Verify(addr string, epoch int, proof []ProofStep, root string) bool {
+	want, err := decodeHash(root)
+	return err == nil && keccakTree.verify(addr, proof, want)
+}
@@ -0,0 +1,104 @@
+package merkle
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// standardScheme mirrors OpenZeppelin's StandardMerkleTree for the leaf
+// encoding ["address", "uint256"] with values (account, epoch):
+//
+//	leaf = keccak256(bytes.concat(keccak256(abi.encode(account, epoch))))
+//
+// Leaves are sorted by hash and laid out as a complete binary tree in an
+// array, so roots match StandardMerkleTree.of(values, ["address","uint256"]).
+type standardScheme struct{}
+
+func (standardScheme) Name() string { return Standard }
+
+// StandardLeaf returns the double-hashed leaf of (addr, epoch).
+func StandardLeaf(addr string, epoch int) ([]byte, error) {
+	b, err := addressBytes(addr)
+	if err != nil {
+		return nil, err
+	}
+	return standardLeaf(b, big.NewInt(int64(epoch))), nil
+}
+
+func standardLeaf(addr []byte, value *big.Int) []byte {
+	enc := append(common.LeftPadBytes(addr, 32), common.LeftPadBytes(value.Bytes(), 32)...)
+	return crypto.Keccak256(crypto.Keccak256(enc))
+}
+
+// buildStandardTree lays sorted leaves out at the end of the array in
+// reverse order and fills the parents, as @openzeppelin/merkle-tree does.
+func buildStandardTree(leaves [][]byte) [][]byte {
+	tree := make([][]byte, 2*len(leaves)-1)
+	for i, l := range leaves {
+		tree[len(tree)-1-i] = l
+	}
+	for i := len(tree) - 1 - len(leaves); i >= 0; i-- {
+		tree[i] = hashSortedPair(tree[2*i+1], tree[2*i+2])
+	}
+	return tree
+}
+
+func (standardScheme) build(addrs []string, epoch int) (*Tree, error) {
+	type entry struct {
+		addr string
+		hash []byte
+	}
+	leaves := make([]entry, 0, len(addrs))
+	for _, a := range addrs {
+		h, err := StandardLeaf(a, epoch)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, entry{strings.ToLower(a), h})
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return bytes.Compare(leaves[i].hash, leaves[j].hash) < 0 })
+
+	t := &Tree{scheme: Standard, epoch: epoch, array: true, index: make(map[string]int, len(leaves))}
+	if len(leaves) == 0 {
+		t.layers = [][][]byte{nil}
+		return t, nil
+	}
+	hashes := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = l.hash
+		if _, ok := t.index[l.addr]; !ok {
+			t.index[l.addr] = 2*len(leaves) - 2 - i
+		}
+	}
+	t.layers = [][][]byte{buildStandardTree(hashes)}
+	return t, nil
+}
+
+func (s standardScheme) Root(addrs []string, epoch int) (string, error) {
+	return treeRoot(s, addrs, epoch)
+}
+
+func (s standardScheme) Proof(addrs []string, epoch int, addr string) ([]ProofStep, error) {
+	return treeProof(s, addrs, epoch, addr)
+}
+
+func (standardScheme) Verify(addr string, epoch int, proof []ProofStep, root string) bool {
+	cur, err := StandardLeaf(addr, epoch)
+	if err != nil {
+		return false
+	}
+	for _, step := range proof {
+		sib, err := decodeHash(step.Hash)
+		if err != nil {
+			return false
+		}
+		cur = hashSortedPair(cur, sib)
+	}
+	want, err := decodeHash(root)
+	return err == nil && bytes.Equal(cur, want)
+}
@@ -0,0 +1,285 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Tree is a fully materialized Merkle tree. Building it hashes every leaf
+// once; proofs are then read from the stored nodes in O(log n).
+type Tree struct {
+	scheme string
+	epoch  int
+	// array is set for trees laid out as a single array (oz-standard);
+	// otherwise layers[0] holds the leaves and the last layer the root.
+	array  bool
+	layers [][][]byte
+	index  map[string]int
+}
+
+// treeBuilder is implemented by every scheme.
+type treeBuilder interface {
+	build(addrs []string, epoch int) (*Tree, error)
+}
+
+// Build materializes the tree of addrs under scheme s.
+func Build(s Scheme, addrs []string, epoch int) (*Tree, error) {
+	b, ok := s.(treeBuilder)
+	if !ok {
+		return nil, fmt.Errorf("merkle scheme %q cannot build trees", s.Name())
+	}
+	return b.build(addrs, epoch)
+}
+
+// indexAddrs maps lowercase addresses to their position; the first
+// occurrence of a duplicate wins.
+func indexAddrs(addrs []string) map[string]int {
+	index := make(map[string]int, len(addrs))
+	for i, a := range addrs {
+		key := strings.ToLower(a)
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// Scheme returns the name of the scheme the tree was built with.
+func (t *Tree) Scheme() string { return t.scheme }
+
+// Epoch returns the epoch the tree was built for.
+func (t *Tree) Epoch() int { return t.epoch }
+
+// Len returns the number of leaves.
+func (t *Tree) Len() int {
+	if t.array {
+		if len(t.layers[0]) == 0 {
+			return 0
+		}
+		return (len(t.layers[0]) + 1) / 2
+	}
+	return len(t.layers[0])
+}
+
+// Contains reports whether addr is a leaf of the tree.
+func (t *Tree) Contains(addr string) bool {
+	_, ok := t.index[strings.ToLower(addr)]
+	return ok
+}
+
+// Addresses returns the lowercase leaf addresses in tree order, without
+// duplicates.
+func (t *Tree) Addresses() []string {
+	addrs := make([]string, 0, len(t.index))
+	for a := range t.index {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		pi, pj := t.index[addrs[i]], t.index[addrs[j]]
+		if t.array {
+			// array trees store the first sorted leaf at the highest index
+			return pi > pj
+		}
+		return pi < pj
+	})
+	return addrs
+}
+
+func (t *Tree) encode(b []byte) string {
+	if t.scheme == Legacy {
+		return hex.EncodeToString(b)
+	}
+	return hexPrefixed(b)
+}
+
+// Root returns the encoded root, or "" for an empty tree.
+func (t *Tree) Root() string {
+	if t.Len() == 0 {
+		return ""
+	}
+	if t.array {
+		return t.encode(t.layers[0][0])
+	}
+	return t.encode(t.layers[len(t.layers)-1][0])
+}
+
+// Proof returns the inclusion proof of addr.
+func (t *Tree) Proof(addr string) ([]ProofStep, error) {
+	i, ok := t.index[strings.ToLower(addr)]
+	if !ok {
+		return nil, ErrNotInTree
+	}
+	var proof []ProofStep
+	if t.array {
+		nodes := t.layers[0]
+		for i > 0 {
+			// odd indexes are left children, so their sibling is on the right
+			if i%2 == 1 {
+				proof = append(proof, ProofStep{Hash: t.encode(nodes[i+1]), Left: false})
+			} else {
+				proof = append(proof, ProofStep{Hash: t.encode(nodes[i-1]), Left: true})
+			}
+			i = (i - 1) / 2
+		}
+		return proof, nil
+	}
+	for _, layer := range t.layers[:len(t.layers)-1] {
+		sib := i ^ 1
+		// an odd last node is promoted without a sibling
+		if sib < len(layer) {
+			proof = append(proof, ProofStep{Hash: t.encode(layer[sib]), Left: sib < i})
+		}
+		i /= 2
+	}
+	return proof, nil
+}
+
+const treeMagic = "IMT1"
+
+// MarshalBinary encodes the tree as the magic "IMT1", the scheme name, the
+// epoch, the leaf addresses with their positions and every stored node.
+// Node hashes are 32 bytes.
+func (t *Tree) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(treeMagic)
+	writeString(&buf, t.scheme)
+	writeUvarint(&buf, uint64(t.epoch))
+
+	// leaves are written with their position so duplicates and array
+	// indexes round-trip
+	addrs := make([]string, 0, len(t.index))
+	for a := range t.index {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return t.index[addrs[i]] < t.index[addrs[j]] })
+	writeUvarint(&buf, uint64(len(addrs)))
+	for _, a := range addrs {
+		writeString(&buf, a)
+		writeUvarint(&buf, uint64(t.index[a]))
+	}
+
+	if t.array {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	writeUvarint(&buf, uint64(len(t.layers)))
+	for _, layer := range t.layers {
+		writeUvarint(&buf, uint64(len(layer)))
+		for _, n := range layer {
+			if len(n) != 32 {
+				return nil, fmt.Errorf("node of %d bytes", len(n))
+			}
+			buf.Write(n)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a tree written by MarshalBinary.
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(treeMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != treeMagic {
+		return errors.New("merkle: not an encoded tree")
+	}
+	scheme, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if _, err := Get(scheme); err != nil {
+		return err
+	}
+	epoch, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if n > uint64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+	index := make(map[string]int, n)
+	for i := uint64(0); i < n; i++ {
+		a, err := readString(r)
+		if err != nil {
+			return err
+		}
+		pos, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		index[a] = int(pos)
+	}
+
+	array, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	nl, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if nl == 0 || nl > uint64(r.Len())+1 {
+		return io.ErrUnexpectedEOF
+	}
+	layers := make([][][]byte, nl)
+	for l := range layers {
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if count*32 > uint64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		layers[l] = make([][]byte, count)
+		for i := range layers[l] {
+			node := make([]byte, 32)
+			if _, err := io.ReadFull(r, node); err != nil {
+				return err
+			}
+			layers[l][i] = node
+		}
+	}
+	size := len(layers[0])
+	for _, pos := range index {
+		if pos >= size {
+			return fmt.Errorf("merkle: leaf position %d out of range", pos)
+		}
+	}
+	*t = Tree{scheme: scheme, epoch: int(epoch), array: array == 1, layers: layers, index: index}
+	return nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
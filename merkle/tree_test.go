@@ -0,0 +1,54 @@
+package merkle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeBinaryRoundTrip(t *testing.T) {
+	for _, name := range Names() {
+		s, _ := Get(name)
+		for _, n := range []int{0, 1, 5, 8} {
+			addrs := testAddrs(n)
+			tree, err := Build(s, addrs, 7)
+			if err != nil {
+				t.Fatalf("%s build: %v", name, err)
+			}
+			data, err := tree.MarshalBinary()
+			if err != nil {
+				t.Fatalf("%s marshal: %v", name, err)
+			}
+			var got Tree
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("%s unmarshal: %v", name, err)
+			}
+			if got.Scheme() != name || got.Epoch() != 7 || got.Len() != n || got.Root() != tree.Root() {
+				t.Fatalf("%s: decoded tree differs: %s %d %d %s", name, got.Scheme(), got.Epoch(), got.Len(), got.Root())
+			}
+			if len(got.Addresses()) != n || !reflect.DeepEqual(got.Addresses(), tree.Addresses()) {
+				t.Fatalf("%s: addresses differ after round trip", name)
+			}
+			for _, a := range addrs {
+				want, _ := tree.Proof(a)
+				proof, err := got.Proof(a)
+				if err != nil || !reflect.DeepEqual(proof, want) {
+					t.Fatalf("%s: proof for %s differs after round trip", name, a)
+				}
+			}
+		}
+	}
+}
+
+func TestTreeUnmarshalRejectsGarbage(t *testing.T) {
+	var tree Tree
+	for _, data := range [][]byte{nil, []byte("IMT1"), []byte("nope")} {
+		if err := tree.UnmarshalBinary(data); err == nil {
+			t.Fatalf("expected error for %q", data)
+		}
+	}
+	good, _ := Build(schemes[Legacy], testAddrs(3), 1)
+	data, _ := good.MarshalBinary()
+	if err := tree.UnmarshalBinary(data[:len(data)-5]); err == nil {
+		t.Fatalf("expected error for truncated tree")
+	}
+}
@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"idenauthgo/merkle"
+)
 
 func TestComputeMerkleRootEmpty(t *testing.T) {
 	if res := computeMerkleRoot([]string{}); res != "" {
@@ -36,3 +44,115 @@ func TestMerkleProof(t *testing.T) {
 		t.Fatalf("proof verification failed")
 	}
 }
+
+func TestMerkleRootTableMigration(t *testing.T) {
+	var err error
+	db, err = sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE epoch_merkle_roots (epoch INTEGER PRIMARY KEY, merkle_root TEXT, ts INTEGER)`); err != nil {
+		t.Fatalf("create old table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO epoch_merkle_roots VALUES (5, 'abc', 1)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	createMerkleRootTable()
+
+	if root, ok := getMerkleRoot(5, merkle.Legacy); !ok || root != "abc" {
+		t.Fatalf("legacy root lost: %q %v", root, ok)
+	}
+	saveMerkleRoot(5, merkle.Standard, "0xdef")
+	if root, ok := getMerkleRoot(5, merkle.Standard); !ok || root != "0xdef" {
+		t.Fatalf("second scheme not stored: %q %v", root, ok)
+	}
+}
+
+func TestMerkleProofHandlerScheme(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	addrs := []string{
+		"0x0000000000000000000000000000000000000001",
+		"0x0000000000000000000000000000000000000002",
+		"0x0000000000000000000000000000000000000003",
+	}
+	wlMu.Lock()
+	savedEpoch, savedList := currentEpoch, currentWhitelist
+	currentEpoch, currentWhitelist = 42, addrs
+	wlMu.Unlock()
+	defer func() {
+		wlMu.Lock()
+		currentEpoch, currentWhitelist = savedEpoch, savedList
+		wlMu.Unlock()
+	}()
+
+	rr := httptest.NewRecorder()
+	merkleProofHandler(rr, httptest.NewRequest("GET", "/merkle_proof?address="+addrs[2]+"&scheme=oz-standard", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Root   string             `json:"merkle_root"`
+		Proof  []merkle.ProofStep `json:"proof"`
+		Epoch  int                `json:"epoch"`
+		Scheme string             `json:"scheme"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.Scheme != merkle.Standard || out.Epoch != 42 {
+		t.Fatalf("unexpected response %+v", out)
+	}
+	if !merkle.MustGet(merkle.Standard).Verify(addrs[2], 42, out.Proof, out.Root) {
+		t.Fatalf("proof does not verify against %s", out.Root)
+	}
+
+	rr = httptest.NewRecorder()
+	merkleProofHandler(rr, httptest.NewRequest("GET", "/merkle_proof?address="+addrs[0]+"&scheme=md5", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown scheme, got %d", rr.Code)
+	}
+}
+
+func TestWhitelistRootReusesStoredTree(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	addrs := []string{
+		"0x0000000000000000000000000000000000000002",
+		"0x0000000000000000000000000000000000000001",
+	}
+	root, scheme, err := whitelistRoot(50, addrs)
+	if err != nil {
+		t.Fatalf("root: %v", err)
+	}
+	other, _ := merkle.Build(merkle.MustGet(merkle.Standard), addrs, 50)
+	if err := storeMerkleTree(other); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE epoch_merkle_roots SET ts=1 WHERE epoch=50 AND scheme=?`, scheme); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+
+	// re-exporting the same list must not touch the stored trees or roots
+	if again, _, err := whitelistRoot(50, addrs); err != nil || again != root {
+		t.Fatalf("re-export: %q %v", again, err)
+	}
+	var ts int64
+	db.QueryRow(`SELECT ts FROM epoch_merkle_roots WHERE epoch=50 AND scheme=?`, scheme).Scan(&ts)
+	if ts != 1 {
+		t.Fatalf("root of an unchanged list was stored again")
+	}
+	if _, ok := loadStoredTree(50, merkle.Standard); !ok {
+		t.Fatalf("tree of another scheme dropped on re-export")
+	}
+
+	// a changed list replaces the tree and drops the other schemes
+	changed, _, err := whitelistRoot(50, addrs[:1])
+	if err != nil || changed == root {
+		t.Fatalf("changed list: %q %v", changed, err)
+	}
+	if _, ok := loadStoredTree(50, merkle.Standard); ok {
+		t.Fatalf("stale tree of another scheme kept")
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"idenauthgo/merkle"
+	"idenauthgo/solidity"
+)
+
+var merkleBatchMax = getenvInt("MERKLE_BATCH_MAX", 10000)
+
+// addressProof is one entry of a batch response or proof bundle. Addresses
+// that are not on the whitelist carry found=false and a null proof.
+type addressProof struct {
+	Address string             `json:"address"`
+	Found   bool               `json:"found"`
+	Proof   []merkle.ProofStep `json:"proof"`
+}
+
+// merkleProofsHandler answers POST /merkle_proofs with a proof for every
+// requested address:
+//
+//	{"addresses": ["0x..."], "epoch": 42, "scheme": "oz-standard"}
+//
+// epoch and scheme are optional and default to the current epoch and the
+// configured scheme.
+func merkleProofsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Addresses []string `json:"addresses"`
+		Epoch     *int     `json:"epoch"`
+		Scheme    string   `json:"scheme"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Addresses) == 0 {
+		http.Error(w, "addresses required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Addresses) > merkleBatchMax {
+		http.Error(w, fmt.Sprintf("at most %d addresses per request", merkleBatchMax), http.StatusRequestEntityTooLarge)
+		return
+	}
+	wlMu.RLock()
+	epoch := currentEpoch
+	wlMu.RUnlock()
+	if req.Epoch != nil {
+		epoch = *req.Epoch
+	}
+	if req.Scheme == "" {
+		req.Scheme = merkleScheme
+	}
+	scheme, err := merkle.Get(req.Scheme)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, ok := epochTree(w, epoch, scheme)
+	if !ok {
+		return
+	}
+	proofs := make([]addressProof, 0, len(req.Addresses))
+	for _, a := range req.Addresses {
+		proofs = append(proofs, proofEntry(t, a))
+	}
+	writeJSON(w, map[string]interface{}{
+		"merkle_root": t.Root(),
+		"epoch":       epoch,
+		"scheme":      scheme.Name(),
+		"proofs":      proofs,
+	})
+}
+
+// merkleBundleHandler streams GET /merkle_proofs/epoch/{n}/bundle: the root,
+// scheme and epoch followed by the proof of every whitelisted address. With
+// ?format=ndjson the first line is the header and each following line one
+// address; otherwise a single JSON document is written.
+func merkleBundleHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/merkle_proofs/epoch/")
+	epochStr, ok := strings.CutSuffix(rest, "/bundle")
+	epoch, err := strconv.Atoi(epochStr)
+	if !ok || err != nil {
+		http.Error(w, "bad epoch", http.StatusBadRequest)
+		return
+	}
+	scheme, err := requestScheme(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, ok := epochTree(w, epoch, scheme)
+	if !ok {
+		return
+	}
+
+	addrs := t.Addresses()
+	header := map[string]interface{}{
+		"merkle_root": t.Root(),
+		"epoch":       epoch,
+		"scheme":      scheme.Name(),
+		"count":       len(addrs),
+	}
+	name := fmt.Sprintf("merkle_proofs_epoch_%d_%s", epoch, scheme.Name())
+	enc := json.NewEncoder(w)
+	if r.URL.Query().Get("format") == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.ndjson"`)
+		if err := enc.Encode(header); err != nil {
+			return
+		}
+		for _, a := range addrs {
+			if err := enc.Encode(proofEntry(t, a)); err != nil {
+				log.Printf("[MERKLE_BUNDLE] epoch %d: %v", epoch, err)
+				return
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	head, _ := json.Marshal(header)
+	// reopen the header object to append the proofs array
+	if _, err := w.Write(append(head[:len(head)-1], `,"proofs":[`...)); err != nil {
+		return
+	}
+	for i, a := range addrs {
+		if i > 0 {
+			w.Write([]byte{','})
+		}
+		b, _ := json.Marshal(proofEntry(t, a))
+		if _, err := w.Write(b); err != nil {
+			log.Printf("[MERKLE_BUNDLE] epoch %d: %v", epoch, err)
+			return
+		}
+	}
+	w.Write([]byte("]}\n"))
+}
+
+// epochTree loads the tree of an epoch, writing the error response if that
+// fails.
+func epochTree(w http.ResponseWriter, epoch int, scheme merkle.Scheme) (*merkle.Tree, bool) {
+	t, err := merkleTree(epoch, scheme)
+	if err == errWhitelistMissing {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[MERKLE] %s tree for epoch %d: %v", scheme.Name(), epoch, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return t, true
+}
+
+func proofEntry(t *merkle.Tree, addr string) addressProof {
+	proof, err := t.Proof(addr)
+	if err != nil {
+		return addressProof{Address: addr}
+	}
+	if proof == nil {
+		// a single-leaf tree has an empty but valid proof
+		proof = []merkle.ProofStep{}
+	}
+	return addressProof{Address: addr, Found: true, Proof: proof}
+}
+
+// computeMerkleMultiProof returns a multiproof of addrs in the oz-standard
+// tree of an epoch together with that tree's root.
+func computeMerkleMultiProof(epoch int, addrs []string) (*merkle.MultiProof, string, error) {
+	t, err := merkleTree(epoch, merkle.MustGet(merkle.Standard))
+	if err != nil {
+		return nil, "", err
+	}
+	mp, err := t.MultiProof(addrs)
+	if err != nil {
+		return nil, "", err
+	}
+	return mp, t.Root(), nil
+}
+
+func verifyMerkleMultiProof(mp *merkle.MultiProof, root string) bool {
+	return merkle.VerifyMultiProof(mp, root)
+}
+
+// merkleMultiProofHandler returns an OpenZeppelin multiProofVerify proof for
+// a set of addresses, given as POST {"addresses": [...], "epoch": 42} or
+// GET ?addresses=0x..,0x..&epoch=42. Multiproofs are only defined for the
+// oz-standard scheme.
+func merkleMultiProofHandler(w http.ResponseWriter, r *http.Request) {
+	wlMu.RLock()
+	epoch := currentEpoch
+	wlMu.RUnlock()
+	var addrs []string
+	switch r.Method {
+	case http.MethodGet:
+		if epStr := r.URL.Query().Get("epoch"); epStr != "" {
+			if ep, err := strconv.Atoi(epStr); err == nil {
+				epoch = ep
+			}
+		}
+		for _, a := range strings.Split(r.URL.Query().Get("addresses"), ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addrs = append(addrs, a)
+			}
+		}
+	case http.MethodPost:
+		var req struct {
+			Addresses []string `json:"addresses"`
+			Epoch     *int     `json:"epoch"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if req.Epoch != nil {
+			epoch = *req.Epoch
+		}
+		addrs = req.Addresses
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s := r.URL.Query().Get("scheme"); s != "" && s != merkle.Standard {
+		http.Error(w, "multiproofs require the "+merkle.Standard+" scheme", http.StatusBadRequest)
+		return
+	}
+	if len(addrs) == 0 {
+		http.Error(w, "addresses required", http.StatusBadRequest)
+		return
+	}
+	if len(addrs) > merkleBatchMax {
+		http.Error(w, fmt.Sprintf("at most %d addresses per request", merkleBatchMax), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mp, root, err := computeMerkleMultiProof(epoch, addrs)
+	switch {
+	case err == errWhitelistMissing:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	case errors.Is(err, merkle.ErrNotInTree):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("[MERKLE_MULTIPROOF] epoch %d: %v", epoch, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"merkle_root": root,
+		"scheme":      merkle.Standard,
+		"epoch":       mp.Epoch,
+		"addresses":   mp.Addresses,
+		"leaves":      mp.Leaves,
+		"proof":       mp.Proof,
+		"proof_flags": mp.ProofFlags,
+	})
+}
+
+func verifyMerkleExclusion(p *merkle.ExclusionProof, root string, count int) bool {
+	return merkle.VerifyExclusion(p, root, count)
+}
+
+// merkleExclusionProofHandler proves that an address is not on an epoch's
+// whitelist by returning inclusion proofs of its sorted neighbors, together
+// with the address count the proof must be checked against and, when the
+// root is signed, its attestation. Only the position-binding sha256-legacy
+// scheme supports this.
+func merkleExclusionProofHandler(w http.ResponseWriter, r *http.Request) {
+	addr := strings.ToLower(r.URL.Query().Get("address"))
+	if addr == "" {
+		http.Error(w, "missing address", http.StatusBadRequest)
+		return
+	}
+	wlMu.RLock()
+	epoch := currentEpoch
+	wlMu.RUnlock()
+	if epStr := r.URL.Query().Get("epoch"); epStr != "" {
+		if ep, err := strconv.Atoi(epStr); err == nil {
+			epoch = ep
+		}
+	}
+	name := r.URL.Query().Get("scheme")
+	if name == "" {
+		name = merkle.Legacy
+	}
+	scheme, err := merkle.Get(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !merkle.SupportsExclusion(scheme.Name()) {
+		http.Error(w, fmt.Sprintf("exclusion proofs need the %s scheme, %s does not bind leaf positions", merkle.Legacy, scheme.Name()), http.StatusBadRequest)
+		return
+	}
+	t, ok := epochTree(w, epoch, scheme)
+	if !ok {
+		return
+	}
+	p, err := t.ExclusionProof(addr)
+	switch {
+	case err == merkle.ErrInTree:
+		http.Error(w, "address is on the whitelist", http.StatusConflict)
+		return
+	case err == merkle.ErrExclusionUnsupported:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("[MERKLE_EXCLUSION] epoch %d: %v", epoch, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	resp := map[string]interface{}{
+		"merkle_root":   t.Root(),
+		"address_count": t.Len(),
+		"exclusion":     p,
+	}
+	if a, ok := getAttestation(epoch, scheme.Name()); ok && a.MerkleRoot == t.Root() {
+		if a.AddressCount != p.LeafCount {
+			log.Printf("[MERKLE_EXCLUSION] epoch %d: tree has %d leaves, attestation %d", epoch, p.LeafCount, a.AddressCount)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		resp["attestation"] = a
+	}
+	writeJSON(w, resp)
+}
+
+// writeABIProof answers /merkle_proof?format=abi with the proof encoded for
+// the contract generated by cmd/contractgen: proof_abi holds the ABI-encoded
+// proof arguments and verify_calldata a complete verify() call.
+func writeABIProof(w http.ResponseWriter, t *merkle.Tree, addr string, proof []merkle.ProofStep) {
+	enc, err := solidity.EncodeProof(t.Scheme(), proof)
+	if err != nil {
+		log.Printf("[MERKLE_PROOF] abi encode: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	call, err := solidity.VerifyCalldata(t.Scheme(), t.Epoch(), addr, proof)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	root, err := solidity.Bytes32(t.Root())
+	if err != nil {
+		log.Printf("[MERKLE_PROOF] root %q: %v", t.Root(), err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"merkle_root":     t.Root(),
+		"root_bytes32":    "0x" + hex.EncodeToString(root[:]),
+		"epoch":           t.Epoch(),
+		"scheme":          t.Scheme(),
+		"address":         addr,
+		"proof_abi":       "0x" + hex.EncodeToString(enc),
+		"verify_calldata": "0x" + hex.EncodeToString(call),
+	})
+}
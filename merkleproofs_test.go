@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"idenauthgo/merkle"
+	"idenauthgo/solidity"
+)
+
+var bundleAddrs = []string{
+	"0x0000000000000000000000000000000000000001",
+	"0x0000000000000000000000000000000000000002",
+	"0x0000000000000000000000000000000000000003",
+}
+
+func TestMerkleProofsBatch(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	if _, _, err := whitelistRoot(9060, bundleAddrs); err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+	body := `{"epoch":9060,"addresses":["` + bundleAddrs[0] + `","0x00000000000000000000000000000000000000ff"]}`
+	rr := httptest.NewRecorder()
+	merkleProofsHandler(rr, httptest.NewRequest("POST", "/merkle_proofs", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Root   string         `json:"merkle_root"`
+		Proofs []addressProof `json:"proofs"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(out.Proofs) != 2 || !out.Proofs[0].Found || out.Proofs[1].Found {
+		t.Fatalf("unexpected proofs %+v", out.Proofs)
+	}
+	if !verifyMerkleProof(bundleAddrs[0], out.Proofs[0].Proof, out.Root) {
+		t.Fatalf("batch proof does not verify")
+	}
+
+	rr = httptest.NewRecorder()
+	merkleProofsHandler(rr, httptest.NewRequest("POST", "/merkle_proofs", strings.NewReader(`{"addresses":[]}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty batch, got %d", rr.Code)
+	}
+}
+
+func TestMerkleBundleNDJSON(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	saved := merkleScheme
+	merkleScheme = merkle.KeccakSorted
+	defer func() { merkleScheme = saved }()
+	if _, _, err := whitelistRoot(9061, bundleAddrs); err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	merkleBundleHandler(rr, httptest.NewRequest("GET", "/merkle_proofs/epoch/9061/bundle?format=ndjson", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	sc := bufio.NewScanner(rr.Body)
+	sc.Scan()
+	var head struct {
+		Root   string `json:"merkle_root"`
+		Scheme string `json:"scheme"`
+		Count  int    `json:"count"`
+	}
+	if err := json.Unmarshal(sc.Bytes(), &head); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if head.Scheme != merkle.KeccakSorted || head.Count != len(bundleAddrs) {
+		t.Fatalf("unexpected header %+v", head)
+	}
+	s := merkle.MustGet(merkle.KeccakSorted)
+	n := 0
+	for sc.Scan() {
+		var p addressProof
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			t.Fatalf("line %d: %v", n, err)
+		}
+		if !s.Verify(p.Address, 9061, p.Proof, head.Root) {
+			t.Fatalf("proof for %s does not verify", p.Address)
+		}
+		n++
+	}
+	if n != len(bundleAddrs) {
+		t.Fatalf("expected %d proofs, got %d", len(bundleAddrs), n)
+	}
+
+	rr = httptest.NewRecorder()
+	merkleBundleHandler(rr, httptest.NewRequest("GET", "/merkle_proofs/epoch/9061/bundle", nil))
+	var doc struct {
+		Scheme string         `json:"scheme"`
+		Proofs []addressProof `json:"proofs"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("json bundle: %v: %s", err, rr.Body.String())
+	}
+	if doc.Scheme != merkle.KeccakSorted || len(doc.Proofs) != len(bundleAddrs) {
+		t.Fatalf("unexpected bundle %+v", doc)
+	}
+}
+
+func TestMerkleMultiProofHandler(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	if err := os.WriteFile(whitelistPath(9062), []byte(`{"addresses":["`+strings.Join(bundleAddrs, `","`)+`"]}`), 0644); err != nil {
+		t.Fatalf("write whitelist: %v", err)
+	}
+	defer os.Remove(whitelistPath(9062))
+
+	rr := httptest.NewRecorder()
+	merkleMultiProofHandler(rr, httptest.NewRequest("GET", "/merkle_multiproof?epoch=9062&addresses="+bundleAddrs[0]+","+bundleAddrs[2], nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Root string `json:"merkle_root"`
+		merkle.MultiProof
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(out.Leaves) != 2 || !verifyMerkleMultiProof(&out.MultiProof, out.Root) {
+		t.Fatalf("multiproof does not verify: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	merkleMultiProofHandler(rr, httptest.NewRequest("POST", "/merkle_multiproof", strings.NewReader(`{"epoch":9062,"addresses":["0x00000000000000000000000000000000000000ff"]}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for absent address, got %d", rr.Code)
+	}
+}
+
+func TestMerkleExclusionProofHandler(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	if _, _, err := whitelistRoot(9063, bundleAddrs); err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+	// the probe sorts between the second and the third leaf
+	rr := httptest.NewRecorder()
+	merkleExclusionProofHandler(rr, httptest.NewRequest("GET", "/merkle_exclusion_proof?epoch=9063&address=0x0000000000000000000000000000000000000002a", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Root      string                `json:"merkle_root"`
+		Count     int                   `json:"address_count"`
+		Exclusion merkle.ExclusionProof `json:"exclusion"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.Count != len(bundleAddrs) || out.Exclusion.Left == nil || out.Exclusion.Right == nil || !verifyMerkleExclusion(&out.Exclusion, out.Root, out.Count) {
+		t.Fatalf("exclusion proof does not verify: %s", rr.Body.String())
+	}
+	if verifyMerkleExclusion(&out.Exclusion, out.Root, out.Count+1) {
+		t.Fatalf("exclusion proof verifies against another address count")
+	}
+
+	rr = httptest.NewRecorder()
+	merkleExclusionProofHandler(rr, httptest.NewRequest("GET", "/merkle_exclusion_proof?epoch=9063&scheme=keccak256-sorted&address=0x0000000000000000000000000000000000000002a", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "sha256-legacy") {
+		t.Fatalf("expected keccak256-sorted to be rejected, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	merkleExclusionProofHandler(rr, httptest.NewRequest("GET", "/merkle_exclusion_proof?epoch=9063&address="+bundleAddrs[1], nil))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for whitelisted address, got %d", rr.Code)
+	}
+}
+
+func TestMerkleProofABIFormat(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	if _, _, err := whitelistRoot(9064, bundleAddrs); err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	merkleProofHandler(rr, httptest.NewRequest("GET", "/merkle_proof?format=abi&epoch=9064&address="+bundleAddrs[2], nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	proof, _ := computeMerkleProof(bundleAddrs, bundleAddrs[2])
+	want, _ := solidity.VerifyCalldata(merkle.Legacy, 9064, bundleAddrs[2], proof)
+	if out["verify_calldata"] != "0x"+hex.EncodeToString(want) {
+		t.Fatalf("unexpected calldata %v", out["verify_calldata"])
+	}
+	if !strings.HasPrefix(out["proof_abi"].(string), "0x") || out["root_bytes32"] != "0x"+out["merkle_root"].(string) {
+		t.Fatalf("unexpected response %v", out)
+	}
+}
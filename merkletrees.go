@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"idenauthgo/merkle"
+)
+
+// Merkle trees are materialized once per epoch and scheme and kept in
+// epoch_merkle_trees, so proof requests never rehash the whitelist. The most
+// recently used trees stay decoded in memory.
+
+var merkleTreeCacheSize = getenvInt("MERKLE_TREE_CACHE", 8)
+
+type treeKey struct {
+	epoch  int
+	scheme string
+}
+
+// treeLRU is a fixed-size least-recently-used cache of decoded trees.
+type treeLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[treeKey]*list.Element
+}
+
+type treeEntry struct {
+	key  treeKey
+	tree *merkle.Tree
+}
+
+func newTreeLRU(size int) *treeLRU {
+	if size < 1 {
+		size = 1
+	}
+	return &treeLRU{size: size, order: list.New(), items: make(map[treeKey]*list.Element)}
+}
+
+func (c *treeLRU) get(k treeKey) (*merkle.Tree, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*treeEntry).tree, true
+}
+
+func (c *treeLRU) put(k treeKey, t *merkle.Tree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		el.Value.(*treeEntry).tree = t
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&treeEntry{key: k, tree: t})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*treeEntry).key)
+	}
+}
+
+// dropEpoch removes every tree of an epoch.
+func (c *treeLRU) dropEpoch(epoch int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, el := range c.items {
+		if k.epoch == epoch {
+			c.order.Remove(el)
+			delete(c.items, k)
+		}
+	}
+}
+
+var (
+	merkleTrees = newTreeLRU(merkleTreeCacheSize)
+	// treeBuildMu serializes loading and building so a burst of requests
+	// for a cold epoch builds its tree once.
+	treeBuildMu sync.Mutex
+)
+
+func createMerkleTreeTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS epoch_merkle_trees (
+            epoch INTEGER,
+            scheme TEXT,
+            tree BLOB,
+            ts INTEGER,
+            PRIMARY KEY (epoch, scheme)
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// storeMerkleTree persists a tree together with its root and caches it.
+func storeMerkleTree(t *merkle.Tree) error {
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO epoch_merkle_trees(epoch,scheme,tree,ts) VALUES(?,?,?,?)`,
+		t.Epoch(), t.Scheme(), data, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	saveMerkleRoot(t.Epoch(), t.Scheme(), t.Root())
+	attestRoot(t.Epoch(), t.Scheme(), t.Root(), t.Len())
+	merkleTrees.put(treeKey{t.Epoch(), t.Scheme()}, t)
+	return nil
+}
+
+// dropMerkleTrees forgets the trees and roots of an epoch before its
+// whitelist is replaced.
+func dropMerkleTrees(epoch int) {
+	merkleTrees.dropEpoch(epoch)
+	for _, table := range []string{"epoch_merkle_trees", "epoch_merkle_roots"} {
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE epoch=?", epoch); err != nil {
+			log.Printf("[MERKLE] drop %s for epoch %d: %v", table, epoch, err)
+		}
+	}
+}
+
+// storedMerkleTree returns the tree of an epoch from memory or the database
+// without building it.
+func storedMerkleTree(epoch int, scheme string) (*merkle.Tree, bool) {
+	key := treeKey{epoch, scheme}
+	if t, ok := merkleTrees.get(key); ok {
+		return t, true
+	}
+	t, ok := loadStoredTree(epoch, scheme)
+	if ok {
+		merkleTrees.put(key, t)
+	}
+	return t, ok
+}
+
+// sameLeaves reports whether t was built from list, i.e. has the same
+// leaves in the same order. Standard trees order their leaves by hash, so
+// for them only the set of addresses matters.
+func sameLeaves(t *merkle.Tree, list []string) bool {
+	got := t.Addresses()
+	if t.Len() != len(list) || len(got) != len(list) {
+		return false
+	}
+	want := make([]string, len(list))
+	for i, a := range list {
+		want[i] = strings.ToLower(a)
+	}
+	if t.Scheme() == merkle.Standard {
+		sort.Strings(got)
+		sort.Strings(want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func loadStoredTree(epoch int, scheme string) (*merkle.Tree, bool) {
+	row := db.QueryRow(`SELECT tree FROM epoch_merkle_trees WHERE epoch=? AND scheme=?`, epoch, scheme)
+	var data []byte
+	if err := row.Scan(&data); err != nil {
+		return nil, false
+	}
+	var t merkle.Tree
+	if err := t.UnmarshalBinary(data); err != nil {
+		log.Printf("[MERKLE] stored tree for epoch %d/%s: %v", epoch, scheme, err)
+		return nil, false
+	}
+	return &t, true
+}
+
+// errWhitelistMissing is returned by merkleTree when no whitelist exists for
+// the epoch.
+var errWhitelistMissing = fmt.Errorf("whitelist not found")
+
+// merkleTree returns the tree of an epoch's whitelist, from memory, from the
+// database or, as a last resort, by building it from the whitelist.
+func merkleTree(epoch int, scheme merkle.Scheme) (*merkle.Tree, error) {
+	key := treeKey{epoch, scheme.Name()}
+	if t, ok := merkleTrees.get(key); ok {
+		return t, nil
+	}
+	treeBuildMu.Lock()
+	defer treeBuildMu.Unlock()
+	if t, ok := merkleTrees.get(key); ok {
+		return t, nil
+	}
+	if t, ok := loadStoredTree(epoch, scheme.Name()); ok {
+		merkleTrees.put(key, t)
+		return t, nil
+	}
+
+	var addrs []string
+	var err error
+	wlMu.RLock()
+	cur := currentEpoch
+	wlMu.RUnlock()
+	if epoch == cur {
+		addrs, err = getWhitelist()
+	} else {
+		addrs, _, _, err = loadWhitelistData(epoch)
+	}
+	if err != nil {
+		return nil, errWhitelistMissing
+	}
+	t, err := merkle.Build(scheme, addrs, epoch)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeMerkleTree(t); err != nil {
+		log.Printf("[MERKLE] store tree for epoch %d/%s: %v", epoch, scheme.Name(), err)
+		merkleTrees.put(key, t)
+	}
+	log.Printf("[MERKLE] built %s tree for epoch %d with %d leaves", scheme.Name(), epoch, t.Len())
+	return t, nil
+}
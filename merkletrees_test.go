@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"idenauthgo/merkle"
+)
+
+func TestMerkleTreeServedFromStore(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	addrs := []string{
+		"0x0000000000000000000000000000000000000001",
+		"0x0000000000000000000000000000000000000002",
+		"0x0000000000000000000000000000000000000003",
+	}
+	root, _, err := whitelistRoot(9050, addrs)
+	if err != nil {
+		t.Fatalf("whitelist root: %v", err)
+	}
+	// a restarted server has an empty cache and no whitelist file for the
+	// epoch; the stored tree must be enough
+	merkleTrees = newTreeLRU(2)
+	tree, err := merkleTree(9050, merkle.MustGet(merkle.Legacy))
+	if err != nil {
+		t.Fatalf("load tree: %v", err)
+	}
+	if tree.Root() != root || tree.Len() != 3 {
+		t.Fatalf("unexpected tree root=%s len=%d", tree.Root(), tree.Len())
+	}
+
+	rr := httptest.NewRecorder()
+	merkleProofHandler(rr, httptest.NewRequest("GET", "/merkle_proof?epoch=9050&address="+addrs[1], nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	merkleProofHandler(rr, httptest.NewRequest("GET", "/merkle_proof?epoch=9051&address="+addrs[1], nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown epoch, got %d", rr.Code)
+	}
+}
+
+func TestTreeLRUEvictsOldest(t *testing.T) {
+	c := newTreeLRU(2)
+	s := merkle.MustGet(merkle.Legacy)
+	for ep := 1; ep <= 3; ep++ {
+		tree, _ := merkle.Build(s, []string{"0xabc"}, ep)
+		c.put(treeKey{ep, merkle.Legacy}, tree)
+		if ep == 2 {
+			// touch epoch 1 so epoch 2 becomes the oldest
+			c.get(treeKey{1, merkle.Legacy})
+		}
+	}
+	if _, ok := c.get(treeKey{2, merkle.Legacy}); ok {
+		t.Fatalf("epoch 2 should have been evicted")
+	}
+	for _, ep := range []int{1, 3} {
+		if _, ok := c.get(treeKey{ep, merkle.Legacy}); !ok {
+			t.Fatalf("epoch %d missing", ep)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenID Connect provider settings. Relying parties are registered in the
+// clients table; one more can be configured through the environment. The
+// signing key is generated on first start.
+var (
+	OIDC_CLIENT_ID     = getenv("OIDC_CLIENT_ID", "")
+	OIDC_CLIENT_SECRET = getenv("OIDC_CLIENT_SECRET", "")
+	OIDC_REDIRECT_URIS = getenv("OIDC_REDIRECT_URIS", "")
+	OIDC_KEY_FILE      = getenv("OIDC_KEY_FILE", "oidc_signing_key.pem")
+)
+
+const (
+	oidcCodeTTL  = 5 * time.Minute
+	oidcTokenTTL = time.Hour
+)
+
+var (
+	oidcKey   *rsa.PrivateKey
+	oidcKeyID string
+)
+
+// oidcClient describes a relying party allowed to use the authorization flow.
+type oidcClient struct {
+	ID           string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	Policy       string
+}
+
+// oidcRequest is a pending authorization request bound to a sign-in session.
+type oidcRequest struct {
+	Token         string
+	ClientID      string
+	RedirectURI   string
+	State         string
+	Nonce         string
+	Scope         string
+	CodeChallenge string
+}
+
+func createOIDCTables() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS oidc_requests (
+            token TEXT PRIMARY KEY,
+            client_id TEXT,
+            redirect_uri TEXT,
+            state TEXT,
+            nonce TEXT,
+            scope TEXT,
+            code_challenge TEXT,
+            created INTEGER
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS oidc_codes (
+            code TEXT PRIMARY KEY,
+            token TEXT,
+            created INTEGER,
+            used INTEGER DEFAULT 0
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadOIDCKey reads the RSA signing key from path or generates and stores a
+// new one if the file does not exist yet.
+func loadOIDCKey(path string) error {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("invalid PEM in %s", path)
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		setOIDCKey(key)
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, pemData, 0600); err != nil {
+		return err
+	}
+	log.Printf("[OIDC] generated new signing key %s", path)
+	setOIDCKey(key)
+	return nil
+}
+
+func setOIDCKey(key *rsa.PrivateKey) {
+	oidcKey = key
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	oidcKeyID = base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// lookupOIDCClient returns the relying party registered under id.
+func lookupOIDCClient(id string) (*oidcClient, bool) {
+	if id == "" {
+		return nil, false
+	}
+	if c, ok := loadClient(id); ok {
+		return c, true
+	}
+	if id != OIDC_CLIENT_ID {
+		return nil, false
+	}
+	var uris []string
+	for _, u := range strings.Split(OIDC_REDIRECT_URIS, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			uris = append(uris, u)
+		}
+	}
+	c := &oidcClient{ID: OIDC_CLIENT_ID, RedirectURIs: uris, Policy: policyDefault}
+	if OIDC_CLIENT_SECRET != "" {
+		c.SecretHash = hashSecret(OIDC_CLIENT_SECRET)
+	}
+	return c, true
+}
+
+func (c *oidcClient) allowsRedirect(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *oidcClient) checkSecret(secret string) bool {
+	return c.SecretHash != "" && subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(hashSecret(secret))) == 1
+}
+
+// public reports whether the client was registered without a secret. Such
+// clients authenticate with token_endpoint_auth_method=none and must prove
+// possession of the code with PKCE.
+func (c *oidcClient) public() bool {
+	return c.SecretHash == ""
+}
+
+// authenticate checks the client credentials sent to the token endpoint.
+func (c *oidcClient) authenticate(secret string) bool {
+	if c.public() {
+		return secret == ""
+	}
+	return c.checkSecret(secret)
+}
+
+func oidcIssuer() string {
+	return strings.TrimRight(BASE_URL, "/")
+}
+
+// openIDConfigurationHandler serves the discovery document.
+func openIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	iss := oidcIssuer()
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                iss,
+		"authorization_endpoint":                iss + "/oidc/authorize",
+		"token_endpoint":                        iss + "/oidc/token",
+		"userinfo_endpoint":                     iss + "/oidc/userinfo",
+		"jwks_uri":                              iss + "/oidc/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "idena"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"idena_address", "idena_state", "idena_stake", "idena_epoch",
+			"idena_policy", "idena_reason",
+		},
+	})
+}
+
+// jwksHandler publishes the public part of the signing key.
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if oidcKey == nil {
+		http.Error(w, "signing key not configured", http.StatusServiceUnavailable)
+		return
+	}
+	pub := oidcKey.PublicKey
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": oidcKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// oidcAuthorizeHandler validates an authorization request and starts the
+// regular Idena deep-link sign-in with the request attached to the session.
+func oidcAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	client, ok := lookupOIDCClient(q.Get("client_id"))
+	if !ok {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !client.allowsRedirect(redirectURI) {
+		// never redirect to an unregistered URI
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" {
+		redirectOIDCError(w, r, redirectURI, q.Get("state"), "unsupported_response_type", "only response_type=code is supported")
+		return
+	}
+	scope := q.Get("scope")
+	if !hasScope(scope, "openid") {
+		redirectOIDCError(w, r, redirectURI, q.Get("state"), "invalid_scope", "scope must include openid")
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if challenge != "" && q.Get("code_challenge_method") != "S256" {
+		redirectOIDCError(w, r, redirectURI, q.Get("state"), "invalid_request", "only S256 code challenges are supported")
+		return
+	}
+	if challenge == "" && client.public() {
+		redirectOIDCError(w, r, redirectURI, q.Get("state"), "invalid_request", "public clients must send a code_challenge")
+		return
+	}
+
+	token, err := newSignInSession(client.Policy)
+	if err != nil {
+		log.Printf("[OIDC] DB error storing session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = storeOIDCRequest(&oidcRequest{
+		Token:         token,
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		State:         q.Get("state"),
+		Nonce:         q.Get("nonce"),
+		Scope:         scope,
+		CodeChallenge: challenge,
+	})
+	if err != nil {
+		log.Printf("[OIDC] DB error storing request: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[OIDC] authorization request client=%s token=%s", client.ID, token)
+	http.Redirect(w, r, idenaSigninURL(token), http.StatusFound)
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func redirectOIDCError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, desc string) {
+	v := url.Values{}
+	v.Set("error", code)
+	v.Set("error_description", desc)
+	if state != "" {
+		v.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, v), http.StatusFound)
+}
+
+func appendQuery(uri string, v url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + v.Encode()
+	}
+	return uri + "?" + v.Encode()
+}
+
+// storeOIDCRequest attaches an authorization request to a sign-in session.
+func storeOIDCRequest(req *oidcRequest) error {
+	_, err := db.Exec(`INSERT INTO oidc_requests(token,client_id,redirect_uri,state,nonce,scope,code_challenge,created) VALUES(?,?,?,?,?,?,?,?)`,
+		req.Token, req.ClientID, req.RedirectURI, req.State, req.Nonce, req.Scope, req.CodeChallenge, time.Now().Unix())
+	return err
+}
+
+// getOIDCRequest returns the pending authorization request for a session.
+func getOIDCRequest(token string) (*oidcRequest, bool) {
+	row := db.QueryRow(`SELECT client_id, redirect_uri, state, nonce, scope, code_challenge FROM oidc_requests WHERE token=?`, token)
+	req := oidcRequest{Token: token}
+	if err := row.Scan(&req.ClientID, &req.RedirectURI, &req.State, &req.Nonce, &req.Scope, &req.CodeChallenge); err != nil {
+		return nil, false
+	}
+	return &req, true
+}
+
+// finishOIDCAuthorization redirects the browser back to the relying party,
+// either with a one-time authorization code or with an access_denied error.
+func finishOIDCAuthorization(w http.ResponseWriter, r *http.Request, req *oidcRequest, eligible bool, reason string) {
+	if !eligible {
+		log.Printf("[OIDC] access denied for token=%s: %s", req.Token, reason)
+		redirectOIDCError(w, r, req.RedirectURI, req.State, "access_denied", reason)
+		return
+	}
+	code := randHex(32)
+	if _, err := db.Exec(`INSERT INTO oidc_codes(code,token,created) VALUES(?,?,?)`, code, req.Token, time.Now().Unix()); err != nil {
+		log.Printf("[OIDC] DB error storing code: %v", err)
+		redirectOIDCError(w, r, req.RedirectURI, req.State, "server_error", "could not issue authorization code")
+		return
+	}
+	v := url.Values{}
+	v.Set("code", code)
+	if req.State != "" {
+		v.Set("state", req.State)
+	}
+	log.Printf("[OIDC] issued code for client=%s token=%s", req.ClientID, req.Token)
+	http.Redirect(w, r, appendQuery(req.RedirectURI, v), http.StatusFound)
+}
+
+// oidcTokenHandler exchanges an authorization code for an ID token.
+func oidcTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOIDCError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	clientID, secret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	client, ok := lookupOIDCClient(clientID)
+	if !ok || !client.authenticate(secret) {
+		writeOIDCError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// the code is only marked as used once the whole request checked out,
+	// so a failed attempt does not burn it
+	code := r.PostForm.Get("code")
+	row := db.QueryRow(`SELECT token, created, used FROM oidc_codes WHERE code=?`, code)
+	var token string
+	var created int64
+	var used int
+	if err := row.Scan(&token, &created, &used); err != nil {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "unknown authorization code")
+		return
+	}
+	if used != 0 {
+		log.Printf("[OIDC] authorization code replay for token=%s", token)
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "authorization code already used")
+		return
+	}
+	if time.Since(time.Unix(created, 0)) > oidcCodeTTL {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "authorization code expired")
+		return
+	}
+	req, ok := getOIDCRequest(token)
+	if !ok || req.ClientID != client.ID {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "code was not issued to this client")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != req.RedirectURI {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	if req.CodeChallenge == "" && client.public() {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "code was issued without a code_challenge")
+		return
+	}
+	if req.CodeChallenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != req.CodeChallenge {
+			writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "code_verifier mismatch")
+			return
+		}
+	}
+
+	row = db.QueryRow(`SELECT address, authenticated, identity_state, stake, created, COALESCE(policy,''), COALESCE(reason,'') FROM sessions WHERE token=?`, token)
+	var address, state, policy, reason string
+	var authenticated int
+	var stake float64
+	var authTime int64
+	if err := row.Scan(&address, &authenticated, &state, &stake, &authTime, &policy, &reason); err != nil || authenticated != 1 {
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "session is not authenticated")
+		return
+	}
+
+	res, err := db.Exec(`UPDATE oidc_codes SET used=1 WHERE code=? AND used=0`, code)
+	if err != nil {
+		writeOIDCError(w, http.StatusInternalServerError, "server_error", "could not redeem code")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("[OIDC] authorization code replay for token=%s", token)
+		writeOIDCError(w, http.StatusBadRequest, "invalid_grant", "authorization code already used")
+		return
+	}
+
+	now := time.Now()
+	claims := idenaClaims(address, state, stake)
+	claims["idena_policy"] = policy
+	claims["idena_reason"] = reason
+	claims["iss"] = oidcIssuer()
+	claims["sub"] = strings.ToLower(address)
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(oidcTokenTTL).Unix()
+	claims["auth_time"] = authTime
+	if req.Nonce != "" {
+		claims["nonce"] = req.Nonce
+	}
+	idToken, err := signJWT(claims)
+	if err != nil {
+		log.Printf("[OIDC] sign id_token: %v", err)
+		writeOIDCError(w, http.StatusInternalServerError, "server_error", "could not sign token")
+		return
+	}
+
+	atClaims := idenaClaims(address, state, stake)
+	atClaims["idena_policy"] = policy
+	atClaims["idena_reason"] = reason
+	atClaims["iss"] = oidcIssuer()
+	atClaims["sub"] = strings.ToLower(address)
+	atClaims["aud"] = oidcIssuer() + "/oidc/userinfo"
+	atClaims["client_id"] = client.ID
+	atClaims["scope"] = req.Scope
+	atClaims["iat"] = now.Unix()
+	atClaims["exp"] = now.Add(oidcTokenTTL).Unix()
+	accessToken, err := signJWT(atClaims)
+	if err != nil {
+		log.Printf("[OIDC] sign access_token: %v", err)
+		writeOIDCError(w, http.StatusInternalServerError, "server_error", "could not sign token")
+		return
+	}
+	_, _ = db.Exec(`DELETE FROM oidc_requests WHERE token=?`, token)
+
+	log.Printf("[OIDC] issued tokens for %s to client=%s", address, client.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(oidcTokenTTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        req.Scope,
+	})
+}
+
+// oidcUserinfoHandler returns the claims of a bearer access token.
+func oidcUserinfoHandler(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	claims, err := parseJWT(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil || claims["aud"] != oidcIssuer()+"/oidc/userinfo" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	out := map[string]interface{}{"sub": claims["sub"]}
+	for _, k := range []string{"idena_address", "idena_state", "idena_stake", "idena_epoch", "idena_policy", "idena_reason"} {
+		out[k] = claims[k]
+	}
+	writeJSON(w, out)
+}
+
+// idenaClaims returns the custom claims describing an Idena identity.
+func idenaClaims(address, state string, stake float64) map[string]interface{} {
+	wlMu.RLock()
+	epoch := currentEpoch
+	wlMu.RUnlock()
+	return map[string]interface{}{
+		"idena_address": address,
+		"idena_state":   state,
+		"idena_stake":   stake,
+		"idena_epoch":   epoch,
+	}
+}
+
+func writeOIDCError(w http.ResponseWriter, status int, code, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": desc})
+}
+
+// signJWT encodes claims as a compact RS256 JWT signed with the provider key.
+func signJWT(claims map[string]interface{}) (string, error) {
+	if oidcKey == nil {
+		return "", errors.New("signing key not configured")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": oidcKeyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, oidcKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseJWT verifies a token issued by signJWT and returns its claims.
+func parseJWT(token string) (map[string]interface{}, error) {
+	if oidcKey == nil {
+		return nil, errors.New("signing key not configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil || header.Alg != "RS256" {
+		return nil, errors.New("unsupported token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&oidcKey.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, err
+	}
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().Unix() > int64(exp) {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+func cleanupOIDC() {
+	cutoff := time.Now().Add(-1 * time.Hour).Unix()
+	_, _ = db.Exec("DELETE FROM oidc_requests WHERE created < ?", cutoff)
+	_, _ = db.Exec("DELETE FROM oidc_codes WHERE created < ?", cutoff)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTestOIDC(t *testing.T) {
+	setupTestDB(t)
+	createOIDCTables()
+	createClientTable()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	setOIDCKey(key)
+	oldID, oldSecret, oldURIs := OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URIS
+	OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URIS = "app", "s3cret", "https://app.example/cb"
+	t.Cleanup(func() {
+		OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URIS = oldID, oldSecret, oldURIs
+		db.Close()
+	})
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	rr := httptest.NewRecorder()
+	openIDConfigurationHandler(rr, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc["issuer"] != oidcIssuer() || doc["jwks_uri"] != oidcIssuer()+"/oidc/jwks" {
+		t.Fatalf("unexpected discovery document: %v", doc)
+	}
+}
+
+func TestOIDCAuthorizeRejectsUnknownRedirect(t *testing.T) {
+	setupTestOIDC(t)
+	req := httptest.NewRequest(http.MethodGet, "/oidc/authorize?client_id=app&response_type=code&scope=openid&redirect_uri=https://evil.example/cb", nil)
+	rr := httptest.NewRecorder()
+	oidcAuthorizeHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	setupTestOIDC(t)
+
+	verifier := "verifier-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	q := url.Values{
+		"client_id":             {"app"},
+		"response_type":         {"code"},
+		"scope":                 {"openid idena"},
+		"redirect_uri":          {"https://app.example/cb"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-1"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	rr := httptest.NewRecorder()
+	oidcAuthorizeHandler(rr, httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+q.Encode(), nil))
+	if rr.Code != http.StatusFound || !strings.HasPrefix(rr.Header().Get("Location"), "https://app.idena.io/dna/signin") {
+		t.Fatalf("expected redirect to Idena app, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	var token string
+	if err := db.QueryRow(`SELECT token FROM oidc_requests`).Scan(&token); err != nil {
+		t.Fatalf("pending request: %v", err)
+	}
+
+	stakeThreshold = 10000
+	_, err := db.Exec(`UPDATE sessions SET address=?, authenticated=1, status='authenticated', identity_state=?, stake=? WHERE token=?`,
+		"0xAbC", "Human", 20000.0, token)
+	if err != nil {
+		t.Fatalf("update session: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	callbackHandler(rr, httptest.NewRequest(http.MethodGet, "/callback?token="+token, nil))
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || err != nil || loc.Host != "app.example" {
+		t.Fatalf("expected redirect to client, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	code := loc.Query().Get("code")
+	if code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf("missing code or state: %s", loc)
+	}
+
+	exchange := func(verifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example/cb"},
+			"code_verifier": {verifier},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("app", "s3cret")
+		rr := httptest.NewRecorder()
+		oidcTokenHandler(rr, req)
+		return rr
+	}
+	if rr = exchange("wrong-verifier"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected verifier mismatch, got %d", rr.Code)
+	}
+	rr = exchange(verifier)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("token exchange failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var tok struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &tok); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	claims, err := parseJWT(tok.IDToken)
+	if err != nil {
+		t.Fatalf("verify id_token: %v", err)
+	}
+	if claims["sub"] != "0xabc" || claims["aud"] != "app" || claims["nonce"] != "n-1" || claims["idena_state"] != "Human" || claims["idena_stake"] != 20000.0 {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	rr = httptest.NewRecorder()
+	oidcUserinfoHandler(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"idena_address":"0xAbC"`) {
+		t.Fatalf("userinfo: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr = exchange(verifier); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected code replay to fail, got %d", rr.Code)
+	}
+}
+
+func TestOIDCCallbackDenied(t *testing.T) {
+	setupTestOIDC(t)
+	_, err := db.Exec(`INSERT INTO sessions(token,address,authenticated,identity_state,stake,created) VALUES (?,?,?,?,?,?)`,
+		"tok1", "0xabc", 0, "Suspended", 1.0, time.Now().Unix())
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO oidc_requests(token,client_id,redirect_uri,state,nonce,scope,code_challenge,created) VALUES (?,?,?,?,?,?,?,?)`,
+		"tok1", "app", "https://app.example/cb", "s", "", "openid", "", time.Now().Unix())
+	if err != nil {
+		t.Fatalf("insert request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	callbackHandler(rr, httptest.NewRequest(http.MethodGet, "/callback?token=tok1", nil))
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc == nil || loc.Query().Get("error") != "access_denied" {
+		t.Fatalf("expected access_denied redirect, got %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestOIDCPublicClientUsesPKCE(t *testing.T) {
+	setupTestClients(t)
+	c := registerTestClient(t, `{"name":"spa","redirect_uris":["https://spa.example/cb"],"token_endpoint_auth_method":"none"}`)
+	if c.ClientSecret != "" || c.AuthMethod != "none" {
+		t.Fatalf("public client got a secret: %+v", c)
+	}
+
+	q := url.Values{
+		"client_id":     {c.ClientID},
+		"response_type": {"code"},
+		"scope":         {"openid"},
+		"redirect_uri":  {"https://spa.example/cb"},
+	}
+	rr := httptest.NewRecorder()
+	oidcAuthorizeHandler(rr, httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+q.Encode(), nil))
+	if loc, _ := url.Parse(rr.Header().Get("Location")); loc == nil || loc.Query().Get("error") != "invalid_request" {
+		t.Fatalf("expected invalid_request without code_challenge, got %s", rr.Header().Get("Location"))
+	}
+
+	verifier := "public-verifier-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	now := time.Now().Unix()
+	_, err := db.Exec(`INSERT INTO sessions(token,address,authenticated,identity_state,stake,created) VALUES (?,?,?,?,?,?)`,
+		"tok1", "0xabc", 1, "Human", 20000.0, now)
+	if err != nil {
+		t.Fatalf("insert session: %v", err)
+	}
+	err = storeOIDCRequest(&oidcRequest{Token: "tok1", ClientID: c.ClientID, RedirectURI: "https://spa.example/cb", Scope: "openid",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatalf("store request: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO oidc_codes(code,token,created) VALUES(?,?,?)`, "code1", "tok1", now); err != nil {
+		t.Fatalf("insert code: %v", err)
+	}
+
+	exchange := func(secret string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {"code1"},
+			"redirect_uri":  {"https://spa.example/cb"},
+			"code_verifier": {verifier},
+			"client_id":     {c.ClientID},
+		}
+		if secret != "" {
+			form.Set("client_secret", secret)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		oidcTokenHandler(rr, req)
+		return rr
+	}
+	if rr = exchange("guess"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a secret to be rejected for a public client, got %d", rr.Code)
+	}
+	if rr = exchange(""); rr.Code != http.StatusOK {
+		t.Fatalf("public token exchange: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"idenauthgo/eligibility"
+)
+
+// POLICY_FILE holds the named login policies. A missing file leaves only the
+// built-in default and signature policies.
+var POLICY_FILE = getenv("POLICY_FILE", "config/policies.json")
+
+const (
+	policyDefault   = "default"
+	policySignature = "signature"
+)
+
+var (
+	policyMu sync.RWMutex
+	policies = map[string]eligibility.Policy{
+		policyDefault:   eligibility.DefaultPolicy(),
+		policySignature: eligibility.SignaturePolicy(),
+	}
+
+	// identityAgeFetcher can be replaced in tests to avoid network calls
+	identityAgeFetcher func(string) int = getIdentityAge
+)
+
+func loadPolicyFile(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Printf("[POLICY] %s not found, using built-in policies", path)
+		return
+	}
+	loaded, err := eligibility.LoadPolicies(path)
+	if err != nil {
+		log.Fatalf("[POLICY] %v", err)
+	}
+	policyMu.Lock()
+	policies = loaded
+	policyMu.Unlock()
+	log.Printf("[POLICY] loaded %d policies from %s", len(loaded), path)
+}
+
+func lookupPolicy(name string) (eligibility.Policy, bool) {
+	if name == "" {
+		name = policyDefault
+	}
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	p, ok := policies[name]
+	return p, ok
+}
+
+// evaluatePolicy applies the named policy to an authentication attempt and
+// returns whether the login is accepted and a human readable reason.
+func evaluatePolicy(name string, sigOK bool, address, state string, stake float64) (bool, string) {
+	p, ok := lookupPolicy(name)
+	if !ok {
+		return false, "Unknown policy " + name + "."
+	}
+	id := eligibility.Identity{State: state, Stake: stake}
+	if p.NeedsAge() {
+		id.Age = identityAgeFetcher(address)
+	}
+	reasons := p.Evaluate(id, stakeThreshold)
+	if !sigOK {
+		reasons = append([]string{"Invalid signature."}, reasons...)
+	}
+	if len(reasons) == 0 {
+		if p.Name == policyDefault {
+			return true, "Eligible for login."
+		}
+		return true, "Eligible for login (policy " + p.Name + ")."
+	}
+	return false, strings.Join(reasons, " ")
+}
+
+// sessionPolicy returns the policy a sign-in session was started with.
+func sessionPolicy(token string) string {
+	row := db.QueryRow(`SELECT COALESCE(policy,'') FROM sessions WHERE token=?`, token)
+	var p string
+	if err := row.Scan(&p); err != nil || p == "" {
+		return policyDefault
+	}
+	return p
+}
+
+// policiesHandler lists the configured policies so apps can pick one for
+// /signin?policy=...
+func policiesHandler(w http.ResponseWriter, r *http.Request) {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	writeJSON(w, policies)
+}
+
+// getIdentityAge returns the identity age in epochs, or 0 if unknown.
+func getIdentityAge(address string) int {
+	if id, err := nodeSource.GetIdentity(address); err == nil && id.Age > 0 {
+		return id.Age
+	}
+	id, err := apiSource.GetIdentity(address)
+	if err != nil {
+		log.Printf("[IDENTITY][AGE] %s: %v", address, err)
+		return 0
+	}
+	return id.Age
+}
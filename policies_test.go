@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"idenauthgo/eligibility"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSigninRejectsUnknownPolicy(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	rr := httptest.NewRecorder()
+	signinHandler(rr, httptest.NewRequest(http.MethodGet, "/signin?policy=nope", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestPolicySelectedAtSignin(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	// a login exports the whitelist into the data dir
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+	loadPolicyFile("config/policies.json")
+	defer func() {
+		policies, _ = eligibility.LoadPolicies("")
+	}()
+	stakeThreshold = 10000
+	identityFetcher = func(addr string) (string, float64) { return "Human", 50000 }
+	defer func() { identityFetcher = getIdentity }()
+	identityAgeFetcher = func(addr string) int { return 4 }
+	defer func() { identityAgeFetcher = getIdentityAge }()
+
+	rr := httptest.NewRecorder()
+	signinHandler(rr, httptest.NewRequest(http.MethodGet, "/signin?policy=veteran", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", rr.Code)
+	}
+	var token string
+	if err := db.QueryRow(`SELECT token FROM sessions WHERE policy='veteran'`).Scan(&token); err != nil {
+		t.Fatalf("session with policy: %v", err)
+	}
+
+	priv, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(priv.PublicKey).Hex()
+	nonce, err := issueNonce(token, addr)
+	if err != nil {
+		t.Fatalf("issue nonce: %v", err)
+	}
+	ok, reason := postAuthenticate(t, token, signMessage(priv, nonce))
+	if ok || !strings.Contains(reason, "Identity too young") {
+		t.Fatalf("expected age rejection, got %t %q", ok, reason)
+	}
+
+	rr = httptest.NewRecorder()
+	callbackHandler(rr, httptest.NewRequest(http.MethodGet, "/callback?token="+token, nil))
+	if body := rr.Body.String(); !strings.Contains(body, "Access denied!") || !strings.Contains(body, "Identity too young") {
+		t.Fatalf("result page should show the policy reason: %s", body)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Before a built whitelist goes live it is checked against the publication
+// guards below. A list that violates one of them is not published: it is
+// stored in whitelist_candidates and stays there until an admin approves it
+// through POST /admin/whitelist/approve. With WHITELIST_REQUIRE_APPROVAL
+// every list waits for approval.
+
+var (
+	// WHITELIST_MIN_ADDRESSES and WHITELIST_MAX_ADDRESSES bound the size of
+	// the list; 0 disables the bound.
+	WHITELIST_MIN_ADDRESSES = getenvInt("WHITELIST_MIN_ADDRESSES", 0)
+	WHITELIST_MAX_ADDRESSES = getenvInt("WHITELIST_MAX_ADDRESSES", 0)
+	// WHITELIST_MAX_CHANGE_PCT bounds the change in size against the last
+	// published epoch; 0 disables the check.
+	WHITELIST_MAX_CHANGE_PCT = getenvInt("WHITELIST_MAX_CHANGE_PCT", 0)
+	// WHITELIST_MAX_CHECK_ERROR_PCT is the share of penalty and validation
+	// checks that may fail.
+	WHITELIST_MAX_CHECK_ERROR_PCT = getenvInt("WHITELIST_MAX_CHECK_ERROR_PCT", 50)
+	WHITELIST_REQUIRE_APPROVAL    = getenv("WHITELIST_REQUIRE_APPROVAL", "false") == "true"
+)
+
+// errWhitelistQuarantined is wrapped by builds whose list was stored as a
+// candidate instead of being published.
+var errWhitelistQuarantined = errors.New("whitelist quarantined")
+
+// whitelistCandidate is a built whitelist awaiting approval, together with
+// everything finishEpochWhitelist needs to publish it.
+type whitelistCandidate struct {
+	Epoch         int             `json:"epoch"`
+	Threshold     float64         `json:"threshold"`
+	Source        string          `json:"source"`
+	Provider      string          `json:"provider,omitempty"`
+	Block         blockRef        `json:"block"`
+	AddressCount  int             `json:"address_count"`
+	PreviousEpoch int             `json:"previous_epoch,omitempty"`
+	PreviousCount int             `json:"previous_count,omitempty"`
+	Checks        int             `json:"checks"`
+	CheckErrors   int             `json:"check_errors"`
+	Reasons       []string        `json:"reasons"`
+	Created       int64           `json:"created"`
+	Snapshots     []EpochSnapshot `json:"snapshots,omitempty"`
+}
+
+func createCandidateTable() {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS whitelist_candidates (
+            epoch INTEGER PRIMARY KEY,
+            candidate TEXT,
+            ts INTEGER
+        )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// guardViolations returns why c must not be published without approval.
+func guardViolations(c *whitelistCandidate) []string {
+	var reasons []string
+	if WHITELIST_MIN_ADDRESSES > 0 && c.AddressCount < WHITELIST_MIN_ADDRESSES {
+		reasons = append(reasons, fmt.Sprintf("%d addresses, below the minimum of %d", c.AddressCount, WHITELIST_MIN_ADDRESSES))
+	}
+	if WHITELIST_MAX_ADDRESSES > 0 && c.AddressCount > WHITELIST_MAX_ADDRESSES {
+		reasons = append(reasons, fmt.Sprintf("%d addresses, above the maximum of %d", c.AddressCount, WHITELIST_MAX_ADDRESSES))
+	}
+	if WHITELIST_MAX_CHANGE_PCT > 0 && c.PreviousCount > 0 {
+		change := math.Abs(float64(c.AddressCount-c.PreviousCount)) * 100 / float64(c.PreviousCount)
+		if change > float64(WHITELIST_MAX_CHANGE_PCT) {
+			reasons = append(reasons, fmt.Sprintf("%d addresses, %.1f%% change from %d in epoch %d exceeds %d%%",
+				c.AddressCount, change, c.PreviousCount, c.PreviousEpoch, WHITELIST_MAX_CHANGE_PCT))
+		}
+	}
+	if c.Checks > 0 {
+		rate := float64(c.CheckErrors) * 100 / float64(c.Checks)
+		if rate > float64(WHITELIST_MAX_CHECK_ERROR_PCT) {
+			reasons = append(reasons, fmt.Sprintf("%d of %d checks failed (%.1f%%), more than %d%%",
+				c.CheckErrors, c.Checks, rate, WHITELIST_MAX_CHECK_ERROR_PCT))
+		}
+	}
+	if WHITELIST_REQUIRE_APPROVAL {
+		reasons = append(reasons, "approval required")
+	}
+	return reasons
+}
+
+// previousPublished returns the epoch and size of the last whitelist
+// published before epoch.
+func previousPublished(epoch int) (int, int, bool) {
+	var data string
+	err := db.QueryRow(`SELECT manifest FROM epoch_manifests WHERE epoch < ? ORDER BY epoch DESC LIMIT 1`, epoch).Scan(&data)
+	if err != nil {
+		return 0, 0, false
+	}
+	var m snapshotManifest
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return 0, 0, false
+	}
+	return m.Epoch, m.AddressCount, true
+}
+
+// publishEpochWhitelist publishes the list built from snaps through
+// finishEpochWhitelist if it passes the publication guards. Otherwise it is
+// stored as a candidate and the returned error wraps errWhitelistQuarantined.
+// checks is the number of addresses that were checked and checkErrors the
+// number of those checks that failed.
+func publishEpochWhitelist(epoch int, threshold float64, source, provider string, blk blockRef, snaps []EpochSnapshot, checks, checkErrors int) ([]string, string, error) {
+	c := &whitelistCandidate{
+		Epoch:        epoch,
+		Threshold:    threshold,
+		Source:       source,
+		Provider:     provider,
+		Block:        blk,
+		AddressCount: len(eligibleAddresses(snaps, threshold)),
+		Checks:       checks,
+		CheckErrors:  checkErrors,
+		Created:      time.Now().Unix(),
+		Snapshots:    snaps,
+	}
+	if prev, n, ok := previousPublished(epoch); ok {
+		c.PreviousEpoch, c.PreviousCount = prev, n
+	}
+	c.Reasons = guardViolations(c)
+	if len(c.Reasons) == 0 {
+		deleteCandidate(epoch)
+		return finishEpochWhitelist(epoch, threshold, source, provider, blk, snaps)
+	}
+	if err := saveCandidate(c); err != nil {
+		return nil, "", fmt.Errorf("store candidate: %w", err)
+	}
+	for _, r := range c.Reasons {
+		log.Printf("[WHITELIST] epoch %d candidate held back: %s", epoch, r)
+	}
+	return nil, "", fmt.Errorf("%w: epoch %d awaits approval at /admin/whitelist/approve", errWhitelistQuarantined, epoch)
+}
+
+func saveCandidate(c *whitelistCandidate) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO whitelist_candidates(epoch, candidate, ts) VALUES(?,?,?)`, c.Epoch, string(data), c.Created)
+	return err
+}
+
+func loadCandidate(epoch int) (*whitelistCandidate, error) {
+	var data string
+	err := db.QueryRow(`SELECT candidate FROM whitelist_candidates WHERE epoch=?`, epoch).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	var c whitelistCandidate
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func deleteCandidate(epoch int) {
+	if _, err := db.Exec(`DELETE FROM whitelist_candidates WHERE epoch=?`, epoch); err != nil {
+		log.Printf("[WHITELIST] delete candidate %d: %v", epoch, err)
+	}
+}
+
+// adminWhitelistCandidatesHandler lists the candidates awaiting approval,
+// without their identity records.
+func adminWhitelistCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rows, err := db.Query(`SELECT candidate FROM whitelist_candidates ORDER BY epoch`)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	list := []whitelistCandidate{}
+	for rows.Next() {
+		var data string
+		var c whitelistCandidate
+		if rows.Scan(&data) != nil || json.Unmarshal([]byte(data), &c) != nil {
+			continue
+		}
+		c.Snapshots = nil
+		list = append(list, c)
+	}
+	writeJSON(w, list)
+}
+
+// adminWhitelistApproveHandler publishes the candidate of the epoch given
+// as ?epoch= or {"epoch": N}. A candidate older than the latest published
+// whitelist cannot be approved.
+func adminWhitelistApproveHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Epoch int `json:"epoch"`
+	}
+	if v := r.URL.Query().Get("epoch"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid epoch", http.StatusBadRequest)
+			return
+		}
+		req.Epoch = n
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	c, err := loadCandidate(req.Epoch)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no candidate for epoch", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if prev, _, ok := previousPublished(math.MaxInt32); ok && prev > c.Epoch {
+		http.Error(w, fmt.Sprintf("epoch %d is already published", prev), http.StatusConflict)
+		return
+	}
+	list, root, err := finishEpochWhitelist(c.Epoch, c.Threshold, c.Source, c.Provider, c.Block, c.Snapshots)
+	if err != nil {
+		log.Printf("[WHITELIST] approve epoch %d: %v", c.Epoch, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	deleteCandidate(c.Epoch)
+	log.Printf("[WHITELIST] candidate of epoch %d approved with %d addresses root=%s", c.Epoch, len(list), root)
+	writeJSON(w, map[string]interface{}{"epoch": c.Epoch, "address_count": len(list), "merkle_root": root})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"idenauthgo/epochids"
+)
+
+func TestPublicationGuardsQuarantine(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldToken, oldEpoch := dataDir, ADMIN_TOKEN, currentEpoch
+	oldMin, oldChange, oldErrors := WHITELIST_MIN_ADDRESSES, WHITELIST_MAX_CHANGE_PCT, WHITELIST_MAX_CHECK_ERROR_PCT
+	dataDir, ADMIN_TOKEN = t.TempDir(), "admin-token"
+	WHITELIST_MIN_ADDRESSES, WHITELIST_MAX_CHANGE_PCT, WHITELIST_MAX_CHECK_ERROR_PCT = 2, 50, 25
+	defer func() {
+		dataDir, ADMIN_TOKEN, currentEpoch = oldDir, oldToken, oldEpoch
+		WHITELIST_MIN_ADDRESSES, WHITELIST_MAX_CHANGE_PCT, WHITELIST_MAX_CHECK_ERROR_PCT = oldMin, oldChange, oldErrors
+	}()
+
+	human := func(addrs ...string) []EpochSnapshot {
+		var snaps []EpochSnapshot
+		for _, a := range addrs {
+			snaps = append(snaps, EpochSnapshot{Address: a, State: "Human", Stake: 15000})
+		}
+		return snaps
+	}
+	if _, _, err := publishEpochWhitelist(20, 12000, manifestSourceNode, "", blockRef{}, human("0xaaa", "0xbbb"), 2, 0); err != nil {
+		t.Fatalf("epoch 20: %v", err)
+	}
+
+	cases := []struct {
+		name          string
+		snaps         []EpochSnapshot
+		checks, fails int
+		reason        string
+	}{
+		{"too few", human("0xaaa"), 1, 0, "below the minimum"},
+		{"too large a change", human("0xaaa", "0xbbb", "0xccc", "0xddd"), 4, 0, "change from 2 in epoch 20"},
+		{"check errors", human("0xaaa", "0xbbb"), 4, 2, "2 of 4 checks failed"},
+	}
+	for _, c := range cases {
+		_, _, err := publishEpochWhitelist(21, 12000, manifestSourceNode, "", blockRef{}, c.snaps, c.checks, c.fails)
+		if !errors.Is(err, errWhitelistQuarantined) {
+			t.Fatalf("%s: expected quarantine, got %v", c.name, err)
+		}
+		if _, err := os.Stat(whitelistPath(21)); !os.IsNotExist(err) {
+			t.Fatalf("%s: quarantined whitelist was written", c.name)
+		}
+		cand, err := loadCandidate(21)
+		if err != nil || len(cand.Reasons) != 1 || !strings.Contains(cand.Reasons[0], c.reason) {
+			t.Fatalf("%s: unexpected candidate %+v %v", c.name, cand, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/whitelist/candidates", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	adminWhitelistCandidatesHandler(rr, req)
+	var list []whitelistCandidate
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Epoch != 21 || list[0].Snapshots != nil {
+		t.Fatalf("unexpected candidates %s", rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/whitelist/approve", strings.NewReader(`{"epoch":21}`))
+	rr = httptest.NewRecorder()
+	adminWhitelistApproveHandler(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/admin/whitelist/approve", strings.NewReader(`{"epoch":21}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	adminWhitelistApproveHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("approve: %d %s", rr.Code, rr.Body.String())
+	}
+	got, err := getWhitelist()
+	if err != nil || !reflect.DeepEqual(got, []string{"0xaaa", "0xbbb"}) {
+		t.Fatalf("approved whitelist %v %v", got, err)
+	}
+	if _, err := os.Stat(whitelistPath(21)); err != nil {
+		t.Fatalf("approved whitelist not written: %v", err)
+	}
+	if currentEpoch != 21 {
+		t.Fatalf("current epoch %d after approval", currentEpoch)
+	}
+	if _, err := loadCandidate(21); err == nil {
+		t.Fatalf("candidate kept after approval")
+	}
+}
+
+func TestStartupKeepsPublishedListWhenBuildFails(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldEpoch, oldList, oldFetch := dataDir, currentEpoch, currentWhitelist, fetchEpochIdentitiesFn
+	dataDir = t.TempDir()
+	defer func() {
+		dataDir, currentEpoch, currentWhitelist, fetchEpochIdentitiesFn = oldDir, oldEpoch, oldList, oldFetch
+	}()
+
+	snaps := []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}
+	if _, _, err := publishEpochWhitelist(20, 12000, manifestSourceNode, "", blockRef{}, snaps, 1, 0); err != nil {
+		t.Fatalf("epoch 20: %v", err)
+	}
+	setConfigInt("current_epoch", 20)
+
+	// restart while epoch 21 cannot be read completely
+	currentWhitelist = nil
+	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) {
+		return nil, fmt.Errorf("%w: page 2 failed", epochids.ErrTruncated)
+	}
+	saveCheckpoint(21, checkSourceNode, EpochSnapshot{Address: "0xaaa", State: "Human", Stake: 15000})
+	initWhitelist(21, 12000)
+	if currentEpoch != 20 || getConfigInt("current_epoch") != 20 {
+		t.Fatalf("epoch advanced to %d/%d without a published list", currentEpoch, getConfigInt("current_epoch"))
+	}
+	list, err := getWhitelist()
+	if err != nil || !reflect.DeepEqual(list, []string{"0xaaa"}) {
+		t.Fatalf("previous list not served: %v %v", list, err)
+	}
+	if cps := loadCheckpoints(21, checkSourceNode); len(cps) != 0 {
+		t.Fatalf("checkpoints kept after a truncated build: %v", cps)
+	}
+}
+
+func TestFinalizedEpochRetriesUntilPublished(t *testing.T) {
+	setupTestDB(t)
+	oldDir, oldEpoch, oldFetch := dataDir, currentEpoch, fetchEpochIdentitiesFn
+	oldApproval := WHITELIST_REQUIRE_APPROVAL
+	dataDir = t.TempDir()
+	defer func() {
+		dataDir, currentEpoch, fetchEpochIdentitiesFn = oldDir, oldEpoch, oldFetch
+		WHITELIST_REQUIRE_APPROVAL = oldApproval
+	}()
+
+	snaps := []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}
+	if _, _, err := publishEpochWhitelist(20, 12000, manifestSourceNode, "", blockRef{}, snaps, 1, 0); err != nil {
+		t.Fatalf("epoch 20: %v", err)
+	}
+	currentEpoch = 20
+	setConfigInt("current_epoch", 20)
+
+	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) {
+		return nil, fmt.Errorf("%w: page 2 failed", epochids.ErrTruncated)
+	}
+	if !buildFinalizedEpoch(21, 12000, 100) {
+		t.Fatalf("truncated build not retried")
+	}
+	if currentEpoch != 20 {
+		t.Fatalf("epoch advanced to %d after a truncated build", currentEpoch)
+	}
+
+	// without a node the checks run against the API, answered by checkpoints
+	fetchEpochIdentitiesFn = func(epoch int) ([]epochIdentity, error) {
+		return []epochIdentity{{Address: "0xaaa", State: "Human", Stake: 15000}}, nil
+	}
+	saveCheckpoint(21, checkSourceAPI, snaps[0])
+	WHITELIST_REQUIRE_APPROVAL = true
+	if buildFinalizedEpoch(21, 12000, 101) {
+		t.Fatalf("quarantined build retried")
+	}
+	WHITELIST_REQUIRE_APPROVAL = false
+	if buildFinalizedEpoch(21, 12000, 102) || currentEpoch != 20 {
+		t.Fatalf("candidate rebuilt instead of awaiting approval")
+	}
+
+	deleteCandidate(21)
+	saveCheckpoint(21, checkSourceAPI, snaps[0])
+	if buildFinalizedEpoch(21, 12000, 103) {
+		t.Fatalf("published build retried")
+	}
+	if currentEpoch != 21 || getConfigInt("current_epoch") != 21 || getSnapshotBlock(21) != 103 {
+		t.Fatalf("epoch %d/%d block %d after publishing", currentEpoch, getConfigInt("current_epoch"), getSnapshotBlock(21))
+	}
+}
@@ -23,7 +23,6 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -38,7 +37,9 @@ import (
 	"time"
 
 	"idenauthgo/checks"
+	"idenauthgo/datasource"
 	"idenauthgo/eligibility"
+	"idenauthgo/epochids"
 )
 
 // Config holds runtime settings loaded from env or config.json
@@ -62,6 +63,8 @@ type Snapshot struct {
 var (
 	cfg Config
 	db  *sql.DB
+	// enumStore keeps the progress of interrupted epoch enumerations
+	enumStore epochids.Store
 
 	// fallback rate limiting
 	fbMu      sync.Mutex
@@ -76,55 +79,23 @@ var (
 
 const idenaAPI = "https://api.idena.io"
 
+// publicNode returns the public JSON-RPC endpoint used for bootstrap and
+// fallback lookups.
+func publicNode() *datasource.NodeSource {
+	return datasource.NewNodeSource(idenaAPI, "")
+}
+
+// localNode returns the configured node.
+func localNode() *datasource.NodeSource {
+	return datasource.NewNodeSource(cfg.RPCURL, cfg.RPCKey)
+}
+
 func callPublicRPC(method string, params interface{}, out interface{}) error {
-	reqBody := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  method,
-		"params":  params,
-	}
-	b, _ := json.Marshal(reqBody)
-	req, err := http.NewRequest(http.MethodPost, idenaAPI, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %s", resp.Status)
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	return publicNode().CallRaw(method, params, out)
 }
 
 func callLocalRPC(method string, params interface{}, out interface{}) error {
-	reqBody := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  method,
-		"params":  params,
-	}
-	if cfg.RPCKey != "" {
-		reqBody["key"] = cfg.RPCKey
-	}
-	b, _ := json.Marshal(reqBody)
-	req, err := http.NewRequest(http.MethodPost, cfg.RPCURL, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %s", resp.Status)
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	return localNode().CallRaw(method, params, out)
 }
 
 type fbInfo struct {
@@ -186,6 +157,9 @@ func createSchema() {
 	if err != nil {
 		log.Fatalf("create schema: %v", err)
 	}
+	if enumStore, err = epochids.NewSQLStore(db); err != nil {
+		log.Fatalf("create schema: %v", err)
+	}
 }
 
 func loadTracked() {
@@ -235,56 +209,44 @@ func fetchRESTEpoch() (int, error) {
 	return out.Result.Epoch, nil
 }
 
-func fetchPublicEpochIdentities(epoch int) ([]Snapshot, error) {
-	var out struct {
-		Result []struct {
-			Address string `json:"address"`
-			State   string `json:"state"`
-			Stake   string `json:"stake"`
-		} `json:"result"`
-	}
-	if err := callPublicRPC("dna_epochIdentities", []interface{}{epoch, 0}, &out); err != nil {
-		return nil, err
-	}
-	list := make([]Snapshot, 0, len(out.Result))
-	for _, r := range out.Result {
-		stake, _ := strconv.ParseFloat(r.Stake, 64)
-		list = append(list, Snapshot{Address: r.Address, State: r.State, Stake: stake})
+func identitiesToSnapshots(ids []datasource.Identity) []Snapshot {
+	list := make([]Snapshot, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, Snapshot{Address: strings.ToLower(id.Address), State: id.State, Stake: id.Stake})
 	}
-	return list, nil
+	return list
 }
 
 // restDelay controls the pause between public API requests to avoid rate limits.
 const restDelay = 200 * time.Millisecond
 
-// fetchRESTEpochIdentities retrieves identity data for an epoch using the
-// public API via JSON-RPC and handles pagination.
-func fetchRESTEpochIdentities(epoch int) ([]Snapshot, error) {
-	cont := ""
-	var list []Snapshot
-	for {
-		var out struct {
-			Result []struct {
-				Address string `json:"address"`
-				State   string `json:"state"`
-				Stake   string `json:"stake"`
-			} `json:"result"`
-			Continuation string `json:"continuationToken"`
-		}
-		if err := callPublicRPC("dna_epochIdentities", []interface{}{epoch, cont}, &out); err != nil {
-			return list, err
+// fetchEpochIdentities enumerates the identities of an epoch, first through
+// the public JSON-RPC endpoint and then through the REST API. Progress is
+// kept in the database so an interrupted bootstrap resumes, and a list that
+// could not be completed is refused with epochids.ErrTruncated.
+func fetchEpochIdentities(epoch int) ([]Snapshot, error) {
+	api := datasource.NewAPISource(idenaAPI, "")
+	var errs []error
+	for _, src := range []datasource.EpochIdentityPager{publicNode(), api} {
+		e := &epochids.Enumerator{
+			Source:    src,
+			Count:     api.EpochIdentitiesCount,
+			Store:     enumStore,
+			Retries:   3,
+			Backoff:   time.Second,
+			PageDelay: restDelay,
 		}
-		for _, r := range out.Result {
-			stake, _ := strconv.ParseFloat(r.Stake, 64)
-			list = append(list, Snapshot{Address: strings.ToLower(r.Address), State: r.State, Stake: stake})
+		res, err := e.Enumerate(epoch)
+		if err == nil && len(res.Identities) > 0 {
+			return identitiesToSnapshots(res.Identities), nil
 		}
-		if out.Continuation == "" {
-			break
+		if err == nil {
+			err = fmt.Errorf("%s: no identities for epoch %d", src.Name(), epoch)
 		}
-		cont = out.Continuation
-		time.Sleep(restDelay)
+		log.Printf("epoch %d identities: %v", epoch, err)
+		errs = append(errs, err)
 	}
-	return list, nil
+	return nil, errors.Join(errs...)
 }
 
 func bootstrapHistory(epochs int) error {
@@ -299,12 +261,9 @@ func bootstrapHistory(epochs int) error {
 	now := time.Now()
 	for i := 0; i < epochs; i++ {
 		ep := current - i
-		snaps, err := fetchPublicEpochIdentities(ep)
-		if err != nil || len(snaps) == 0 {
-			snaps, err = fetchRESTEpochIdentities(ep)
-			if err != nil {
-				return err
-			}
+		snaps, err := fetchEpochIdentities(ep)
+		if err != nil {
+			return err
 		}
 		storeSnapshots(snaps, now.AddDate(0, 0, -7*i))
 		for _, s := range snaps {
@@ -315,40 +274,11 @@ func bootstrapHistory(epochs int) error {
 }
 
 func fetchAllIdentities() ([]Snapshot, error) {
-	req := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  "dna_identities",
-		"params":  []interface{}{},
-	}
-	if cfg.RPCKey != "" {
-		req["key"] = cfg.RPCKey
-	}
-	body, _ := json.Marshal(req)
-	resp, err := http.Post(cfg.RPCURL, "application/json", bytes.NewReader(body))
+	ids, err := localNode().Identities()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("rpc status %s", resp.Status)
-	}
-	var out struct {
-		Result []struct {
-			Address string `json:"address"`
-			State   string `json:"state"`
-			Stake   string `json:"stake"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	list := make([]Snapshot, 0, len(out.Result))
-	for _, r := range out.Result {
-		stake, _ := strconv.ParseFloat(r.Stake, 64)
-		list = append(list, Snapshot{Address: r.Address, State: r.State, Stake: stake})
-	}
-	return list, nil
+	return identitiesToSnapshots(ids), nil
 }
 
 func fetchIdentityFallback(addr string) (*Snapshot, error) {
@@ -379,18 +309,11 @@ func fetchIdentityFallback(addr string) (*Snapshot, error) {
 	fbTotal++
 	fbMu.Unlock()
 
-	var res struct {
-		Result struct {
-			Address string `json:"address"`
-			State   string `json:"state"`
-			Stake   string `json:"stake"`
-		} `json:"result"`
-	}
-	if err := callPublicRPC("dna_identity", []interface{}{addr}, &res); err != nil {
+	id, err := publicNode().GetIdentity(addr)
+	if err != nil {
 		return nil, err
 	}
-	stake, _ := strconv.ParseFloat(res.Result.Stake, 64)
-	return &Snapshot{Address: res.Result.Address, State: res.Result.State, Stake: stake}, nil
+	return &Snapshot{Address: id.Address, State: id.State, Stake: id.Stake}, nil
 }
 
 func storeSnapshots(snaps []Snapshot, ts time.Time) {
@@ -614,46 +537,25 @@ func handleState(w http.ResponseWriter, r *http.Request) {
 // getEpochAndThreshold returns the current epoch and discrimination stake threshold
 // from the local node via JSON-RPC.
 func getEpochAndThreshold() (int, float64, error) {
-	var out struct {
-		Result struct {
-			Epoch     int     `json:"epoch"`
-			Threshold float64 `json:"discriminationStakeThreshold"`
-		} `json:"result"`
-		Error *struct {
-			Message string `json:"message"`
-		} `json:"error"`
-	}
-	if err := callLocalRPC("dna_epoch", []interface{}{}, &out); err != nil {
+	info, err := localNode().GetEpoch()
+	if err != nil {
 		return 0, 0, err
 	}
-	if out.Error != nil && out.Error.Message != "" {
-		return 0, 0, errors.New(out.Error.Message)
-	}
-	return out.Result.Epoch, out.Result.Threshold, nil
+	return info.Epoch, info.Threshold, nil
 }
 
 // getEpochAndThresholdFor returns epoch data for the given epoch using JSON-RPC.
 // If the node reports the epoch is unknown, errEpochNotFound is returned.
 func getEpochAndThresholdFor(ep int) (int, float64, error) {
-	var out struct {
-		Result struct {
-			Epoch     int     `json:"epoch"`
-			Threshold float64 `json:"discriminationStakeThreshold"`
-		} `json:"result"`
-		Error *struct {
-			Message string `json:"message"`
-		} `json:"error"`
-	}
-	if err := callLocalRPC("dna_epoch", []interface{}{ep}, &out); err != nil {
-		return 0, 0, err
-	}
-	if out.Error != nil && strings.Contains(strings.ToLower(out.Error.Message), "not") {
+	info, err := localNode().EpochAt(ep)
+	var rpcErr *datasource.RPCError
+	if errors.As(err, &rpcErr) && strings.Contains(strings.ToLower(rpcErr.Message), "not") {
 		return 0, 0, errEpochNotFound
 	}
-	if out.Error != nil && out.Error.Message != "" {
-		return 0, 0, errors.New(out.Error.Message)
+	if err != nil {
+		return 0, 0, err
 	}
-	return out.Result.Epoch, out.Result.Threshold, nil
+	return info.Epoch, info.Threshold, nil
 }
 
 // handleEpochLast serves the /api/Epoch/Last endpoint.
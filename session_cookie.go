@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookieName = "idena_session"
+
+// SESSION_SECRET signs the session cookie. When unset, a random secret is
+// generated once and kept in the config table so restarts keep users logged in.
+var SESSION_SECRET = getenv("SESSION_SECRET", "")
+
+var (
+	sessionKey     []byte
+	sessionKeyOnce sync.Once
+)
+
+// loadSessionSecret sets the cookie signing key. It runs once at startup,
+// before any cookie is signed; later calls keep the first key.
+func loadSessionSecret() {
+	sessionKeyOnce.Do(func() {
+		secret := SESSION_SECRET
+		if secret == "" {
+			secret = getConfigString("session_secret")
+		}
+		if secret == "" {
+			secret = randHex(32)
+			setConfigString("session_secret", secret)
+			log.Println("[SESSION] generated new cookie secret")
+		}
+		sessionKey = []byte(secret)
+	})
+}
+
+func signSessionToken(token string) string {
+	if len(sessionKey) == 0 {
+		panic("session key used before loadSessionSecret")
+	}
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(token))
+	return token + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySessionCookie returns the session token carried by a signed cookie
+// value, or false if the signature does not match.
+func verifySessionCookie(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i <= 0 {
+		return "", false
+	}
+	token := value[:i]
+	return token, hmac.Equal([]byte(signSessionToken(token)), []byte(value))
+}
+
+// setSessionCookie logs the browser in with the given consumed session.
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    signSessionToken(token),
+		Path:     "/",
+		MaxAge:   sessionDuration,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(BASE_URL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(BASE_URL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// requestSessionToken extracts the session token from the cookie or, for API
+// clients, from an Authorization bearer header carrying the same value.
+func requestSessionToken(r *http.Request) (string, bool) {
+	value := ""
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		value = c.Value
+	} else if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		value = strings.TrimPrefix(h, "Bearer ")
+	}
+	if value == "" {
+		return "", false
+	}
+	return verifySessionCookie(value)
+}
+
+type loggedInUser struct {
+	Address  string  `json:"address"`
+	State    string  `json:"state"`
+	Stake    float64 `json:"stake"`
+	Eligible bool    `json:"eligible"`
+	Policy   string  `json:"policy"`
+	Reason   string  `json:"reason"`
+	Expires  int64   `json:"expires"`
+}
+
+// currentUser loads the login behind a request. Only sessions that completed
+// the sign-in and have not been revoked or expired are accepted.
+func currentUser(r *http.Request) (*loggedInUser, string, bool) {
+	token, ok := requestSessionToken(r)
+	if !ok {
+		return nil, "", false
+	}
+	row := db.QueryRow(`SELECT address, identity_state, stake, created, COALESCE(policy,''), COALESCE(reason,'') FROM sessions WHERE token=? AND status=? AND authenticated=1`,
+		token, sessionConsumed)
+	u := loggedInUser{Eligible: true}
+	var created int64
+	if err := row.Scan(&u.Address, &u.State, &u.Stake, &created, &u.Policy, &u.Reason); err != nil {
+		return nil, "", false
+	}
+	u.Expires = created + sessionDuration
+	if time.Now().Unix() >= u.Expires {
+		return nil, "", false
+	}
+	return &u, token, true
+}
+
+// whoamiHandler returns the identity behind the session cookie.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	u, _, ok := currentUser(r)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"not logged in"}`))
+		return
+	}
+	writeJSON(w, u)
+}
+
+// logoutHandler revokes the session behind the cookie and clears it.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, token, ok := currentUser(r); ok {
+		setSessionStatus(token, sessionRevoked)
+		log.Printf("[SESSION] logout %s", token)
+	}
+	clearSessionCookie(w)
+	writeJSON(w, map[string]bool{"success": true})
+}
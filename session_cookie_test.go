@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionCookieLifecycle(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	stakeThreshold = 10000
+
+	_, err := db.Exec(`INSERT INTO sessions(token,address,authenticated,identity_state,stake,created,status) VALUES (?,?,?,?,?,?,?)`,
+		"tok1", "0xabc", 1, "Human", 20000.0, time.Now().Unix(), sessionAuthenticated)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	callbackHandler(rr, httptest.NewRequest(http.MethodGet, "/callback?token=tok1", nil))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("expected HttpOnly session cookie, got %v", cookies)
+	}
+	cookie := cookies[0]
+
+	whoami := func(c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		whoamiHandler(rr, req)
+		return rr
+	}
+	rr = whoami(cookie)
+	var u loggedInUser
+	if err := json.Unmarshal(rr.Body.Bytes(), &u); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("whoami: %d %s", rr.Code, rr.Body.String())
+	}
+	if u.Address != "0xabc" || u.State != "Human" || !u.Eligible {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	if rr = whoami(nil); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without cookie, got %d", rr.Code)
+	}
+	forged := &http.Cookie{Name: sessionCookieName, Value: "tok1.deadbeef"}
+	if rr = whoami(forged); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for forged cookie, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	logoutHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("logout: %d", rr.Code)
+	}
+	if rr = whoami(cookie); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sign-in session lifecycle, stored in sessions.status:
+//
+//	created -> nonce_issued -> authenticated -> consumed -> revoked
+//	                        \-> failed
+//
+// A nonce is handed out once per session and can be presented to
+// /auth/v1/authenticate exactly once. Consumed sessions back the login
+// cookie until they expire or the user logs out.
+const (
+	sessionCreated       = "created"
+	sessionNonceIssued   = "nonce_issued"
+	sessionAuthenticated = "authenticated"
+	sessionFailed        = "failed"
+	sessionConsumed      = "consumed"
+	sessionRevoked       = "revoked"
+)
+
+var (
+	nonceTTL           = time.Duration(getenvInt("NONCE_TTL", 300)) * time.Second
+	sessionMaxAttempts = getenvInt("SESSION_MAX_ATTEMPTS", 5)
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionClosed   = errors.New("session is no longer open")
+	errAddressMismatch = errors.New("address does not match the session")
+	errTooManyAttempts = errors.New("too many attempts")
+	errNonceExpired    = errors.New("nonce expired")
+	errNonceUsed       = errors.New("nonce already used")
+)
+
+func getenvInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(getenv(key, "")); err == nil {
+		return v
+	}
+	return fallback
+}
+
+// addColumnIfMissing adds a column to an existing table. Tables created by
+// older versions lack columns introduced later; CREATE TABLE IF NOT EXISTS
+// does not touch them.
+func addColumnIfMissing(table, column, decl string) error {
+	ok, err := hasColumn(table, column)
+	if err != nil || ok {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	if err == nil {
+		log.Printf("[DB] added column %s.%s", table, column)
+	}
+	return err
+}
+
+// hasColumn reports whether table has the named column.
+func hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// migrateSessionTable brings a sessions table from before the lifecycle
+// columns up to date. Rows of the old schema are marked failed so that
+// outstanding nonces cannot be used any more.
+func migrateSessionTable() error {
+	cols := []struct{ name, decl string }{
+		{"status", "TEXT DEFAULT 'failed'"},
+		{"nonce_issued_at", "INTEGER DEFAULT 0"},
+		{"attempts", "INTEGER DEFAULT 0"},
+		{"policy", "TEXT"},
+		{"reason", "TEXT"},
+	}
+	for _, c := range cols {
+		if err := addColumnIfMissing("sessions", c.name, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sessionRow struct {
+	Status        string
+	Address       string
+	Nonce         string
+	NonceIssuedAt int64
+	Attempts      int
+}
+
+func loadSession(token string) (*sessionRow, error) {
+	row := db.QueryRow(`SELECT status, COALESCE(address,''), COALESCE(nonce,''), nonce_issued_at, attempts FROM sessions WHERE token=?`, token)
+	var s sessionRow
+	if err := row.Scan(&s.Status, &s.Address, &s.Nonce, &s.NonceIssuedAt, &s.Attempts); err != nil {
+		return nil, errSessionNotFound
+	}
+	return &s, nil
+}
+
+func (s *sessionRow) nonceExpired(now time.Time) bool {
+	return now.Sub(time.Unix(s.NonceIssuedAt, 0)) > nonceTTL
+}
+
+func setSessionStatus(token, status string) {
+	if _, err := db.Exec(`UPDATE sessions SET status=? WHERE token=?`, status, token); err != nil {
+		log.Printf("[SESSION] set status %s for %s: %v", status, token, err)
+	}
+}
+
+// issueNonce hands out the nonce for a session. Repeated requests for the
+// same address get the same nonce until it expires; a different address is
+// rejected once a nonce was issued.
+func issueNonce(token, address string) (string, error) {
+	s, err := loadSession(token)
+	if err != nil {
+		return "", err
+	}
+	if s.Attempts >= sessionMaxAttempts {
+		setSessionStatus(token, sessionFailed)
+		return "", errTooManyAttempts
+	}
+	_, _ = db.Exec(`UPDATE sessions SET attempts=attempts+1 WHERE token=?`, token)
+
+	now := time.Now()
+	switch s.Status {
+	case sessionCreated:
+	case sessionNonceIssued:
+		if !strings.EqualFold(s.Address, address) {
+			return "", errAddressMismatch
+		}
+		if s.Nonce == "" {
+			return "", errNonceUsed
+		}
+		if !s.nonceExpired(now) {
+			return s.Nonce, nil
+		}
+	default:
+		return "", errSessionClosed
+	}
+
+	nonce := "signin-" + randHex(16)
+	res, err := db.Exec(`UPDATE sessions SET address=?, nonce=?, nonce_issued_at=?, status=? WHERE token=? AND status=? AND COALESCE(nonce,'')=?`,
+		address, nonce, now.Unix(), sessionNonceIssued, token, s.Status, s.Nonce)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// raced with another request for the same session
+		return "", errSessionClosed
+	}
+	return nonce, nil
+}
+
+// claimNonce takes the nonce of a session for verification. The nonce is
+// cleared in the same statement so a second request with the same token
+// cannot present it again.
+func claimNonce(token string) (nonce, address string, err error) {
+	s, err := loadSession(token)
+	if err != nil {
+		return "", "", err
+	}
+	if s.Status != sessionNonceIssued {
+		return "", "", errSessionClosed
+	}
+	if s.Nonce == "" {
+		return "", "", errNonceUsed
+	}
+	if s.nonceExpired(time.Now()) {
+		setSessionStatus(token, sessionFailed)
+		return "", "", errNonceExpired
+	}
+	res, err := db.Exec(`UPDATE sessions SET nonce='', attempts=attempts+1 WHERE token=? AND status=? AND nonce=?`,
+		token, sessionNonceIssued, s.Nonce)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", errNonceUsed
+	}
+	return s.Nonce, s.Address, nil
+}
+
+// finishAuthentication records the outcome of a signature check together
+// with the policy reason shown on the result page and put into tokens.
+func finishAuthentication(token string, eligible bool, state string, stake float64, reason string) error {
+	status := sessionFailed
+	if eligible {
+		status = sessionAuthenticated
+	}
+	_, err := db.Exec(`UPDATE sessions SET status=?, authenticated=?, identity_state=?, stake=?, reason=? WHERE token=?`,
+		status, boolToInt(eligible), state, stake, reason, token)
+	return err
+}
+
+// sessionReason returns the stored outcome of the policy evaluation.
+func sessionReason(token string) string {
+	row := db.QueryRow(`SELECT COALESCE(reason,'') FROM sessions WHERE token=?`, token)
+	var reason string
+	_ = row.Scan(&reason)
+	return reason
+}
+
+// consumeSession marks an authenticated session as used. It reports false
+// if the session was not authenticated or has been consumed already.
+func consumeSession(token string) bool {
+	res, err := db.Exec(`UPDATE sessions SET status=? WHERE token=? AND status=?`, sessionConsumed, token, sessionAuthenticated)
+	if err != nil {
+		log.Printf("[SESSION] consume %s: %v", token, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func postAuthenticate(t *testing.T, token, sig string) (bool, string) {
+	t.Helper()
+	body := `{"token":"` + token + `","signature":"` + sig + `"}`
+	rr := httptest.NewRecorder()
+	authenticateHandler(rr, httptest.NewRequest(http.MethodPost, "/auth/v1/authenticate", strings.NewReader(body)))
+	var resp struct {
+		Data struct {
+			Authenticated bool   `json:"authenticated"`
+			Reason        string `json:"reason"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return resp.Data.Authenticated, resp.Data.Reason
+}
+
+func TestNonceIsSingleUse(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	// a login exports the whitelist into the data dir
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+	identityFetcher = func(addr string) (string, float64) { return "Human", 20000 }
+	defer func() { identityFetcher = getIdentity }()
+
+	priv, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	addr := crypto.PubkeyToAddress(priv.PublicKey).Hex()
+	token, err := newSignInSession(policyDefault)
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+
+	nonce, err := issueNonce(token, addr)
+	if err != nil {
+		t.Fatalf("issue nonce: %v", err)
+	}
+	if again, err := issueNonce(token, addr); err != nil || again != nonce {
+		t.Fatalf("expected same nonce on repeat request, got %q %v", again, err)
+	}
+	if _, err := issueNonce(token, "0x0000000000000000000000000000000000000001"); err != errAddressMismatch {
+		t.Fatalf("expected address mismatch, got %v", err)
+	}
+
+	sig := signMessage(priv, nonce)
+	if ok, reason := postAuthenticate(t, token, sig); !ok {
+		t.Fatalf("expected authentication to succeed: %s", reason)
+	}
+	if ok, _ := postAuthenticate(t, token, sig); ok {
+		t.Fatalf("replayed signature was accepted")
+	}
+	if _, err := issueNonce(token, addr); err != errSessionClosed {
+		t.Fatalf("expected closed session, got %v", err)
+	}
+	if !consumeSession(token) || consumeSession(token) {
+		t.Fatalf("session should be consumed exactly once")
+	}
+}
+
+func TestNonceExpiry(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	token, _ := newSignInSession(policyDefault)
+	if _, err := issueNonce(token, "0xabc"); err != nil {
+		t.Fatalf("issue nonce: %v", err)
+	}
+	old := time.Now().Add(-2 * nonceTTL).Unix()
+	if _, err := db.Exec(`UPDATE sessions SET nonce_issued_at=? WHERE token=?`, old, token); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, _, err := claimNonce(token); err != errNonceExpired {
+		t.Fatalf("expected expired nonce, got %v", err)
+	}
+	if s, _ := loadSession(token); s.Status != sessionFailed {
+		t.Fatalf("expected failed session, got %s", s.Status)
+	}
+}
+
+func TestNonceAttemptLimit(t *testing.T) {
+	setupTestDB(t)
+	defer db.Close()
+	token, _ := newSignInSession(policyDefault)
+	for i := 0; i < sessionMaxAttempts; i++ {
+		if _, err := issueNonce(token, "0xabc"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if _, err := issueNonce(token, "0xabc"); err != errTooManyAttempts {
+		t.Fatalf("expected attempt limit, got %v", err)
+	}
+}
+
+func TestMigrateSessionTable(t *testing.T) {
+	var err error
+	db, err = sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE sessions (token TEXT PRIMARY KEY, address TEXT, nonce TEXT,
+		authenticated INTEGER DEFAULT 0, identity_state TEXT, stake REAL, created INTEGER)`)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_, _ = db.Exec(`INSERT INTO sessions(token,address,nonce,created) VALUES('old','0xabc','n',1)`)
+	createSessionTable()
+	createSessionTable()
+	if _, _, err := claimNonce("old"); err != errSessionClosed {
+		t.Fatalf("nonce from old schema should be unusable, got %v", err)
+	}
+	token, err := newSignInSession(policyDefault)
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	if s, _ := loadSession(token); s.Status != sessionCreated {
+		t.Fatalf("expected created status, got %s", s.Status)
+	}
+}
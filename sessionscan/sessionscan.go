@@ -0,0 +1,371 @@
+// Package sessionscan locates the validation sessions on an Idena node's
+// chain and collects the identities that submitted short answers in them,
+// using only the node's JSON-RPC API.
+package sessionscan
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Block flags marking the phases of a validation ceremony.
+const (
+	FlagShortSessionStarted     = "ShortSessionStarted"
+	FlagLongSessionStarted      = "LongSessionStarted"
+	FlagAfterLongSessionStarted = "AfterLongSessionStarted"
+	FlagValidationFinished      = "ValidationFinished"
+	FlagEpochFinalized          = "EpochFinalized"
+)
+
+// Phases of a session block in a Report.
+const (
+	PhaseShort = "short"
+	PhaseLong  = "long"
+)
+
+// DefaultMaxScan is how many blocks a search walks before giving up. A
+// validation ceremony spans far fewer blocks.
+const DefaultMaxScan = 2000
+
+// ErrNotFound is returned when no block with the requested flag lies within
+// the search range.
+var ErrNotFound = errors.New("session block not found")
+
+// Caller performs a JSON-RPC call; *datasource.NodeSource implements it.
+type Caller interface {
+	Call(method string, params interface{}, result interface{}) error
+}
+
+// Block is the part of a bcn_blockAt result the scanner uses. Transactions
+// holds the transaction hashes.
+type Block struct {
+	Height       int64    `json:"height"`
+	Hash         string   `json:"hash"`
+	Flags        []string `json:"flags"`
+	Transactions []string `json:"transactions"`
+}
+
+// HasFlag reports whether the block carries any of flags.
+func (b *Block) HasFlag(flags ...string) bool {
+	for _, f := range b.Flags {
+		for _, want := range flags {
+			if f == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Tx is the part of a bcn_transaction result the scanner uses.
+type Tx struct {
+	Hash string `json:"hash"`
+	Type string `json:"type"`
+	From string `json:"from"`
+}
+
+// Short answer transaction types, under the names used by the node RPC and
+// by the public API. The hash is submitted during the short session, the
+// answers themselves are revealed during the long session.
+var (
+	answerHashTypes = map[string]bool{
+		"submitAnswersHash":   true,
+		"SubmitAnswersHashTx": true,
+		"ShortAnswersHashTx":  true,
+	}
+	shortAnswersTypes = map[string]bool{
+		"submitShortAnswers":   true,
+		"SubmitShortAnswersTx": true,
+	}
+)
+
+// IsAnswerHashTx reports whether typ is a ShortAnswersHashTx.
+func IsAnswerHashTx(typ string) bool {
+	return answerHashTypes[typ]
+}
+
+// IsShortAnswerTx reports whether typ is a ShortAnswersHashTx or a
+// SubmitShortAnswersTx.
+func IsShortAnswerTx(typ string) bool {
+	return answerHashTypes[typ] || shortAnswersTypes[typ]
+}
+
+// Scanner reads blocks and transactions through a node and caches them by
+// height, so repeated scans of the same session do not hit the node again.
+type Scanner struct {
+	node  Caller
+	cache *blockLRU
+	// MaxScan bounds every search and collection, in blocks.
+	MaxScan int
+}
+
+// New returns a scanner caching up to cacheSize blocks.
+func New(node Caller, cacheSize int) *Scanner {
+	return &Scanner{node: node, cache: newBlockLRU(cacheSize), MaxScan: DefaultMaxScan}
+}
+
+type cachedBlock struct {
+	height  int64
+	block   *Block
+	answers []Tx
+	// resolved is set once the transactions have been read.
+	resolved bool
+}
+
+func (s *Scanner) entry(height int64) (*cachedBlock, error) {
+	if e, ok := s.cache.get(height); ok {
+		return e, nil
+	}
+	var b Block
+	if err := s.node.Call("bcn_blockAt", []interface{}{height}, &b); err != nil {
+		return nil, fmt.Errorf("block %d: %w", height, err)
+	}
+	e := &cachedBlock{height: height, block: &b}
+	s.cache.put(e)
+	return e, nil
+}
+
+// Block returns the block at height.
+func (s *Scanner) Block(height int64) (*Block, error) {
+	e, err := s.entry(height)
+	if err != nil {
+		return nil, err
+	}
+	return e.block, nil
+}
+
+// Answers returns the short answer transactions in the block at height, with
+// lower-cased senders.
+func (s *Scanner) Answers(height int64) ([]Tx, error) {
+	e, err := s.entry(height)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.mu.Lock()
+	resolved, answers := e.resolved, e.answers
+	s.cache.mu.Unlock()
+	if resolved {
+		return answers, nil
+	}
+	answers = nil
+	for _, h := range e.block.Transactions {
+		var tx Tx
+		if err := s.node.Call("bcn_transaction", []interface{}{h}, &tx); err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", h, err)
+		}
+		if IsShortAnswerTx(tx.Type) && tx.From != "" {
+			tx.From = strings.ToLower(tx.From)
+			answers = append(answers, tx)
+		}
+	}
+	s.cache.mu.Lock()
+	e.answers, e.resolved = answers, true
+	s.cache.mu.Unlock()
+	return answers, nil
+}
+
+// FindBackward returns the nearest block at or below height that carries
+// one of flags.
+func (s *Scanner) FindBackward(height int64, flags ...string) (*Block, error) {
+	for h := height; h >= 0 && h > height-int64(s.MaxScan); h-- {
+		b, err := s.Block(h)
+		if err != nil {
+			return nil, err
+		}
+		if b.HasFlag(flags...) {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no %s within %d blocks below %d", ErrNotFound, strings.Join(flags, "/"), s.MaxScan, height)
+}
+
+// FindForward returns the nearest block at or above height that carries one
+// of flags.
+func (s *Scanner) FindForward(height int64, flags ...string) (*Block, error) {
+	for h := height; h < height+int64(s.MaxScan); h++ {
+		b, err := s.Block(h)
+		if err != nil {
+			return nil, err
+		}
+		if b.HasFlag(flags...) {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no %s within %d blocks above %d", ErrNotFound, strings.Join(flags, "/"), s.MaxScan, height)
+}
+
+// Session is the block range of a validation ceremony. Answer hashes are
+// submitted from ShortStart on, the short answers are revealed after
+// LongStart, and End is the first block after the long session.
+type Session struct {
+	Epoch          int    `json:"epoch"`
+	ShortStart     int64  `json:"short_session_start"`
+	ShortStartHash string `json:"short_session_start_hash"`
+	LongStart      int64  `json:"long_session_start"`
+	End            int64  `json:"end"`
+	EndFlag        string `json:"end_flag"`
+}
+
+// FindSession locates the validation ceremony that opened epoch, or the
+// node's current epoch if epoch is 0. The search walks back from the epoch's
+// first block, so the node must be in that epoch.
+func (s *Scanner) FindSession(epoch int) (*Session, error) {
+	var info struct {
+		Epoch      int   `json:"epoch"`
+		StartBlock int64 `json:"startBlock"`
+	}
+	if err := s.node.Call("dna_epoch", []interface{}{}, &info); err != nil {
+		return nil, fmt.Errorf("epoch info: %w", err)
+	}
+	if epoch != 0 && info.Epoch != epoch {
+		return nil, fmt.Errorf("node is in epoch %d, not %d", info.Epoch, epoch)
+	}
+	short, err := s.FindBackward(info.StartBlock, FlagShortSessionStarted)
+	if err != nil {
+		return nil, err
+	}
+	long, err := s.FindForward(short.Height+1, FlagLongSessionStarted)
+	if err != nil {
+		return nil, err
+	}
+	end, err := s.FindForward(long.Height+1, FlagAfterLongSessionStarted, FlagValidationFinished, FlagEpochFinalized)
+	if err != nil {
+		return nil, err
+	}
+	if end.Height > info.StartBlock {
+		return nil, fmt.Errorf("session of epoch %d ends at %d, after the epoch start %d", info.Epoch, end.Height, info.StartBlock)
+	}
+	sess := &Session{
+		Epoch:          info.Epoch,
+		ShortStart:     short.Height,
+		ShortStartHash: short.Hash,
+		LongStart:      long.Height,
+		End:            end.Height,
+	}
+	for _, f := range []string{FlagAfterLongSessionStarted, FlagValidationFinished, FlagEpochFinalized} {
+		if end.HasFlag(f) {
+			sess.EndFlag = f
+			break
+		}
+	}
+	return sess, nil
+}
+
+// BlockCount is the number of short answer submissions in one block of a
+// session.
+type BlockCount struct {
+	Height       int64  `json:"height"`
+	Phase        string `json:"phase"`
+	AnswerHashes int    `json:"answer_hashes"`
+	ShortAnswers int    `json:"short_answers"`
+}
+
+// Report is the result of scanning a session.
+type Report struct {
+	Session
+	Blocks       []BlockCount `json:"blocks"`
+	AnswerHashes int          `json:"answer_hashes"`
+	ShortAnswers int          `json:"short_answers"`
+	// LastSubmission is the last block with a submission. If it is the last
+	// block of the session, submissions ran up to the window's end.
+	LastSubmission int64 `json:"last_submission"`
+	// Senders are the sorted, lower-cased senders of all submissions.
+	Senders []string `json:"senders"`
+}
+
+// Scan reads every block of the session window, from ShortStart up to but
+// excluding End, and collects the short answer submissions.
+func (s *Scanner) Scan(sess *Session) (*Report, error) {
+	rep := &Report{Session: *sess}
+	unique := make(map[string]struct{})
+	for h := sess.ShortStart; h < sess.End; h++ {
+		answers, err := s.Answers(h)
+		if err != nil {
+			return nil, err
+		}
+		c := BlockCount{Height: h, Phase: PhaseShort}
+		if h >= sess.LongStart {
+			c.Phase = PhaseLong
+		}
+		for _, tx := range answers {
+			if IsAnswerHashTx(tx.Type) {
+				c.AnswerHashes++
+			} else {
+				c.ShortAnswers++
+			}
+			unique[tx.From] = struct{}{}
+		}
+		if len(answers) > 0 {
+			rep.LastSubmission = h
+		}
+		rep.AnswerHashes += c.AnswerHashes
+		rep.ShortAnswers += c.ShortAnswers
+		rep.Blocks = append(rep.Blocks, c)
+	}
+	rep.Senders = make([]string, 0, len(unique))
+	for a := range unique {
+		rep.Senders = append(rep.Senders, a)
+	}
+	sort.Strings(rep.Senders)
+	return rep, nil
+}
+
+// ScanEpoch finds and scans the session that opened epoch, see FindSession.
+func (s *Scanner) ScanEpoch(epoch int) (*Report, error) {
+	sess, err := s.FindSession(epoch)
+	if err != nil {
+		return nil, err
+	}
+	return s.Scan(sess)
+}
+
+// Summary is a one-line description of the report for logs.
+func (r *Report) Summary() string {
+	return fmt.Sprintf("short session %d-%d, long session %d-%d (%s at %d), %d answer hashes, %d short answers, %d senders, last submission at %d",
+		r.ShortStart, r.LongStart-1, r.LongStart, r.End-1, r.EndFlag, r.End, r.AnswerHashes, r.ShortAnswers, len(r.Senders), r.LastSubmission)
+}
+
+type blockLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[int64]*list.Element
+}
+
+func newBlockLRU(size int) *blockLRU {
+	if size < 1 {
+		size = 1
+	}
+	return &blockLRU{size: size, order: list.New(), items: make(map[int64]*list.Element)}
+}
+
+func (c *blockLRU) get(height int64) (*cachedBlock, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[height]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedBlock), true
+}
+
+func (c *blockLRU) put(e *cachedBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.height]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[e.height] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*cachedBlock).height)
+	}
+}
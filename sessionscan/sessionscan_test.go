@@ -0,0 +1,151 @@
+package sessionscan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// fixtureNode answers dna_epoch, bcn_blockAt and bcn_transaction from a file
+// of node responses and counts the calls.
+type fixtureNode struct {
+	Epoch  json.RawMessage            `json:"dna_epoch"`
+	Blocks map[string]json.RawMessage `json:"bcn_blockAt"`
+	Txs    map[string]json.RawMessage `json:"bcn_transaction"`
+	calls  int
+}
+
+func loadFixture(t *testing.T, name string) *fixtureNode {
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	var n fixtureNode
+	if err := json.Unmarshal(b, &n); err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	return &n
+}
+
+func (n *fixtureNode) Call(method string, params interface{}, result interface{}) error {
+	n.calls++
+	var raw json.RawMessage
+	var ok bool
+	switch method {
+	case "dna_epoch":
+		raw, ok = n.Epoch, true
+	case "bcn_blockAt":
+		raw, ok = n.Blocks[strconv.FormatInt(params.([]interface{})[0].(int64), 10)]
+	case "bcn_transaction":
+		raw, ok = n.Txs[params.([]interface{})[0].(string)]
+	}
+	if !ok {
+		return fmt.Errorf("%s %v: not found", method, params)
+	}
+	return json.Unmarshal(raw, result)
+}
+
+func TestFindSession(t *testing.T) {
+	s := New(loadFixture(t, "session_164.json"), 100)
+	sess, err := s.FindSession(164)
+	if err != nil {
+		t.Fatalf("find session: %v", err)
+	}
+	want := Session{
+		Epoch:          164,
+		ShortStart:     9285951,
+		ShortStartHash: sess.ShortStartHash,
+		LongStart:      9285958,
+		End:            9285975,
+		EndFlag:        FlagAfterLongSessionStarted,
+	}
+	if *sess != want || sess.ShortStartHash == "" {
+		t.Fatalf("session %+v, want %+v", *sess, want)
+	}
+	if _, err := s.FindSession(165); err == nil {
+		t.Fatalf("expected error for an epoch the node is not in")
+	}
+	s.MaxScan = 10
+	if _, err := s.FindSession(164); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestScanSession(t *testing.T) {
+	node := loadFixture(t, "session_164.json")
+	s := New(node, 100)
+	rep, err := s.ScanEpoch(164)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	// the send and long answers transactions are ignored, the upper-case
+	// sender is merged, the late answers of 0x7e5f... are included and the
+	// hash submitted after the long session is not
+	want := []string{
+		"0x1f2e3d4c5b6a79880f1e2d3c4b5a69788f9e0d1c",
+		"0x4d8f1e26a9b3c0e7f51a2d6b8c3e9f0a1b2c3d4e",
+		"0x7e5f4552091a69125d5dfcb7b8c2659029395bdf",
+		"0x9a0b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b",
+		"0xc0ffee254729296a45a3885639ac7e10f9d54979",
+	}
+	if !reflect.DeepEqual(rep.Senders, want) {
+		t.Fatalf("senders %v, want %v", rep.Senders, want)
+	}
+	if rep.AnswerHashes != 4 || rep.ShortAnswers != 4 || rep.LastSubmission != 9285970 {
+		t.Fatalf("unexpected totals %+v", rep)
+	}
+	if len(rep.Blocks) != 9285975-9285951 {
+		t.Fatalf("expected one count per window block, got %d", len(rep.Blocks))
+	}
+	for _, c := range rep.Blocks {
+		phase := PhaseShort
+		if c.Height >= 9285958 {
+			phase = PhaseLong
+		}
+		if c.Phase != phase {
+			t.Fatalf("block %d in phase %s", c.Height, c.Phase)
+		}
+	}
+	if c := rep.Blocks[9285953-9285951]; c.AnswerHashes != 2 || c.ShortAnswers != 0 {
+		t.Fatalf("unexpected count %+v", c)
+	}
+
+	calls := node.calls
+	if _, err := s.ScanEpoch(164); err != nil {
+		t.Fatalf("scan again: %v", err)
+	}
+	// only dna_epoch is not cached
+	if node.calls != calls+1 {
+		t.Fatalf("cached scan hit the node %d times", node.calls-calls)
+	}
+}
+
+func TestScanMissingTransaction(t *testing.T) {
+	node := loadFixture(t, "session_164.json")
+	var blk Block
+	json.Unmarshal(node.Blocks["9285970"], &blk)
+	delete(node.Txs, blk.Transactions[0])
+	s := New(node, 100)
+	// a transaction of the window that cannot be read must fail the scan
+	// instead of being skipped
+	if _, err := s.ScanEpoch(164); err == nil {
+		t.Fatalf("expected error for a missing transaction")
+	}
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	node := loadFixture(t, "session_164.json")
+	s := New(node, 2)
+	for _, h := range []int64{9285951, 9285952, 9285953, 9285951} {
+		if _, err := s.Block(h); err != nil {
+			t.Fatalf("block %d: %v", h, err)
+		}
+	}
+	if node.calls != 4 {
+		t.Fatalf("expected the evicted block to be read again, got %d calls", node.calls)
+	}
+}
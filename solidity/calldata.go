@@ -0,0 +1,129 @@
+package solidity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"idenauthgo/merkle"
+)
+
+var (
+	uint256Type, _ = abi.NewType("uint256", "", nil)
+	addressType, _ = abi.NewType("address", "", nil)
+	bytes32Type, _ = abi.NewType("bytes32", "", nil)
+	proofType, _   = abi.NewType("bytes32[]", "", nil)
+)
+
+// Function signatures of the generated contracts.
+const (
+	PublishRootSignature  = "publishRoot(uint256,bytes32)"
+	VerifySignature       = "verify(uint256,address,bytes32[])"
+	LegacyVerifySignature = "verify(uint256,address,bytes32[],uint256)"
+)
+
+// Selector returns the 4-byte function selector of a signature.
+func Selector(signature string) []byte {
+	return crypto.Keccak256([]byte(signature))[:4]
+}
+
+// Bytes32 decodes a root or proof hash, with or without 0x prefix.
+func Bytes32(h string) ([32]byte, error) {
+	var out [32]byte
+	b, err := hex.DecodeString(strings.TrimPrefix(h, "0x"))
+	if err != nil {
+		return out, err
+	}
+	if len(b) != 32 {
+		return out, fmt.Errorf("hash %q has %d bytes", h, len(b))
+	}
+	copy(out[:], b)
+	return out, nil
+}
+
+// proofArgs splits proof steps into the bytes32[] argument and, for the
+// legacy scheme, the left-sibling bit mask.
+func proofArgs(scheme string, proof []merkle.ProofStep) ([][32]byte, *big.Int, error) {
+	if _, ok := schemeCodes[scheme]; !ok {
+		return nil, nil, fmt.Errorf("unknown merkle scheme %q", scheme)
+	}
+	if len(proof) > 256 {
+		return nil, nil, fmt.Errorf("proof of %d steps is too long", len(proof))
+	}
+	hashes := make([][32]byte, len(proof))
+	mask := new(big.Int)
+	for i, step := range proof {
+		h, err := Bytes32(step.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = h
+		if step.Left {
+			mask.SetBit(mask, i, 1)
+		}
+	}
+	return hashes, mask, nil
+}
+
+func proofArguments(scheme string) abi.Arguments {
+	args := abi.Arguments{{Type: proofType}}
+	if scheme == merkle.Legacy {
+		args = append(args, abi.Argument{Type: uint256Type})
+	}
+	return args
+}
+
+// EncodeProof ABI-encodes the proof arguments of verify: bytes32[] for the
+// keccak schemes and (bytes32[], uint256 leftMask) for sha256-legacy.
+func EncodeProof(scheme string, proof []merkle.ProofStep) ([]byte, error) {
+	hashes, mask, err := proofArgs(scheme, proof)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == merkle.Legacy {
+		return proofArguments(scheme).Pack(hashes, mask)
+	}
+	return proofArguments(scheme).Pack(hashes)
+}
+
+// VerifyCalldata returns the complete calldata of verify(epoch, account,
+// proof...) on the generated contract.
+func VerifyCalldata(scheme string, epoch int, account string, proof []merkle.ProofStep) ([]byte, error) {
+	if !common.IsHexAddress(account) {
+		return nil, fmt.Errorf("invalid address %q", account)
+	}
+	hashes, mask, err := proofArgs(scheme, proof)
+	if err != nil {
+		return nil, err
+	}
+	args := append(abi.Arguments{{Type: uint256Type}, {Type: addressType}}, proofArguments(scheme)...)
+	values := []interface{}{big.NewInt(int64(epoch)), common.HexToAddress(account), hashes}
+	sig := VerifySignature
+	if scheme == merkle.Legacy {
+		values = append(values, mask)
+		sig = LegacyVerifySignature
+	}
+	packed, err := args.Pack(values...)
+	if err != nil {
+		return nil, err
+	}
+	return append(Selector(sig), packed...), nil
+}
+
+// PublishRootCalldata returns the calldata of publishRoot(epoch, root).
+func PublishRootCalldata(epoch int, root string) ([]byte, error) {
+	r, err := Bytes32(root)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := abi.Arguments{{Type: uint256Type}, {Type: bytes32Type}}.Pack(big.NewInt(int64(epoch)), r)
+	if err != nil {
+		return nil, err
+	}
+	return append(Selector(PublishRootSignature), packed...), nil
+}
@@ -0,0 +1,155 @@
+// Package solidity generates on-chain verifier contracts for whitelist Merkle
+// roots and encodes proofs as calldata for them.
+//
+// Every generated contract stores one root per epoch, published by its owner
+// with publishRoot(uint256,bytes32), and exposes verify(). For the keccak
+// schemes verify takes (uint256 epoch, address account, bytes32[] proof); the
+// sha256-legacy tree hashes siblings in position order, so its verify takes
+// an additional uint256 whose bit i is set when proof[i] is a left sibling.
+package solidity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"idenauthgo/merkle"
+)
+
+// DefaultContractName is used when Options.ContractName is empty.
+const DefaultContractName = "IdenaWhitelistVerifier"
+
+// Options customize the generated contract.
+type Options struct {
+	ContractName string
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type schemeCode struct {
+	Leaf       string
+	VerifyArgs string
+	Fold       string
+	Helpers    string
+}
+
+const hashPair = `
+    function _hashPair(bytes32 a, bytes32 b) private pure returns (bytes32) {
+        return a < b ? keccak256(abi.encodePacked(a, b)) : keccak256(abi.encodePacked(b, a));
+    }
+`
+
+var schemeCodes = map[string]schemeCode{
+	merkle.Legacy: {
+		Leaf:       `sha256(bytes(_toLowerHex(account)))`,
+		VerifyArgs: `bytes32[] calldata proof, uint256 leftMask`,
+		Fold: `if ((leftMask >> i) & 1 == 1) {
+                node = sha256(abi.encodePacked(proof[i], node));
+            } else {
+                node = sha256(abi.encodePacked(node, proof[i]));
+            }`,
+		Helpers: `
+    // _toLowerHex renders account as the lowercase 0x-prefixed string the
+    // whitelist hashes.
+    function _toLowerHex(address account) private pure returns (string memory) {
+        bytes memory s = new bytes(42);
+        bytes16 digits = "0123456789abcdef";
+        uint160 v = uint160(account);
+        s[0] = "0";
+        s[1] = "x";
+        for (uint256 i = 41; i > 1; i--) {
+            s[i] = digits[v & 0xf];
+            v >>= 4;
+        }
+        return string(s);
+    }
+`,
+	},
+	merkle.KeccakSorted: {
+		Leaf:       `keccak256(abi.encodePacked(account))`,
+		VerifyArgs: `bytes32[] calldata proof`,
+		Fold:       `node = _hashPair(node, proof[i]);`,
+		Helpers:    hashPair,
+	},
+	merkle.Standard: {
+		Leaf:       `keccak256(bytes.concat(keccak256(abi.encode(account, epoch))))`,
+		VerifyArgs: `bytes32[] calldata proof`,
+		Fold:       `node = _hashPair(node, proof[i]);`,
+		Helpers:    hashPair,
+	},
+}
+
+var contractTmpl = template.Must(template.New("contract").Parse(`// SPDX-License-Identifier: MIT
+// Generated by IdenaAuthGo contractgen for the {{.Scheme}} Merkle scheme.
+pragma solidity ^0.8.20;
+
+contract {{.Name}} {
+    string public constant SCHEME = "{{.Scheme}}";
+
+    address public owner;
+    mapping(uint256 => bytes32) public roots;
+
+    event RootPublished(uint256 indexed epoch, bytes32 root);
+    event OwnershipTransferred(address indexed previousOwner, address indexed newOwner);
+
+    constructor() {
+        owner = msg.sender;
+    }
+
+    modifier onlyOwner() {
+        require(msg.sender == owner, "not owner");
+        _;
+    }
+
+    function transferOwnership(address newOwner) external onlyOwner {
+        require(newOwner != address(0), "zero owner");
+        emit OwnershipTransferred(owner, newOwner);
+        owner = newOwner;
+    }
+
+    function publishRoot(uint256 epoch, bytes32 root) external onlyOwner {
+        roots[epoch] = root;
+        emit RootPublished(epoch, root);
+    }
+
+    function leaf(uint256 epoch, address account) public pure returns (bytes32) {
+        epoch; // not hashed by every scheme
+        return {{.Code.Leaf}};
+    }
+
+    function verify(uint256 epoch, address account, {{.Code.VerifyArgs}}) external view returns (bool) {
+        bytes32 root = roots[epoch];
+        if (root == bytes32(0)) {
+            return false;
+        }
+        bytes32 node = leaf(epoch, account);
+        for (uint256 i = 0; i < proof.length; i++) {
+            {{.Code.Fold}}
+        }
+        return node == root;
+    }
+{{.Code.Helpers}}}
+`))
+
+// Generate returns the source of a verifier contract for scheme.
+func Generate(scheme string, opts Options) (string, error) {
+	code, ok := schemeCodes[scheme]
+	if !ok {
+		return "", fmt.Errorf("unknown merkle scheme %q", scheme)
+	}
+	name := opts.ContractName
+	if name == "" {
+		name = DefaultContractName
+	}
+	if !identifier.MatchString(name) {
+		return "", fmt.Errorf("invalid contract name %q", name)
+	}
+	var b strings.Builder
+	err := contractTmpl.Execute(&b, struct {
+		Name   string
+		Scheme string
+		Code   schemeCode
+	}{name, scheme, code})
+	return b.String(), err
+}
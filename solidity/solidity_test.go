@@ -0,0 +1,181 @@
+package solidity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"idenauthgo/merkle"
+)
+
+// contract is a Go model of the generated verifier: it decodes verify()
+// calldata and replays the Solidity code of each scheme statement by
+// statement, without using the merkle package.
+type contract struct {
+	scheme string
+	roots  map[uint64][32]byte
+}
+
+func (c *contract) call(t *testing.T, data []byte) bool {
+	t.Helper()
+	sig := VerifySignature
+	if c.scheme == merkle.Legacy {
+		sig = LegacyVerifySignature
+	}
+	if !bytes.Equal(data[:4], Selector(sig)) {
+		t.Fatalf("selector %x does not match %s", data[:4], sig)
+	}
+	args := append(abi.Arguments{{Type: uint256Type}, {Type: addressType}}, proofArguments(c.scheme)...)
+	vals, err := args.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	epoch := vals[0].(*big.Int)
+	account := vals[1].(common.Address)
+	proof := vals[2].([][32]byte)
+
+	root, ok := c.roots[epoch.Uint64()]
+	if !ok {
+		return false
+	}
+	var node []byte
+	switch c.scheme {
+	case merkle.Legacy:
+		// sha256(bytes(_toLowerHex(account)))
+		h := sha256.Sum256([]byte(strings.ToLower(account.Hex())))
+		node = h[:]
+		mask := vals[3].(*big.Int)
+		for i, p := range proof {
+			var h [32]byte
+			if mask.Bit(i) == 1 {
+				h = sha256.Sum256(append(p[:], node...))
+			} else {
+				h = sha256.Sum256(append(append([]byte(nil), node...), p[:]...))
+			}
+			node = h[:]
+		}
+	case merkle.KeccakSorted, merkle.Standard:
+		if c.scheme == merkle.KeccakSorted {
+			node = crypto.Keccak256(account.Bytes())
+		} else {
+			enc := append(common.LeftPadBytes(account.Bytes(), 32), common.LeftPadBytes(epoch.Bytes(), 32)...)
+			node = crypto.Keccak256(crypto.Keccak256(enc))
+		}
+		for _, p := range proof {
+			if bytes.Compare(node, p[:]) < 0 {
+				node = crypto.Keccak256(node, p[:])
+			} else {
+				node = crypto.Keccak256(p[:], node)
+			}
+		}
+	}
+	return bytes.Equal(node, root[:])
+}
+
+func (c *contract) publish(t *testing.T, data []byte) {
+	t.Helper()
+	if !bytes.Equal(data[:4], Selector(PublishRootSignature)) {
+		t.Fatalf("publishRoot selector mismatch")
+	}
+	vals, err := abi.Arguments{{Type: uint256Type}, {Type: bytes32Type}}.Unpack(data[4:])
+	if err != nil {
+		t.Fatalf("unpack publishRoot: %v", err)
+	}
+	c.roots[vals[0].(*big.Int).Uint64()] = vals[1].([32]byte)
+}
+
+func testAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("0x%040x", (i+1)*0x1f3)
+	}
+	return addrs
+}
+
+func TestCalldataVerifiesAgainstContractModel(t *testing.T) {
+	for _, name := range merkle.Names() {
+		s, _ := merkle.Get(name)
+		for n := 1; n <= 7; n++ {
+			addrs := testAddrs(n)
+			tree, err := merkle.Build(s, addrs, 12)
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			c := &contract{scheme: name, roots: map[uint64][32]byte{}}
+			publish, err := PublishRootCalldata(12, tree.Root())
+			if err != nil {
+				t.Fatalf("%s publish calldata: %v", name, err)
+			}
+			c.publish(t, publish)
+			for _, a := range addrs {
+				proof, _ := tree.Proof(a)
+				data, err := VerifyCalldata(name, 12, a, proof)
+				if err != nil {
+					t.Fatalf("%s calldata: %v", name, err)
+				}
+				if !c.call(t, data) {
+					t.Fatalf("%s: contract rejects proof for %s in %d leaves", name, a, n)
+				}
+				// the same proof must fail for another epoch or account
+				other, _ := VerifyCalldata(name, 13, a, proof)
+				if c.call(t, other) {
+					t.Fatalf("%s: proof accepted for unpublished epoch", name)
+				}
+				if n > 1 {
+					wrong, _ := VerifyCalldata(name, 12, "0x00000000000000000000000000000000000000ff", proof)
+					if c.call(t, wrong) {
+						t.Fatalf("%s: proof accepted for another account", name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeProofMatchesVerifyArguments(t *testing.T) {
+	tree, _ := merkle.Build(merkle.MustGet(merkle.Legacy), testAddrs(5), 0)
+	proof, _ := tree.Proof(testAddrs(5)[4])
+	enc, err := EncodeProof(merkle.Legacy, proof)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	call, _ := VerifyCalldata(merkle.Legacy, 0, testAddrs(5)[4], proof)
+	// calldata = selector, epoch, account, then the proof arguments with
+	// offsets shifted by the two leading words
+	vals, err := proofArguments(merkle.Legacy).Unpack(enc)
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if len(vals[0].([][32]byte)) != len(proof) || len(call) != 4+64+len(enc) {
+		t.Fatalf("unexpected encoding lengths %d %d", len(enc), len(call))
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, name := range merkle.Names() {
+		src, err := Generate(name, Options{ContractName: "Airdrop"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := "function verify(uint256 epoch, address account, bytes32[] calldata proof"
+		if !strings.Contains(src, "contract Airdrop {") || !strings.Contains(src, want) || !strings.Contains(src, `SCHEME = "`+name+`"`) {
+			t.Fatalf("%s: unexpected source\n%s", name, src)
+		}
+		if strings.Count(src, "{") != strings.Count(src, "}") {
+			t.Fatalf("%s: unbalanced braces", name)
+		}
+	}
+	if _, err := Generate("md5", Options{}); err == nil {
+		t.Fatalf("expected error for unknown scheme")
+	}
+	if _, err := Generate(merkle.Standard, Options{ContractName: "bad name"}); err == nil {
+		t.Fatalf("expected error for invalid contract name")
+	}
+}
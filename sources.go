@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"idenauthgo/datasource"
+	"idenauthgo/sessionscan"
+)
+
+// INDEXER_URL optionally points at a rolling indexer that is consulted after
+// the local node and before the public API.
+var INDEXER_URL = getenv("INDEXER_URL", "")
+
+// SESSION_BLOCK_CACHE is the number of blocks kept by the session scanner.
+var SESSION_BLOCK_CACHE = getenvInt("SESSION_BLOCK_CACHE", 2048)
+
+var (
+	nodeSource     = datasource.NewNodeSource(idenaRpcUrl, IDENA_RPC_KEY)
+	apiSource      = datasource.NewAPISource(fallbackApiUrl, "")
+	identitySource = newIdentitySource()
+	sessionScan    = sessionscan.New(nodeSource, SESSION_BLOCK_CACHE)
+)
+
+// newIdentitySource builds the failover chain: local node, optional indexer,
+// public API.
+func newIdentitySource() *datasource.Composite {
+	sources := []datasource.IdentitySource{nodeSource}
+	if INDEXER_URL != "" {
+		sources = append(sources, datasource.NewIndexerSource(INDEXER_URL))
+	}
+	sources = append(sources, apiSource)
+	return datasource.NewComposite(sources...)
+}
+
+// epochIdentityPagers returns the sources of full epoch identity lists in
+// the failover order of identitySource.
+func epochIdentityPagers() []datasource.EpochIdentityPager {
+	pagers := []datasource.EpochIdentityPager{nodeSource}
+	if INDEXER_URL != "" {
+		pagers = append(pagers, datasource.NewIndexerSource(INDEXER_URL))
+	}
+	return append(pagers, apiSource)
+}
+
+// sourcesHealthHandler reports the health of each identity data source.
+func sourcesHealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"source":  identitySource.Name(),
+		"sources": identitySource.Health(),
+	})
+}
@@ -2,8 +2,9 @@ package whitelist
 
 import (
 	"encoding/json"
-	"os"
 	"sort"
+
+	"idenauthgo/artifacts"
 )
 
 // BuildWhitelist converts the status map into a sorted address slice.
@@ -16,12 +17,14 @@ func BuildWhitelist(status map[string]string) []string {
 	return list
 }
 
-// SaveWhitelist writes the whitelist to disk as JSON.
+// SaveWhitelist writes the whitelist to disk as JSON through the artifact
+// store.
 func SaveWhitelist(addrs []string, path string) error {
 	sort.Strings(addrs)
 	b, err := json.MarshalIndent(addrs, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, b, 0644)
+	_, err = artifacts.Default().Write(path, b, 0644)
+	return err
 }
@@ -1,9 +1,9 @@
 package whitelist
 
 import (
-	"bytes"
-	"encoding/json"
-	"net/http"
+	"strconv"
+
+	"idenauthgo/datasource"
 )
 
 type identityResp struct {
@@ -14,30 +14,14 @@ type identityResp struct {
 }
 
 func fetchIdentity(addr string, epoch int, url, key string) (*identityResp, error) {
-	params := []interface{}{addr}
-	if epoch > 0 {
-		params = append(params, epoch)
-	}
-	req := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "dna_identity",
-		"params":  params,
-		"id":      1,
-	}
-	if key != "" {
-		req["key"] = key
-	}
-	body, _ := json.Marshal(req)
-	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	id, err := datasource.NewNodeSource(url, key).IdentityAt(addr, epoch)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	var out struct {
-		Result identityResp `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
-	}
-	return &out.Result, nil
+	return &identityResp{
+		State:               id.State,
+		Stake:               strconv.FormatFloat(id.Stake, 'f', -1, 64),
+		Penalty:             id.Penalty,
+		LastValidationFlags: id.Flags,
+	}, nil
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"idenauthgo/eligibility"
+)
+
+// The whitelist endpoints read an epoch's list from epoch_identity_snapshot
+// in address order, so pages and downloads are streamed from SQLite instead
+// of loading the whole list. Epochs built before the identity records were
+// kept are served from their whitelist file.
+
+var whitelistPageMax = getenvInt("WHITELIST_PAGE_MAX", 10000)
+
+// whitelistEntry is one whitelisted address. State and stake are empty for
+// epochs served from a whitelist file.
+type whitelistEntry struct {
+	Address string  `json:"address"`
+	State   string  `json:"state,omitempty"`
+	Stake   float64 `json:"stake,omitempty"`
+}
+
+// epochWhitelist iterates the whitelist of one epoch.
+type epochWhitelist struct {
+	epoch     int
+	threshold float64
+	root      string
+	scheme    string
+	// version changes whenever the list may change and keys the ETag.
+	version string
+	// file holds the sorted addresses of an epoch without identity records.
+	file []string
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+// openEpochWhitelist prepares the whitelist of epoch for iteration.
+func openEpochWhitelist(epoch int) (*epochWhitelist, error) {
+	var one int
+	err := db.QueryRow(`SELECT 1 FROM epoch_identity_snapshot WHERE epoch=? LIMIT 1`, epoch).Scan(&one)
+	if err == sql.ErrNoRows {
+		list, root, scheme, err := loadWhitelistData(epoch)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d", errNoEpochData, epoch)
+		}
+		file := make([]string, len(list))
+		for i, a := range list {
+			file[i] = strings.ToLower(a)
+		}
+		sort.Strings(file)
+		return &epochWhitelist{epoch: epoch, root: root, scheme: scheme, version: "file|" + root + "|" + strconv.Itoa(len(file)), file: file}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the root and scheme are the ones the epoch was built with, which may
+	// differ from the configured scheme
+	wl := &epochWhitelist{epoch: epoch, threshold: epochThreshold(epoch), scheme: merkleScheme}
+	input := ""
+	if m, err := loadManifest(epoch); err == nil {
+		input = m.InputHash
+		if m.MerkleScheme != "" {
+			wl.root, wl.scheme = m.MerkleRoot, m.MerkleScheme
+		}
+	} else {
+		snaps, err := loadEpochSnapshots(db, epoch)
+		if err != nil {
+			return nil, err
+		}
+		input = inputSetHash(snaps)
+	}
+	if wl.root == "" {
+		wl.root, _ = getMerkleRoot(epoch, wl.scheme)
+	}
+	wl.version = fmt.Sprintf("db|%s|%g|%s", input, wl.threshold, eligibility.RuleSetHash())
+	return wl, nil
+}
+
+// each calls fn for every whitelisted address after the cursor, in address
+// order, until fn returns errStopIteration.
+func (wl *epochWhitelist) each(after string, fn func(whitelistEntry) error) error {
+	if wl.file != nil {
+		for _, a := range wl.file[sort.SearchStrings(wl.file, after):] {
+			if a == after {
+				continue
+			}
+			if err := fn(whitelistEntry{Address: a}); err != nil {
+				return stopped(err)
+			}
+		}
+		return nil
+	}
+	rows, err := db.Query(`SELECT address, state, stake FROM epoch_identity_snapshot
+        WHERE epoch=? AND address>? AND penalized=0 AND flipReported=0 ORDER BY address`, wl.epoch, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e whitelistEntry
+		if err := rows.Scan(&e.Address, &e.State, &e.Stake); err != nil {
+			return err
+		}
+		if !eligibility.IsEligibleSnapshot(e.State, e.Stake, wl.threshold) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return stopped(err)
+		}
+	}
+	return rows.Err()
+}
+
+func stopped(err error) error {
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// page returns up to limit entries after the cursor and the cursor of the
+// next page, empty on the last one.
+func (wl *epochWhitelist) page(after string, limit int) ([]whitelistEntry, string, error) {
+	var entries []whitelistEntry
+	more := false
+	err := wl.each(after, func(e whitelistEntry) error {
+		if len(entries) == limit {
+			more = true
+			return errStopIteration
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil || !more {
+		return entries, "", err
+	}
+	return entries, entries[len(entries)-1].Address, nil
+}
+
+// whitelistETag identifies a response by the list version and the request
+// parameters that shape it. It is weak since gzip changes the bytes.
+func whitelistETag(wl *epochWhitelist, format, cursor string, limit int) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s|%d", wl.epoch, wl.version, format, cursor, limit)))
+	return `W/"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header covers etag.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client accepts a gzip encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			return err == nil && v > 0
+		}
+		return true
+	}
+	return false
+}
+
+// compressResponse returns the writer for the response body, gzip encoded
+// when the client accepts it, and a function that finishes the body.
+func compressResponse(w http.ResponseWriter, r *http.Request) (io.Writer, func()) {
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	gz := gzip.NewWriter(w)
+	return gz, func() { gz.Close() }
+}
+
+// serveWhitelist writes the whitelist of epoch. Query parameters:
+//
+//	format  json (default), ndjson or csv
+//	limit   page size, at most WHITELIST_PAGE_MAX; without it the whole list
+//	        is streamed
+//	cursor  next_cursor of the previous page
+//
+// The JSON document carries the next cursor as next_cursor, all formats in
+// the X-Next-Cursor header. A stream that fails midway is aborted rather
+// than closed, so clients see a broken response instead of a short list.
+func serveWhitelist(w http.ResponseWriter, r *http.Request, epoch int) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "ndjson" && format != "csv" {
+		http.Error(w, "format must be json, ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > whitelistPageMax {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", whitelistPageMax), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	cursor := strings.ToLower(q.Get("cursor"))
+
+	wl, err := openEpochWhitelist(epoch)
+	if errors.Is(err, errNoEpochData) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[WHITELIST] epoch %d: %v", epoch, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	etag := whitelistETag(wl, format, cursor, limit)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// a page is read before writing so its cursor can go into the headers
+	var page []whitelistEntry
+	next := ""
+	if limit > 0 {
+		if page, next, err = wl.page(cursor, limit); err != nil {
+			log.Printf("[WHITELIST] epoch %d: %v", epoch, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+	}
+	each := func(fn func(whitelistEntry) error) error {
+		if limit == 0 {
+			return wl.each(cursor, fn)
+		}
+		for _, e := range page {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	name := fmt.Sprintf("whitelist_epoch_%d", epoch)
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.ndjson"`)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	out, finish := compressResponse(w, r)
+	defer finish()
+
+	switch format {
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		err = each(func(e whitelistEntry) error { return enc.Encode(e) })
+	case "csv":
+		cw := csv.NewWriter(out)
+		cw.Write([]string{"address", "state", "stake"})
+		err = each(func(e whitelistEntry) error {
+			stake := ""
+			if e.State != "" {
+				stake = strconv.FormatFloat(e.Stake, 'f', -1, 64)
+			}
+			return cw.Write([]string{e.Address, e.State, stake})
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	default:
+		head, _ := json.Marshal(map[string]interface{}{
+			"epoch":         epoch,
+			"merkle_root":   wl.root,
+			"merkle_scheme": wl.scheme,
+		})
+		// reopen the header object to append the addresses array
+		if _, err := out.Write(append(head[:len(head)-1], `,"addresses":[`...)); err != nil {
+			return
+		}
+		first := true
+		err = each(func(e whitelistEntry) error {
+			b, _ := json.Marshal(e.Address)
+			if !first {
+				b = append([]byte{','}, b...)
+			}
+			first = false
+			_, err := out.Write(b)
+			return err
+		})
+		if err == nil {
+			tail, _ := json.Marshal(next)
+			_, err = out.Write(append(append([]byte(`],"next_cursor":`), tail...), '}', '\n'))
+		}
+	}
+	if err != nil {
+		// the status is already sent; cut the connection so the client
+		// cannot take the truncated list for a complete one
+		log.Printf("[WHITELIST] epoch %d: %v", epoch, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"idenauthgo/merkle"
+)
+
+type whitelistPageResponse struct {
+	Epoch      int      `json:"epoch"`
+	MerkleRoot string   `json:"merkle_root"`
+	Scheme     string   `json:"merkle_scheme"`
+	Addresses  []string `json:"addresses"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+func setupWhitelistAPI(t *testing.T) {
+	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = oldDir })
+	snaps := []EpochSnapshot{
+		{Address: "0xaaa", State: "Human", Stake: 15000},
+		{Address: "0xbbb", State: "Verified", Stake: 10000},
+		{Address: "0xccc", State: "Human", Stake: 1000},
+		{Address: "0xddd", State: "Newbie", Stake: 20000},
+		{Address: "0xeee", State: "Human", Stake: 20000, FlipReported: true},
+		{Address: "0xfff", State: "Human", Stake: 13000},
+	}
+	if _, _, err := finishEpochWhitelist(30, 12000, manifestSourceNode, "", blockRef{}, snaps); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+}
+
+func TestWhitelistEpochPagination(t *testing.T) {
+	setupWhitelistAPI(t)
+
+	var got []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30?limit=2&cursor="+cursor, nil))
+		var page whitelistPageResponse
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &page) != nil {
+			t.Fatalf("page %d: %d %s", i, rr.Code, rr.Body.String())
+		}
+		if page.Epoch != 30 || page.MerkleRoot == "" || rr.Header().Get("X-Next-Cursor") != page.NextCursor {
+			t.Fatalf("unexpected page %+v", page)
+		}
+		got = append(got, page.Addresses...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	want := []string{"0xaaa", "0xbbb", "0xddd", "0xfff"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30?limit=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for limit=0, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/31", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestWhitelistCurrentETagAndGzip(t *testing.T) {
+	setupWhitelistAPI(t)
+	oldEpoch := currentEpoch
+	currentEpoch = 30
+	defer func() { currentEpoch = oldEpoch }()
+
+	req := httptest.NewRequest(http.MethodGet, "/whitelist/current", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	whitelistCurrentHandler(rr, req)
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	var page whitelistPageResponse
+	if err := json.NewDecoder(zr).Decode(&page); err != nil || len(page.Addresses) != 4 || page.NextCursor != "" {
+		t.Fatalf("decode %v: %+v", err, page)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/whitelist/current", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	whitelistCurrentHandler(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", rr.Code)
+	}
+
+	// a rebuild with other identities changes the ETag
+	if _, _, err := finishEpochWhitelist(30, 12000, manifestSourceNode, "", blockRef{}, []EpochSnapshot{{Address: "0xaaa", State: "Human", Stake: 15000}}); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	whitelistCurrentHandler(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("expected new content, got %d", rr.Code)
+	}
+}
+
+func TestWhitelistStreamFormats(t *testing.T) {
+	setupWhitelistAPI(t)
+
+	rr := httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30?format=ndjson", nil))
+	sc := bufio.NewScanner(rr.Body)
+	var lines []whitelistEntry
+	for sc.Scan() {
+		var e whitelistEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("ndjson line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, e)
+	}
+	if len(lines) != 4 || lines[0].Address != "0xaaa" || lines[0].State != "Human" || lines[0].Stake != 15000 {
+		t.Fatalf("unexpected ndjson %+v", lines)
+	}
+
+	rr = httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30?format=csv&limit=3", nil))
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(rows) != 4 || rows[0][0] != "address" || rows[3][0] != "0xddd" || rr.Header().Get("X-Next-Cursor") != "0xddd" {
+		t.Fatalf("unexpected csv %v %v", rows, err)
+	}
+}
+
+// failingWriter accepts ok writes and fails every later one.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	ok int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if f.ok == 0 {
+		return 0, errors.New("connection reset")
+	}
+	f.ok--
+	return f.ResponseRecorder.Write(b)
+}
+
+func TestWhitelistStreamAbortsOnError(t *testing.T) {
+	setupWhitelistAPI(t)
+	// the CSV writer buffers the whole list and writes it on flush
+	for format, ok := range map[string]int{"json": 1, "ndjson": 1, "csv": 0} {
+		w := &failingWriter{ResponseRecorder: httptest.NewRecorder(), ok: ok}
+		func() {
+			defer func() {
+				if rec := recover(); rec != http.ErrAbortHandler {
+					t.Fatalf("%s: expected the handler to abort, got %v", format, rec)
+				}
+			}()
+			whitelistEpochHandler(w, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30?format="+format, nil))
+		}()
+		if format == "json" && strings.HasSuffix(strings.TrimSpace(w.Body.String()), "}") {
+			t.Fatalf("truncated JSON document was closed: %s", w.Body.String())
+		}
+	}
+}
+
+func TestWhitelistEpochFromFile(t *testing.T) {
+	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+
+	// epochs built before identity records were kept only have a file
+	if err := writeWhitelistFile(40, []string{"0xBBB", "0xaaa"}, "root", "sha256-legacy"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/40", nil))
+	var page whitelistPageResponse
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &page) != nil {
+		t.Fatalf("response %d %s", rr.Code, rr.Body.String())
+	}
+	if page.MerkleRoot != "root" || len(page.Addresses) != 2 || page.Addresses[0] != "0xaaa" || page.Addresses[1] != "0xbbb" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+}
+
+func TestWhitelistEpochUsesBuildScheme(t *testing.T) {
+	setupWhitelistAPI(t)
+	m, err := loadManifest(30)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	saved := merkleScheme
+	merkleScheme = merkle.KeccakSorted
+	defer func() { merkleScheme = saved }()
+
+	// the epoch was built under the legacy scheme before the switch
+	rr := httptest.NewRecorder()
+	whitelistEpochHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/epoch/30", nil))
+	var page whitelistPageResponse
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &page) != nil {
+		t.Fatalf("response %d %s", rr.Code, rr.Body.String())
+	}
+	if page.Scheme != m.MerkleScheme || page.MerkleRoot != m.MerkleRoot || page.MerkleRoot == "" {
+		t.Fatalf("page %s/%s, manifest %s/%s", page.Scheme, page.MerkleRoot, m.MerkleScheme, m.MerkleRoot)
+	}
+}
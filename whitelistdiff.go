@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"idenauthgo/eligibility"
+)
+
+// Whitelist diffs explain membership changes between two epochs from the
+// identity records kept in epoch_identity_snapshot.
+
+// Reason codes of a diff entry. Removals name what now keeps an address off
+// the list, additions what kept it off before.
+const (
+	reasonMissing        = "missing_from_snapshot"
+	reasonNew            = "new_in_snapshot"
+	reasonState          = "state_transition"
+	reasonStakeBelow     = "stake_below_threshold"
+	reasonStakeReached   = "stake_reached_threshold"
+	reasonPenalty        = "new_penalty"
+	reasonPenaltyCleared = "penalty_cleared"
+	reasonFlip           = "flip_report"
+	reasonFlipCleared    = "flip_report_cleared"
+	reasonUnknown        = "unknown"
+)
+
+var errNoEpochData = errors.New("no whitelist or snapshot for epoch")
+
+type diffReason struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// diffRecord is an identity record of one side of the diff.
+type diffRecord struct {
+	State        string  `json:"state"`
+	Stake        float64 `json:"stake"`
+	Penalized    bool    `json:"penalized"`
+	FlipReported bool    `json:"flip_reported"`
+}
+
+type diffEntry struct {
+	Address string       `json:"address"`
+	Change  string       `json:"change"`
+	Reasons []diffReason `json:"reasons"`
+	From    *diffRecord  `json:"from"`
+	To      *diffRecord  `json:"to"`
+}
+
+type whitelistDiff struct {
+	From          int         `json:"from"`
+	To            int         `json:"to"`
+	FromThreshold float64     `json:"from_threshold"`
+	ToThreshold   float64     `json:"to_threshold"`
+	Added         []diffEntry `json:"added"`
+	Removed       []diffEntry `json:"removed"`
+}
+
+// diffEpoch is the whitelist and identity records of one epoch.
+type diffEpoch struct {
+	list      []string
+	records   map[string]*diffRecord
+	threshold float64
+}
+
+// epochThreshold returns the stake threshold a whitelist of epoch was built
+// with: the manifest's, else the cached epoch info, else the current one.
+func epochThreshold(epoch int) float64 {
+	if m, err := loadManifest(epoch); err == nil {
+		return m.Threshold
+	}
+	var thr float64
+	err := db.QueryRow(`SELECT discriminationStakeThreshold FROM epoch WHERE epoch=? ORDER BY ts DESC LIMIT 1`, epoch).Scan(&thr)
+	if err == nil {
+		return thr
+	}
+	return stakeThreshold
+}
+
+// loadDiffEpoch reads the published whitelist of epoch, falling back to the
+// list derived from its identity records.
+func loadDiffEpoch(epoch int) (*diffEpoch, error) {
+	snaps, err := loadEpochSnapshots(db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	e := &diffEpoch{records: make(map[string]*diffRecord, len(snaps)), threshold: epochThreshold(epoch)}
+	for _, s := range snaps {
+		e.records[s.Address] = &diffRecord{State: s.State, Stake: s.Stake, Penalized: s.Penalized, FlipReported: s.FlipReported}
+	}
+	if list, _, _, err := loadWhitelistData(epoch); err == nil {
+		e.list = list
+	} else if len(snaps) > 0 {
+		e.list = eligibleAddresses(snaps, e.threshold)
+	} else {
+		return nil, fmt.Errorf("%w %d", errNoEpochData, epoch)
+	}
+	return e, nil
+}
+
+// blockers returns what keeps rec off a whitelist with the given threshold,
+// as codes of a removal.
+func blockers(rec *diffRecord, threshold float64) []string {
+	if rec == nil {
+		return []string{reasonMissing}
+	}
+	var codes []string
+	if need, ok := eligibility.MinStake(rec.State, threshold); !ok {
+		codes = append(codes, reasonState)
+	} else if rec.Stake < need {
+		codes = append(codes, reasonStakeBelow)
+	}
+	if rec.Penalized {
+		codes = append(codes, reasonPenalty)
+	}
+	if rec.FlipReported {
+		codes = append(codes, reasonFlip)
+	}
+	return codes
+}
+
+func stateOf(rec *diffRecord) string {
+	if rec == nil {
+		return "unknown"
+	}
+	return rec.State
+}
+
+// stakeReason describes a stake change against what cur's state requires.
+func stakeReason(code string, prev, cur *diffRecord, threshold float64) diffReason {
+	need, _ := eligibility.MinStake(cur.State, threshold)
+	stake := fmt.Sprintf("stake %g", cur.Stake)
+	if prev != nil {
+		stake = fmt.Sprintf("stake %g -> %g", prev.Stake, cur.Stake)
+	}
+	return diffReason{code, fmt.Sprintf("%s, %s requires %g", stake, cur.State, need)}
+}
+
+// removalReasons explains why addr left the list between from and to.
+func removalReasons(from, to *diffEpoch, addr string) []diffReason {
+	prev, cur := from.records[addr], to.records[addr]
+	var reasons []diffReason
+	for _, code := range blockers(cur, to.threshold) {
+		switch code {
+		case reasonMissing:
+			reasons = append(reasons, diffReason{code, "no identity record in the snapshot"})
+		case reasonState:
+			reasons = append(reasons, diffReason{code, stateOf(prev) + " -> " + cur.State})
+		case reasonStakeBelow:
+			reasons = append(reasons, stakeReason(code, prev, cur, to.threshold))
+		case reasonPenalty:
+			reasons = append(reasons, diffReason{code, "penalized in the validation"})
+		case reasonFlip:
+			reasons = append(reasons, diffReason{code, "reported for a flip"})
+		}
+	}
+	return reasons
+}
+
+// additionReasons explains why addr joined the list between from and to.
+func additionReasons(from, to *diffEpoch, addr string) []diffReason {
+	prev, cur := from.records[addr], to.records[addr]
+	var reasons []diffReason
+	for _, code := range blockers(prev, from.threshold) {
+		switch code {
+		case reasonMissing:
+			reasons = append(reasons, diffReason{reasonNew, "no identity record in the previous snapshot"})
+		case reasonState:
+			reasons = append(reasons, diffReason{reasonState, prev.State + " -> " + stateOf(cur)})
+		case reasonStakeBelow:
+			if cur != nil {
+				reasons = append(reasons, stakeReason(reasonStakeReached, prev, cur, to.threshold))
+			}
+		case reasonPenalty:
+			reasons = append(reasons, diffReason{reasonPenaltyCleared, "no penalty in the validation"})
+		case reasonFlip:
+			reasons = append(reasons, diffReason{reasonFlipCleared, "no flip report"})
+		}
+	}
+	return reasons
+}
+
+// computeWhitelistDiff lists the addresses added to and removed from the
+// whitelist between two epochs together with the causes.
+func computeWhitelistDiff(fromEpoch, toEpoch int) (*whitelistDiff, error) {
+	from, err := loadDiffEpoch(fromEpoch)
+	if err != nil {
+		return nil, err
+	}
+	to, err := loadDiffEpoch(toEpoch)
+	if err != nil {
+		return nil, err
+	}
+	d := &whitelistDiff{
+		From:          fromEpoch,
+		To:            toEpoch,
+		FromThreshold: from.threshold,
+		ToThreshold:   to.threshold,
+		Added:         []diffEntry{},
+		Removed:       []diffEntry{},
+	}
+	added, removed := diffAddresses(from.list, to.list)
+	entry := func(addr, change string, reasons []diffReason) diffEntry {
+		if len(reasons) == 0 {
+			reasons = []diffReason{{reasonUnknown, "identity records do not explain the change"}}
+		}
+		return diffEntry{Address: addr, Change: change, Reasons: reasons, From: from.records[addr], To: to.records[addr]}
+	}
+	for _, a := range added {
+		d.Added = append(d.Added, entry(a, "added", additionReasons(from, to, a)))
+	}
+	for _, a := range removed {
+		d.Removed = append(d.Removed, entry(a, "removed", removalReasons(from, to, a)))
+	}
+	return d, nil
+}
+
+// writeDiffCSV writes one row per changed address.
+func writeDiffCSV(w io.Writer, d *whitelistDiff) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"change", "address", "reasons", "details", "from_state", "from_stake", "to_state", "to_stake"})
+	record := func(rec *diffRecord) []string {
+		if rec == nil {
+			return []string{"", ""}
+		}
+		return []string{rec.State, strconv.FormatFloat(rec.Stake, 'f', -1, 64)}
+	}
+	for _, e := range append(append([]diffEntry(nil), d.Added...), d.Removed...) {
+		codes := make([]string, len(e.Reasons))
+		details := make([]string, len(e.Reasons))
+		for i, r := range e.Reasons {
+			codes[i], details[i] = r.Code, r.Detail
+		}
+		row := []string{e.Change, e.Address, strings.Join(codes, ";"), strings.Join(details, "; ")}
+		row = append(row, record(e.From)...)
+		cw.Write(append(row, record(e.To)...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// whitelistDiffHandler serves GET /whitelist/diff?from=N&to=M, as JSON or,
+// with format=csv, as a CSV attachment.
+func whitelistDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err1 := strconv.Atoi(q.Get("from"))
+	to, err2 := strconv.Atoi(q.Get("to"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "from and to epochs required", http.StatusBadRequest)
+		return
+	}
+	d, err := computeWhitelistDiff(from, to)
+	if errors.Is(err, errNoEpochData) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, d)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whitelist_diff_%d_%d.csv"`, from, to))
+		writeDiffCSV(w, d)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// runDiffCLI implements `diff [-format json|csv] <from> <to>`, printing the
+// whitelist diff of two epochs. It returns the exit code.
+func runDiffCLI(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format: json or csv")
+	dbPath := fs.String("db", dbFile, "SQLite database path")
+	dir := fs.String("data-dir", dataDir, "directory with whitelist files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	from, err1 := strconv.Atoi(fs.Arg(0))
+	to, err2 := strconv.Atoi(fs.Arg(1))
+	if fs.NArg() != 2 || err1 != nil || err2 != nil || (*format != "json" && *format != "csv") {
+		fmt.Fprintln(os.Stderr, "usage: diff [-format json|csv] [-db path] [-data-dir dir] <from epoch> <to epoch>")
+		return 2
+	}
+	var err error
+	if db, err = sql.Open("sqlite3", *dbPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	dataDir = *dir
+	d, err := computeWhitelistDiff(from, to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *format == "csv" {
+		err = writeDiffCSV(os.Stdout, d)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
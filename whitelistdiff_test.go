@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWhitelistDiffReasons(t *testing.T) {
+	setupTestDB(t)
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDir }()
+
+	prev := []EpochSnapshot{
+		{Address: "0xaaa", State: "Human", Stake: 15000},
+		{Address: "0xbbb", State: "Human", Stake: 15000},
+		{Address: "0xccc", State: "Verified", Stake: 12000},
+		{Address: "0xddd", State: "Newbie", Stake: 11000},
+		{Address: "0xeee", State: "Human", Stake: 9000},
+		{Address: "0xfff", State: "Human", Stake: 20000, Penalized: true},
+	}
+	next := []EpochSnapshot{
+		{Address: "0xaaa", State: "Suspended", Stake: 15000},
+		{Address: "0xbbb", State: "Human", Stake: 11000},
+		{Address: "0xccc", State: "Verified", Stake: 12000, FlipReported: true},
+		{Address: "0xeee", State: "Human", Stake: 13000},
+		{Address: "0xfff", State: "Human", Stake: 20000},
+		{Address: "0x111", State: "Newbie", Stake: 10000},
+	}
+	if _, _, err := finishEpochWhitelist(20, 12000, manifestSourceNode, "", blockRef{}, prev); err != nil {
+		t.Fatalf("epoch 20: %v", err)
+	}
+	if _, _, err := finishEpochWhitelist(21, 12000, manifestSourceNode, "", blockRef{}, next); err != nil {
+		t.Fatalf("epoch 21: %v", err)
+	}
+
+	d, err := computeWhitelistDiff(20, 21)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	codes := func(entries []diffEntry) map[string]string {
+		out := make(map[string]string)
+		for _, e := range entries {
+			var c []string
+			for _, r := range e.Reasons {
+				c = append(c, r.Code)
+			}
+			out[e.Address] = strings.Join(c, ",")
+		}
+		return out
+	}
+	wantRemoved := map[string]string{
+		"0xaaa": reasonState,
+		"0xbbb": reasonStakeBelow,
+		"0xccc": reasonFlip,
+		"0xddd": reasonMissing,
+	}
+	wantAdded := map[string]string{
+		"0xeee": reasonStakeReached,
+		"0xfff": reasonPenaltyCleared,
+		"0x111": reasonNew,
+	}
+	for name, c := range map[string]struct{ got, want map[string]string }{
+		"removed": {codes(d.Removed), wantRemoved},
+		"added":   {codes(d.Added), wantAdded},
+	} {
+		if len(c.got) != len(c.want) {
+			t.Fatalf("%s: got %v, want %v", name, c.got, c.want)
+		}
+		for a, code := range c.want {
+			if c.got[a] != code {
+				t.Fatalf("%s %s: got %q, want %q", name, a, c.got[a], code)
+			}
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	whitelistDiffHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/diff?from=20&to=21", nil))
+	var out whitelistDiff
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &out) != nil || len(out.Removed) != 4 || len(out.Added) != 3 {
+		t.Fatalf("json response %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	whitelistDiffHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/diff?from=20&to=21&format=csv", nil))
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(rows) != 8 || rows[0][0] != "change" {
+		t.Fatalf("csv response: %v %v", rows, err)
+	}
+
+	rr = httptest.NewRecorder()
+	whitelistDiffHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/diff?from=20&to=99", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown epoch, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	whitelistDiffHandler(rr, httptest.NewRequest(http.MethodGet, "/whitelist/diff?from=20", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without to, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,321 @@
+// Package wlformat reads and writes whitelist files.
+//
+// The canonical format is a versioned JSON document:
+//
+//	{
+//	  "format": "idena-whitelist",
+//	  "version": 1,
+//	  "epoch": 164,
+//	  "merkle_scheme": "sha256-legacy",
+//	  "merkle_root": "…",
+//	  "manifest": {…},
+//	  "attestation": {…},
+//	  "entries": [{"address": "0x…", "state": "Human", "stake": 15000, "flags": […]}]
+//	}
+//
+// The legacy formats produced by the server, the fetcher agent, the strict
+// builder and cmd/whitelistfilter are read and written as well, so files can
+// be converted between all of them.
+package wlformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Name and Version identify canonical documents.
+const (
+	Name    = "idena-whitelist"
+	Version = 1
+)
+
+// Format names a file layout.
+type Format string
+
+const (
+	// Canonical is the versioned format of this package.
+	Canonical Format = "canonical"
+	// Server is the {merkle_root, merkle_scheme, addresses} object written
+	// by the server to data/whitelist_epoch_N.json.
+	Server Format = "server"
+	// Array is a bare JSON array of addresses, as written by the fetcher
+	// agent and cmd/whitelistfilter.
+	Array Format = "array"
+	// IdentityJSONL is one identity object per line, as written by
+	// strictlocal.BuildWhitelist.
+	IdentityJSONL Format = "identity-jsonl"
+	// Lines is one address per line, as written by cmd/whitelistfilter
+	// -jsonl.
+	Lines Format = "lines"
+)
+
+// Formats lists every supported format.
+func Formats() []Format {
+	return []Format{Canonical, Server, Array, IdentityJSONL, Lines}
+}
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats() {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown whitelist format %q", name)
+}
+
+// Validation flags carried by entries. Penalties are not a validation flag
+// of the node, so they are recorded under their own name.
+const (
+	FlagPenalized    = "Penalized"
+	FlagFlipReported = "AtLeastOneFlipReported"
+)
+
+// Header describes a whitelist. Manifest and Attestation are kept verbatim.
+type Header struct {
+	Format      string          `json:"format"`
+	Version     int             `json:"version"`
+	Epoch       int             `json:"epoch"`
+	Scheme      string          `json:"merkle_scheme,omitempty"`
+	Root        string          `json:"merkle_root,omitempty"`
+	Manifest    json.RawMessage `json:"manifest,omitempty"`
+	Attestation json.RawMessage `json:"attestation,omitempty"`
+}
+
+// Entry is one whitelisted identity. Formats that only list addresses leave
+// the other fields empty.
+type Entry struct {
+	Address string   `json:"address"`
+	State   string   `json:"state,omitempty"`
+	Stake   float64  `json:"stake,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+// Whitelist is a parsed whitelist file.
+type Whitelist struct {
+	Header
+	Entries []Entry `json:"entries"`
+}
+
+// New returns an empty canonical whitelist for epoch.
+func New(epoch int) *Whitelist {
+	return &Whitelist{Header: Header{Format: Name, Version: Version, Epoch: epoch}, Entries: []Entry{}}
+}
+
+// Addresses returns the entry addresses in order.
+func (wl *Whitelist) Addresses() []string {
+	out := make([]string, len(wl.Entries))
+	for i, e := range wl.Entries {
+		out[i] = e.Address
+	}
+	return out
+}
+
+// HasFlag reports whether e carries flag.
+func (e Entry) HasFlag(flag string) bool {
+	for _, f := range e.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrEmpty is returned for files without any content.
+	ErrEmpty = errors.New("empty whitelist file")
+	// ErrUnsupportedVersion is returned for canonical documents newer than
+	// this package.
+	ErrUnsupportedVersion = errors.New("unsupported whitelist version")
+)
+
+// identityLine is the strictlocal.IdentityInfo record.
+type identityLine struct {
+	Address string   `json:"address"`
+	Stake   float64  `json:"stake"`
+	State   string   `json:"state"`
+	Penalty string   `json:"penalty"`
+	Flags   []string `json:"lastValidationFlags"`
+}
+
+// serverFile is the layout of the server's whitelist files.
+type serverFile struct {
+	MerkleRoot   string          `json:"merkle_root"`
+	MerkleScheme string          `json:"merkle_scheme,omitempty"`
+	Addresses    []string        `json:"addresses"`
+	Attestation  json.RawMessage `json:"attestation,omitempty"`
+}
+
+// Detect returns the format of data.
+func Detect(data []byte) (Format, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", ErrEmpty
+	}
+	switch data[0] {
+	case '[':
+		return Array, nil
+	case '{':
+		dec := json.NewDecoder(bytes.NewReader(data))
+		var obj map[string]json.RawMessage
+		if err := dec.Decode(&obj); err != nil {
+			return "", err
+		}
+		if dec.More() {
+			return IdentityJSONL, nil
+		}
+		if _, ok := obj["format"]; ok {
+			return Canonical, nil
+		}
+		if _, ok := obj["addresses"]; ok {
+			return Server, nil
+		}
+		if _, ok := obj["address"]; ok {
+			return IdentityJSONL, nil
+		}
+		return "", errors.New("unrecognized whitelist object")
+	}
+	return Lines, nil
+}
+
+// Read parses a whitelist in any supported format and reports the format.
+func Read(r io.Reader) (*Whitelist, Format, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return Parse(data)
+}
+
+// Parse parses a whitelist in any supported format and reports the format.
+// Legacy files are returned as canonical whitelists without an epoch.
+func Parse(data []byte) (*Whitelist, Format, error) {
+	f, err := Detect(data)
+	if err != nil {
+		return nil, "", err
+	}
+	wl := New(0)
+	switch f {
+	case Canonical:
+		if err := json.Unmarshal(data, wl); err != nil {
+			return nil, f, err
+		}
+		if wl.Format != Name {
+			return nil, f, fmt.Errorf("unknown document format %q", wl.Format)
+		}
+		if wl.Version < 1 || wl.Version > Version {
+			return nil, f, fmt.Errorf("%w %d", ErrUnsupportedVersion, wl.Version)
+		}
+	case Server:
+		var s serverFile
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, f, err
+		}
+		wl.Root, wl.Scheme, wl.Attestation = s.MerkleRoot, s.MerkleScheme, s.Attestation
+		wl.Entries = addressEntries(s.Addresses)
+	case Array:
+		var addrs []string
+		if err := json.Unmarshal(data, &addrs); err != nil {
+			return nil, f, err
+		}
+		wl.Entries = addressEntries(addrs)
+	case IdentityJSONL:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var id identityLine
+			if err := dec.Decode(&id); err != nil {
+				return nil, f, err
+			}
+			e := Entry{Address: id.Address, State: id.State, Stake: id.Stake, Flags: id.Flags}
+			if id.Penalty != "" && id.Penalty != "0" {
+				e.Flags = append(e.Flags, FlagPenalized)
+			}
+			wl.Entries = append(wl.Entries, e)
+		}
+	case Lines:
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			if strings.ContainsAny(line, " \t,\"") {
+				return nil, f, fmt.Errorf("invalid address line %q", line)
+			}
+			wl.Entries = append(wl.Entries, Entry{Address: line})
+		}
+		if err := sc.Err(); err != nil {
+			return nil, f, err
+		}
+	}
+	return wl, f, nil
+}
+
+func addressEntries(addrs []string) []Entry {
+	entries := make([]Entry, len(addrs))
+	for i, a := range addrs {
+		entries[i] = Entry{Address: a}
+	}
+	return entries
+}
+
+// Write writes wl in format f. Legacy formats drop what they cannot hold:
+// the server format keeps root, scheme and attestation, the identity lines
+// keep state, stake and flags, and the others only the addresses.
+func Write(w io.Writer, wl *Whitelist, f Format) error {
+	switch f {
+	case Canonical:
+		doc := *wl
+		doc.Format, doc.Version = Name, Version
+		if doc.Entries == nil {
+			doc.Entries = []Entry{}
+		}
+		return writeIndented(w, doc)
+	case Server:
+		return writeIndented(w, serverFile{
+			MerkleRoot:   wl.Root,
+			MerkleScheme: wl.Scheme,
+			Addresses:    wl.Addresses(),
+			Attestation:  wl.Attestation,
+		})
+	case Array:
+		return writeIndented(w, wl.Addresses())
+	case IdentityJSONL:
+		enc := json.NewEncoder(w)
+		for _, e := range wl.Entries {
+			id := identityLine{Address: e.Address, Stake: e.Stake, State: e.State, Penalty: "0"}
+			for _, fl := range e.Flags {
+				if fl == FlagPenalized {
+					id.Penalty = "1"
+				} else {
+					id.Flags = append(id.Flags, fl)
+				}
+			}
+			if err := enc.Encode(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Lines:
+		bw := bufio.NewWriter(w)
+		for _, e := range wl.Entries {
+			bw.WriteString(e.Address + "\n")
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("unknown whitelist format %q", f)
+}
+
+func writeIndented(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,100 @@
+package wlformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func sample() *Whitelist {
+	wl := New(164)
+	wl.Scheme, wl.Root = "sha256-legacy", "abc"
+	wl.Attestation = json.RawMessage(`{"epoch":164}`)
+	wl.Entries = []Entry{
+		{Address: "0xaaa", State: "Human", Stake: 15000},
+		{Address: "0xbbb", State: "Newbie", Stake: 10000, Flags: []string{FlagPenalized}},
+	}
+	return wl
+}
+
+func compact(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	json.Compact(&buf, raw)
+	return buf.String()
+}
+
+func TestDetect(t *testing.T) {
+	cases := map[string]Format{
+		`{"format":"idena-whitelist","version":1,"entries":[]}`: Canonical,
+		`{"merkle_root":"x","addresses":["0xa"]}`:               Server,
+		`["0xa","0xb"]`: Array,
+		"{\"address\":\"0xa\"}\n{\"address\":\"0xb\"}\n": IdentityJSONL,
+		`{"address":"0xa","state":"Human"}`:              IdentityJSONL,
+		"0xa\n0xb\n":                                     Lines,
+	}
+	for in, want := range cases {
+		got, err := Detect([]byte(in))
+		if err != nil || got != want {
+			t.Fatalf("Detect(%q) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+	if _, err := Detect([]byte("  \n")); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := sample()
+	for _, f := range Formats() {
+		var buf bytes.Buffer
+		if err := Write(&buf, src, f); err != nil {
+			t.Fatalf("%s: write: %v", f, err)
+		}
+		got, detected, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: parse: %v", f, err)
+		}
+		if detected != f {
+			t.Fatalf("%s: detected as %s", f, detected)
+		}
+		if !reflect.DeepEqual(got.Addresses(), src.Addresses()) {
+			t.Fatalf("%s: addresses %v", f, got.Addresses())
+		}
+		switch f {
+		case Canonical:
+			if got.Epoch != src.Epoch || got.Root != src.Root || got.Scheme != src.Scheme ||
+				compact(got.Attestation) != compact(src.Attestation) || !reflect.DeepEqual(got.Entries, src.Entries) {
+				t.Fatalf("canonical round trip lost data: %+v", got)
+			}
+		case Server:
+			if got.Root != src.Root || got.Scheme != src.Scheme || compact(got.Attestation) != compact(src.Attestation) {
+				t.Fatalf("server header lost: %+v", got.Header)
+			}
+		case IdentityJSONL:
+			if !reflect.DeepEqual(got.Entries, src.Entries) {
+				t.Fatalf("identity entries lost: %+v", got.Entries)
+			}
+		}
+	}
+}
+
+func TestParseStrictIdentityLine(t *testing.T) {
+	line := `{"address":"0xa","stake":12000,"state":"Human","penalty":"0","lastValidationFlags":["AtLeastOneFlipReported"]}`
+	wl, _, err := Parse([]byte(line))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	e := wl.Entries[0]
+	if e.Stake != 12000 || e.State != "Human" || !e.HasFlag(FlagFlipReported) || e.HasFlag(FlagPenalized) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
+
+func TestParseRejectsNewerVersion(t *testing.T) {
+	_, _, err := Parse([]byte(`{"format":"idena-whitelist","version":2,"entries":[]}`))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}